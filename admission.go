@@ -0,0 +1,559 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	ValidatingWebhooksGroupKind = "core/validatingwebhooks"
+	MutatingWebhooksGroupKind   = "core/mutatingwebhooks"
+)
+
+const (
+	OperationCreate  = "CREATE"
+	OperationReplace = "REPLACE"
+	OperationDelete  = "DELETE"
+)
+
+const (
+	// FailurePolicyFail refuses an operation if its admission webhook can not be called or does not answer in time.
+	FailurePolicyFail = "Fail"
+	// FailurePolicyIgnore lets an operation through if its admission webhook can not be called or does not answer in time.
+	FailurePolicyIgnore = "Ignore"
+)
+
+const (
+	defaultAdmissionTimeout = 10 * time.Second
+	maxAdmissionTimeout     = 30 * time.Second
+	maxAdmissionResponse    = 1 << 20
+)
+
+type AdmissionOptions struct {
+	Client *http.Client
+}
+
+// AdmissionReview is the body of admission webhook calls.
+type AdmissionReview struct {
+	UID       string      `json:"uid"`
+	Operation string      `json:"operation"`
+	GroupKind string      `json:"groupKind"`
+	ID        string      `json:"id"`
+	Object    GenericItem `json:"object,omitempty"`
+	OldObject GenericItem `json:"oldObject,omitempty"`
+	DryRun    bool        `json:"dryRun,omitempty"`
+	Actor     string      `json:"actor,omitempty"`
+}
+
+// AdmissionResponse is expected from admission webhooks. Patch is a JSON patch, only applied for mutating webhooks.
+type AdmissionResponse struct {
+	Allowed    bool                     `json:"allowed"`
+	StatusCode int                      `json:"statusCode,omitempty"`
+	Reason     string                   `json:"reason,omitempty"`
+	Patch      []map[string]interface{} `json:"patch,omitempty"`
+}
+
+type AdmissionWebhookError struct {
+	Webhook string
+	Reason  string
+}
+
+func (err AdmissionWebhookError) Error() string {
+	return fmt.Sprintf("admission webhook '%s' failed: %s", err.Webhook, err.Reason)
+}
+
+// Admission calls admission webhooks before writes, like the admission control of Kubernetes.
+// Webhooks are registered as items of MutatingWebhooksGroupKind and ValidatingWebhooksGroupKind with a url,
+// the kinds they admit as "group/kind", and optionally the operations they admit, an optional secret to sign
+// requests with, timeoutSeconds and a failurePolicy. Secrets are kept out of the webhooks like those of Webhooks.
+//
+// Mutating webhooks are called first, one after the other, and may change the item by responding with a JSON patch.
+// Validating webhooks then get the final item. Any of them may refuse the operation.
+// Webhooks are called before the write, so Admission should be the outermost decorator.
+//
+// Webhooks are never called within a transaction, as it would hold the store while they answer. Batches and bulk
+// operations admit their writes ahead, see preadmit, and other writes within a transaction are refused if they
+// have webhooks.
+type Admission struct {
+	next Service
+	opts AdmissionOptions
+}
+
+func (a *Admission) List(ctx context.Context, groupKind string) ([]GenericItem, error) {
+	return a.next.List(ctx, groupKind)
+}
+
+func (a *Admission) Create(ctx context.Context, groupKind string, req GenericItem) error {
+	if groupKind == ValidatingWebhooksGroupKind || groupKind == MutatingWebhooksGroupKind {
+		err := validateAdmissionWebhook(req)
+		if err != nil || isPreadmitting(ctx) {
+			return err
+		}
+
+		secret, hasSecret := takeWebhookSecret(req)
+
+		return inTx(ctx, a.next, func(ctx context.Context) error {
+			err := a.next.Create(ctx, groupKind, req)
+			if err != nil || !hasSecret {
+				return err
+			}
+
+			return saveWebhookSecret(ctx, a.next, groupKind, req.GetID(), secret)
+		})
+	}
+
+	err := a.admitOnce(ctx, OperationCreate, groupKind, req.GetID(), req, nil)
+	if err != nil || isPreadmitting(ctx) {
+		return err
+	}
+
+	return a.next.Create(ctx, groupKind, req)
+}
+
+func (a *Admission) Read(ctx context.Context, groupKind string, id string) (GenericItem, error) {
+	return a.next.Read(ctx, groupKind, id)
+}
+
+func (a *Admission) Replace(ctx context.Context, groupKind string, id string, req GenericItem) error {
+	if groupKind == ValidatingWebhooksGroupKind || groupKind == MutatingWebhooksGroupKind {
+		err := validateAdmissionWebhook(req)
+		if err != nil || isPreadmitting(ctx) {
+			return err
+		}
+
+		secret, hasSecret := takeWebhookSecret(req)
+
+		return inTx(ctx, a.next, func(ctx context.Context) error {
+			err := a.next.Replace(ctx, groupKind, id, req)
+			if err != nil || !hasSecret {
+				return err
+			}
+
+			return saveWebhookSecret(ctx, a.next, groupKind, id, secret)
+		})
+	}
+
+	old, err := a.next.Read(ctx, groupKind, id)
+	if err != nil {
+		return err
+	}
+
+	err = a.admitOnce(ctx, OperationReplace, groupKind, id, req, old)
+	if err != nil || isPreadmitting(ctx) {
+		return err
+	}
+
+	return a.next.Replace(ctx, groupKind, id, req)
+}
+
+func (a *Admission) Delete(ctx context.Context, groupKind string, id string) error {
+	if groupKind == ValidatingWebhooksGroupKind || groupKind == MutatingWebhooksGroupKind {
+		if isPreadmitting(ctx) {
+			return nil
+		}
+
+		return inTx(ctx, a.next, func(ctx context.Context) error {
+			err := a.next.Delete(ctx, groupKind, id)
+			if err != nil {
+				return err
+			}
+
+			// webhooks with finalizers are only marked for deletion
+			_, err = a.next.Read(ctx, groupKind, id)
+			if !errors.As(err, &ItemNotFoundError{}) {
+				return err
+			}
+
+			return saveWebhookSecret(ctx, a.next, groupKind, id, "")
+		})
+	}
+
+	old, err := a.next.Read(ctx, groupKind, id)
+	if err != nil {
+		return err
+	}
+
+	err = a.admitOnce(ctx, OperationDelete, groupKind, id, nil, old)
+	if err != nil || isPreadmitting(ctx) {
+		return err
+	}
+
+	return a.next.Delete(ctx, groupKind, id)
+}
+
+func (a *Admission) Unwrap() Service {
+	return a.next
+}
+
+var _ Service = new(Admission)
+
+func validateAdmissionWebhook(item GenericItem) error {
+	err := validateWebhook(item)
+	if err != nil {
+		return err
+	}
+
+	if v, ok := item["operations"]; ok {
+		operations, ok := v.([]interface{})
+		if !ok {
+			return FieldError{Field: "operations", Reason: "must be a list of CREATE, REPLACE or DELETE"}
+		}
+
+		for i := range operations {
+			if operations[i] != OperationCreate && operations[i] != OperationReplace && operations[i] != OperationDelete {
+				return FieldError{Field: "operations", Reason: "must be a list of CREATE, REPLACE or DELETE"}
+			}
+		}
+	}
+
+	if v, ok := item["timeoutSeconds"]; ok {
+		seconds, ok := toInt64(normalizeJSONValue(v))
+		if !ok || seconds < 1 || time.Duration(seconds)*time.Second > maxAdmissionTimeout {
+			return FieldError{Field: "timeoutSeconds", Reason: fmt.Sprintf("must be between 1 and %d", int(maxAdmissionTimeout.Seconds()))}
+		}
+	}
+
+	if v, ok := item["failurePolicy"]; ok && v != FailurePolicyFail && v != FailurePolicyIgnore {
+		return FieldError{Field: "failurePolicy", Reason: "must be Fail or Ignore"}
+	}
+
+	return nil
+}
+
+func admissionWebhookAdmits(webhook GenericItem, operation string, groupKind string) bool {
+	if disabled, _ := webhook["disabled"].(bool); disabled {
+		return false
+	}
+
+	kinds, _ := webhook["kinds"].([]interface{})
+	if !containsValue(kinds, groupKind) {
+		return false
+	}
+
+	operations, ok := webhook["operations"].([]interface{})
+
+	return !ok || containsValue(operations, operation)
+}
+
+func (a *Admission) webhooksOf(ctx context.Context, webhooksGroupKind string, operation string, groupKind string) ([]GenericItem, error) {
+	webhooks, err := a.next.List(ctx, webhooksGroupKind)
+	if err != nil {
+		if errors.As(err, &GroupKindNotFoundError{}) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	res := make([]GenericItem, 0, len(webhooks))
+
+	for i := range webhooks {
+		if admissionWebhookAdmits(webhooks[i], operation, groupKind) {
+			res = append(res, webhooks[i])
+		}
+	}
+
+	return res, nil
+}
+
+// admitted holds the admissions of the writes of a transaction, made ahead of it by preadmit.
+type admitted struct {
+	preadmitting bool
+
+	mu      sync.Mutex
+	records []admission
+}
+
+type admission struct {
+	operation string
+	groupKind string
+	id        string
+	item      GenericItem
+	old       GenericItem
+	res       GenericItem
+	err       error
+}
+
+type admittedKey struct{}
+
+func (adm *admitted) add(record admission) {
+	adm.mu.Lock()
+	defer adm.mu.Unlock()
+
+	adm.records = append(adm.records, record)
+}
+
+// take removes and returns the admission of an operation on the same item and the same old item.
+func (adm *admitted) take(operation string, groupKind string, id string, item, old GenericItem) (admission, bool) {
+	adm.mu.Lock()
+	defer adm.mu.Unlock()
+
+	for i, record := range adm.records {
+		if record.operation != operation || record.groupKind != groupKind || record.id != id {
+			continue
+		}
+
+		if !reflect.DeepEqual(normalizeJSONValue(record.item), normalizeJSONValue(item)) ||
+			!reflect.DeepEqual(normalizeJSONValue(record.old), normalizeJSONValue(old)) {
+			continue
+		}
+
+		adm.records = append(adm.records[:i], adm.records[i+1:]...)
+
+		return record, true
+	}
+
+	return admission{}, false
+}
+
+func isPreadmitting(ctx context.Context) bool {
+	adm, ok := ctx.Value(admittedKey{}).(*admitted)
+
+	return ok && adm.preadmitting
+}
+
+// preadmit calls the admission webhooks of the writes fn makes ahead, by running fn once without writing anything.
+// The returned context lets fn then run in a transaction, without calling webhooks while it holds the store.
+// Writes which turn out different in the transaction, as the items changed meanwhile, are refused if they have
+// webhooks.
+func preadmit(ctx context.Context, svc Service, fn func(ctx context.Context) error) context.Context {
+	if _, ok := Lookup[*Admission](svc); !ok || inTransaction(ctx) {
+		return ctx
+	}
+
+	adm := &admitted{preadmitting: true}
+
+	_ = fn(context.WithValue(ctx, admittedKey{}, adm))
+
+	return context.WithValue(ctx, admittedKey{}, &admitted{records: adm.records})
+}
+
+// admitOnce admits an operation, unless it was admitted ahead of its transaction.
+func (a *Admission) admitOnce(ctx context.Context, operation string, groupKind string, id string, item, old GenericItem) error {
+	adm, _ := ctx.Value(admittedKey{}).(*admitted)
+
+	switch {
+	case adm != nil && adm.preadmitting:
+		in := item.DeepCopy()
+
+		err := a.admit(ctx, operation, groupKind, id, item, old)
+
+		adm.add(admission{operation: operation, groupKind: groupKind, id: id, item: in, old: old, res: item.DeepCopy(), err: err})
+
+		return err
+	case adm != nil:
+		if record, ok := adm.take(operation, groupKind, id, item, old); ok {
+			if record.err != nil {
+				return record.err
+			}
+
+			for k := range item {
+				delete(item, k)
+			}
+
+			for k := range record.res {
+				item[k] = record.res[k]
+			}
+
+			return nil
+		}
+	}
+
+	if !inTransaction(ctx) {
+		return a.admit(ctx, operation, groupKind, id, item, old)
+	}
+
+	webhooksGroupKinds := []string{MutatingWebhooksGroupKind, ValidatingWebhooksGroupKind}
+	if operation == OperationDelete {
+		webhooksGroupKinds = webhooksGroupKinds[1:]
+	}
+
+	for _, webhooksGroupKind := range webhooksGroupKinds {
+		webhooks, err := a.webhooksOf(ctx, webhooksGroupKind, operation, groupKind)
+		if err != nil {
+			return err
+		}
+
+		if len(webhooks) > 0 {
+			return AdmissionWebhookError{Webhook: webhooks[0].GetID(), Reason: "can not be called within a transaction"}
+		}
+	}
+
+	return nil
+}
+
+// admit calls the admission webhooks of an operation. Mutating webhooks change item in place.
+func (a *Admission) admit(ctx context.Context, operation string, groupKind string, id string, item, old GenericItem) error {
+	if operation != OperationDelete {
+		mutating, err := a.webhooksOf(ctx, MutatingWebhooksGroupKind, operation, groupKind)
+		if err != nil {
+			return err
+		}
+
+		for i := range mutating {
+			res, err := a.review(ctx, MutatingWebhooksGroupKind, mutating[i], operation, groupKind, id, item, old)
+			if err != nil {
+				return err
+			}
+
+			if res == nil || len(res.Patch) == 0 {
+				continue
+			}
+
+			patched, err := ApplyJSONPatch(item.DeepCopy(), res.Patch)
+			if err != nil {
+				return AdmissionWebhookError{Webhook: mutating[i].GetID(), Reason: err.Error()}
+			}
+
+			var p map[string]interface{}
+
+			switch patched := patched.(type) {
+			case GenericItem:
+				p = patched
+			case map[string]interface{}:
+				p = patched
+			}
+
+			if p == nil || p["id"] != item["id"] {
+				return AdmissionWebhookError{Webhook: mutating[i].GetID(), Reason: "patch must keep the item and its id"}
+			}
+
+			for k := range item {
+				delete(item, k)
+			}
+
+			for k := range p {
+				item[k] = p[k]
+			}
+		}
+	}
+
+	validating, err := a.webhooksOf(ctx, ValidatingWebhooksGroupKind, operation, groupKind)
+	if err != nil {
+		return err
+	}
+
+	for i := range validating {
+		_, err := a.review(ctx, ValidatingWebhooksGroupKind, validating[i], operation, groupKind, id, item, old)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// review calls webhook. It returns a nil response if the call failed and the failure policy of webhook ignores it.
+func (a *Admission) review(ctx context.Context, webhooksGroupKind string, webhook GenericItem, operation string, groupKind string, id string, item, old GenericItem) (*AdmissionResponse, error) {
+	res, err := a.call(ctx, webhooksGroupKind, webhook, AdmissionReview{
+		UID:       uuid.NewString(),
+		Operation: operation,
+		GroupKind: groupKind,
+		ID:        id,
+		Object:    item,
+		OldObject: old,
+		DryRun:    IsDryRun(ctx),
+		Actor:     ActorFromContext(ctx),
+	})
+	if err != nil {
+		if webhook["failurePolicy"] == FailurePolicyIgnore {
+			return nil, nil
+		}
+
+		return nil, AdmissionWebhookError{Webhook: webhook.GetID(), Reason: err.Error()}
+	}
+
+	if !res.Allowed {
+		status := res.StatusCode
+		if status == 0 {
+			status = http.StatusForbidden
+		}
+
+		reason := res.Reason
+		if reason == "" {
+			reason = "denied"
+		}
+
+		return nil, VetoError{StatusCode: status, Reason: fmt.Sprintf("admission webhook '%s': %s", webhook.GetID(), reason)}
+	}
+
+	return res, nil
+}
+
+func (a *Admission) call(ctx context.Context, webhooksGroupKind string, webhook GenericItem, review AdmissionReview) (*AdmissionResponse, error) {
+	timeout := defaultAdmissionTimeout
+	if seconds, ok := toInt64(normalizeJSONValue(webhook["timeoutSeconds"])); ok {
+		timeout = time.Duration(seconds) * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	body, err := json.Marshal(review)
+	if err != nil {
+		return nil, err
+	}
+
+	target, _ := webhook["url"].(string)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp := time.Now().Unix()
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookIDHeader, webhook.GetID())
+	req.Header.Set(WebhookDeliveryHeader, review.UID)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+
+	secret, err := webhookSecret(ctx, a.next, webhooksGroupKind, webhook.GetID())
+	if err != nil {
+		return nil, err
+	}
+
+	if secret != "" {
+		req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(secret, timestamp, body))
+	}
+
+	res, err := a.opts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = res.Body.Close() }()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+
+	var rsp AdmissionResponse
+
+	err = json.NewDecoder(io.LimitReader(res.Body, maxAdmissionResponse)).Decode(&rsp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+
+	return &rsp, nil
+}
+
+func NewAdmission(next Service, opts AdmissionOptions) *Admission {
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: maxAdmissionTimeout}
+	}
+
+	return &Admission{
+		next: next,
+		opts: opts,
+	}
+}
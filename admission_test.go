@@ -0,0 +1,230 @@
+package core_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/applicaset/core"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var _ = Describe("Admission", func() {
+	var (
+		svc     core.Service
+		h       *core.Handler
+		server  *httptest.Server
+		mu      sync.Mutex
+		reviews []core.AdmissionReview
+		signed  []bool
+		respond map[string]func(review core.AdmissionReview) (int, core.AdmissionResponse)
+	)
+
+	register := func(kind string, body string) {
+		res := doRequest(h, http.MethodPost, "/core/"+kind, body)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+	}
+
+	BeforeEach(func() {
+		reviews = nil
+		signed = nil
+		respond = make(map[string]func(review core.AdmissionReview) (int, core.AdmissionResponse))
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			var review core.AdmissionReview
+
+			Expect(json.Unmarshal(body, &review)).Should(Succeed())
+
+			timestamp, _ := strconv.ParseInt(r.Header.Get(core.WebhookTimestampHeader), 10, 64)
+
+			mu.Lock()
+			reviews = append(reviews, review)
+			signed = append(signed, r.Header.Get(core.WebhookSignatureHeader) == core.SignWebhookPayload("s3cr3t", timestamp, body))
+			fn := respond[r.URL.Path]
+			mu.Unlock()
+
+			status, res := fn(review)
+
+			w.WriteHeader(status)
+			_ = json.NewEncoder(w).Encode(res)
+		}))
+
+		svc = core.NewStore()
+		svc = core.NewAutoFields(svc)
+		svc = core.NewAdmission(svc, core.AdmissionOptions{})
+
+		h = core.NewHandler(svc)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should apply patches of mutating webhooks before validating", func() {
+		respond["/mutate"] = func(review core.AdmissionReview) (int, core.AdmissionResponse) {
+			return http.StatusOK, core.AdmissionResponse{
+				Allowed: true,
+				Patch:   []map[string]interface{}{{"op": "add", "path": "/status", "value": "new"}},
+			}
+		}
+
+		respond["/validate"] = func(review core.AdmissionReview) (int, core.AdmissionResponse) {
+			if _, ok := review.Object["total"]; !ok {
+				return http.StatusOK, core.AdmissionResponse{Allowed: false, StatusCode: http.StatusUnprocessableEntity, Reason: "total is required"}
+			}
+
+			return http.StatusOK, core.AdmissionResponse{Allowed: true}
+		}
+
+		register("mutatingwebhooks", `{"id":"m1","url":"`+server.URL+`/mutate","kinds":["acme/orders"],"operations":["CREATE"]}`)
+		register("validatingwebhooks", `{"id":"v1","url":"`+server.URL+`/validate","kinds":["acme/orders"]}`)
+
+		res := doRequest(h, http.MethodPost, "/acme/orders", `{"id":"o1"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+		Expect(decodeBody(res)["error"]).Should(ContainSubstring("total is required"))
+
+		res = doRequest(h, http.MethodPost, "/acme/orders", `{"id":"o1","total":10}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+		Expect(decodeBody(res)["status"]).Should(Equal("new"))
+
+		Expect(reviews).Should(HaveLen(4))
+		Expect(reviews[3].Operation).Should(Equal(core.OperationCreate))
+		Expect(reviews[3].Object["status"]).Should(Equal("new"))
+
+		res = doRequest(h, http.MethodPut, "/acme/orders/o1", `{"id":"o1","total":20}`)
+		Expect(res.StatusCode).Should(BeNumerically("<", 300))
+
+		// the mutating webhook only admits creates
+		Expect(reviews).Should(HaveLen(5))
+		Expect(reviews[4].Operation).Should(Equal(core.OperationReplace))
+		Expect(reviews[4].OldObject["total"]).Should(BeEquivalentTo(10))
+
+		res = doRequest(h, http.MethodGet, "/acme/orders/o1", "")
+		Expect(decodeBody(res)).ShouldNot(HaveKey("status"))
+
+		res = doRequest(h, http.MethodDelete, "/acme/orders/o1", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+		Expect(reviews[5].Operation).Should(Equal(core.OperationDelete))
+		Expect(reviews[5].Object).Should(BeNil())
+	})
+
+	It("should enforce timeouts with the failure policy", func() {
+		respond["/slow"] = func(review core.AdmissionReview) (int, core.AdmissionResponse) {
+			time.Sleep(1500 * time.Millisecond)
+
+			return http.StatusOK, core.AdmissionResponse{Allowed: false}
+		}
+
+		respond["/broken"] = func(review core.AdmissionReview) (int, core.AdmissionResponse) {
+			return http.StatusInternalServerError, core.AdmissionResponse{}
+		}
+
+		register("validatingwebhooks", `{"id":"v1","url":"`+server.URL+`/slow","kinds":["acme/orders"],"timeoutSeconds":1,"failurePolicy":"Ignore"}`)
+		register("validatingwebhooks", `{"id":"v2","url":"`+server.URL+`/broken","kinds":["acme/invoices"]}`)
+
+		res := doRequest(h, http.MethodPost, "/acme/orders", `{"id":"o1"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		res = doRequest(h, http.MethodPost, "/acme/invoices", `{"id":"i1"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusBadGateway))
+		Expect(decodeBody(res)["message"]).Should(Equal("Admission webhook failed"))
+
+		res = doRequest(h, http.MethodGet, "/acme/invoices/i1", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNotFound))
+	})
+
+	It("should admit writes of transactions ahead of them", func() {
+		respond["/mutate"] = func(review core.AdmissionReview) (int, core.AdmissionResponse) {
+			// webhooks which read back would time out if the store was held while they are called
+			res := doRequest(h, http.MethodGet, "/acme/invoices", "")
+			Expect(res.StatusCode).Should(BeNumerically("<", 500))
+
+			return http.StatusOK, core.AdmissionResponse{
+				Allowed: true,
+				Patch:   []map[string]interface{}{{"op": "add", "path": "/status", "value": "new"}},
+			}
+		}
+
+		respond["/validate"] = func(review core.AdmissionReview) (int, core.AdmissionResponse) {
+			return http.StatusOK, core.AdmissionResponse{Allowed: review.ID != "o3"}
+		}
+
+		register("mutatingwebhooks", `{"id":"m1","url":"`+server.URL+`/mutate","kinds":["acme/orders"],"timeoutSeconds":1}`)
+		register("validatingwebhooks", `{"id":"v1","url":"`+server.URL+`/validate","kinds":["acme/orders"],"timeoutSeconds":1}`)
+
+		res := doRequest(h, http.MethodPost, "/_batch", `{"atomic":true,"operations":[
+			{"op":"create","group":"acme","kind":"orders","body":{"id":"o1"}},
+			{"op":"create","group":"acme","kind":"orders","body":{"id":"o2"}},
+			{"op":"create","group":"acme","kind":"orders","body":{"id":"o3"}}
+		]}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+
+		body := decodeBody(res)
+		Expect(body).Should(HaveKeyWithValue("rolledBack", true))
+		Expect(body["results"]).Should(HaveLen(3))
+		Expect(body["results"].([]interface{})[2]).Should(HaveKeyWithValue("status", float64(http.StatusForbidden)))
+
+		res = doRequest(h, http.MethodPost, "/_batch", `{"atomic":true,"operations":[
+			{"op":"create","group":"acme","kind":"orders","body":{"id":"o1"}},
+			{"op":"create","group":"acme","kind":"orders","body":{"id":"o2"}}
+		]}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+		Expect(decodeBody(res)).ShouldNot(HaveKey("rolledBack"))
+
+		res = doRequest(h, http.MethodGet, "/acme/orders/o2", "")
+		Expect(decodeBody(res)).Should(HaveKeyWithValue("status", "new"))
+
+		res = doRequest(h, http.MethodDelete, "/acme/orders?filter="+url.QueryEscape(`status == "new"`), "")
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+		Expect(decodeBody(res)).Should(HaveKeyWithValue("count", float64(2)))
+
+		// writes within other transactions are refused, as they could not be admitted ahead
+		err := core.Tx(context.Background(), svc, func(ctx context.Context) error {
+			return svc.Create(ctx, "acme/orders", core.GenericItem{"id": "o4"})
+		})
+		Expect(err).Should(MatchError(ContainSubstring("within a transaction")))
+	})
+
+	It("should keep secrets out of webhooks", func() {
+		respond["/validate"] = func(review core.AdmissionReview) (int, core.AdmissionResponse) {
+			return http.StatusOK, core.AdmissionResponse{Allowed: true}
+		}
+
+		register("validatingwebhooks", `{"id":"v1","url":"`+server.URL+`/validate","kinds":["acme/orders"],"secret":"s3cr3t"}`)
+
+		res := doRequest(h, http.MethodGet, "/core/validatingwebhooks/v1", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+		Expect(decodeBody(res)).ShouldNot(HaveKey("secret"))
+
+		res = doRequest(h, http.MethodPost, "/acme/orders", `{"id":"o1"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		res = doRequest(h, http.MethodPut, "/core/validatingwebhooks/v1", `{"id":"v1","url":"`+server.URL+`/validate","kinds":["acme/orders"],"secret":""}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusNoContent))
+
+		res = doRequest(h, http.MethodPost, "/acme/orders", `{"id":"o2"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		Expect(signed).Should(Equal([]bool{true, false}))
+	})
+
+	It("should validate webhook configurations", func() {
+		res := doRequest(h, http.MethodPost, "/core/validatingwebhooks", `{"id":"v1","url":"`+server.URL+`","kinds":["acme/orders"],"failurePolicy":"Maybe"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+
+		res = doRequest(h, http.MethodPost, "/core/mutatingwebhooks", `{"id":"m1","url":"`+server.URL+`","kinds":["acme/orders"],"timeoutSeconds":60}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+
+		res = doRequest(h, http.MethodPost, "/core/mutatingwebhooks", `{"id":"m1","url":"`+server.URL+`","kinds":["acme/orders"],"operations":["READ"]}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+	})
+})
@@ -0,0 +1,321 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const ApplyPatchType = "application/apply-patch+json"
+
+// systemFields are maintained by the server and never owned by a field manager.
+var systemFields = map[string]bool{
+	"id":                true,
+	"uuid":              true,
+	"group":             true,
+	"kind":              true,
+	"createdAt":         true,
+	"updatedAt":         true,
+	"resourceVersion":   true,
+	"managedFields":     true,
+	"deletionTimestamp": true,
+}
+
+type ManagedFieldsEntry struct {
+	Manager   string   `json:"manager"`
+	Operation string   `json:"operation"`
+	Time      string   `json:"time"`
+	Fields    []string `json:"fields"`
+}
+
+type ApplyConflict struct {
+	Manager string `json:"manager"`
+	Field   string `json:"field"`
+}
+
+type ApplyConflictError struct {
+	Conflicts []ApplyConflict
+}
+
+func (err ApplyConflictError) Error() string {
+	res := make([]string, len(err.Conflicts))
+	for i := range err.Conflicts {
+		res[i] = fmt.Sprintf("'%s' managed by '%s'", err.Conflicts[i].Field, err.Conflicts[i].Manager)
+	}
+
+	return fmt.Sprintf("apply conflicts with %s", strings.Join(res, ", "))
+}
+
+func GetManagedFields(item GenericItem) []ManagedFieldsEntry {
+	entries, _ := item["managedFields"].([]interface{})
+
+	res := make([]ManagedFieldsEntry, 0, len(entries))
+
+	for i := range entries {
+		m, ok := entries[i].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		var entry ManagedFieldsEntry
+		entry.Manager, _ = m["manager"].(string)
+		entry.Operation, _ = m["operation"].(string)
+		entry.Time, _ = m["time"].(string)
+
+		fields, _ := m["fields"].([]interface{})
+		for j := range fields {
+			if s, ok := fields[j].(string); ok {
+				entry.Fields = append(entry.Fields, s)
+			}
+		}
+
+		if entry.Manager != "" {
+			res = append(res, entry)
+		}
+	}
+
+	return res
+}
+
+func setManagedFields(item GenericItem, entries []ManagedFieldsEntry) {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Manager < entries[j].Manager })
+
+	res := make([]interface{}, 0, len(entries))
+
+	for i := range entries {
+		if len(entries[i].Fields) == 0 {
+			continue
+		}
+
+		fields := make([]interface{}, len(entries[i].Fields))
+		for j := range entries[i].Fields {
+			fields[j] = entries[i].Fields[j]
+		}
+
+		res = append(res, map[string]interface{}{
+			"manager":   entries[i].Manager,
+			"operation": entries[i].Operation,
+			"time":      entries[i].Time,
+			"fields":    fields,
+		})
+	}
+
+	if len(res) == 0 {
+		delete(item, "managedFields")
+
+		return
+	}
+
+	item["managedFields"] = res
+}
+
+// Apply merges the fields of config into the stored item on behalf of manager, creating the item if it does not exist.
+// Fields the manager applied before but left out of config are removed, unless another manager owns them too.
+// Setting a field owned by another manager to a different value fails with ApplyConflictError, unless force is set,
+// in which case the ownership is transferred. Like Upsert, it never creates an item when the request carries
+// If-Match preconditions.
+func Apply(ctx context.Context, svc Service, groupKind string, id string, manager string, config GenericItem, force bool) (res GenericItem, created bool, err error) {
+	if cid, ok := config["id"]; ok && cid != id {
+		return nil, false, InvalidPatchError{Reason: "id can not be changed"}
+	}
+
+	for i := 0; i < maxConflictRetries; i++ {
+		res, err = Update(ctx, svc, groupKind, id, func(item GenericItem) (GenericItem, error) {
+			return applyConfig(item, manager, config, force)
+		})
+		if err == nil || !(errors.As(err, &ItemNotFoundError{}) || errors.As(err, &GroupKindNotFoundError{})) {
+			return res, false, err
+		}
+
+		if PreconditionsFromContext(ctx).IfMatch != nil {
+			return nil, false, PreconditionFailedError{ID: id}
+		}
+
+		res, err = applyConfig(GenericItem{"id": id}, manager, config, force)
+		if err != nil {
+			return nil, false, err
+		}
+
+		err = svc.Create(ctx, groupKind, res)
+		if err == nil || !errors.As(err, &ItemExistsError{}) {
+			return res, err == nil, err
+		}
+	}
+
+	return nil, false, ConflictError{ID: id}
+}
+
+func applyConfig(item GenericItem, manager string, config GenericItem, force bool) (GenericItem, error) {
+	fields := managedPaths(config)
+	entries := GetManagedFields(item)
+
+	var conflicts []ApplyConflict
+
+	for i := range entries {
+		if entries[i].Manager == manager {
+			continue
+		}
+
+		kept := entries[i].Fields[:0]
+
+		for _, owned := range entries[i].Fields {
+			overlap := false
+
+			for _, field := range fields {
+				if !pathsOverlap(owned, field) {
+					continue
+				}
+
+				current, _ := jsonPointerGet(item, splitPath(field))
+				applied, _ := jsonPointerGet(config, splitPath(field))
+
+				if !reflect.DeepEqual(normalizeJSONValue(current), normalizeJSONValue(applied)) {
+					overlap = true
+
+					if !force {
+						conflicts = append(conflicts, ApplyConflict{Manager: entries[i].Manager, Field: field})
+					}
+				}
+			}
+
+			if !overlap {
+				kept = append(kept, owned)
+			}
+		}
+
+		entries[i].Fields = kept
+	}
+
+	if len(conflicts) > 0 {
+		return nil, ApplyConflictError{Conflicts: conflicts}
+	}
+
+	var previous []string
+
+	res := entries[:0]
+	for i := range entries {
+		if entries[i].Manager == manager {
+			previous = entries[i].Fields
+
+			continue
+		}
+
+		res = append(res, entries[i])
+	}
+
+	for _, field := range previous {
+		if containsPath(fields, field) || ownedByAny(res, field) {
+			continue
+		}
+
+		_, _ = jsonPointerRemove(item, splitPath(field))
+	}
+
+	for _, field := range fields {
+		value, _ := jsonPointerGet(config, splitPath(field))
+
+		setPath(item, splitPath(field), deepCopyValue(value))
+	}
+
+	if rv, ok := config["resourceVersion"]; ok {
+		item["resourceVersion"] = rv
+	}
+
+	res = append(res, ManagedFieldsEntry{
+		Manager:   manager,
+		Operation: "Apply",
+		Time:      time.Now().Format(time.RFC3339),
+		Fields:    fields,
+	})
+
+	setManagedFields(item, res)
+
+	return item, nil
+}
+
+// managedPaths returns json pointers to leaves of config. Arrays and empty objects are leaves themselves.
+func managedPaths(config GenericItem) []string {
+	var res []string
+
+	var walk func(prefix string, v interface{})
+	walk = func(prefix string, v interface{}) {
+		m, ok := v.(map[string]interface{})
+		if !ok || len(m) == 0 {
+			res = append(res, prefix)
+
+			return
+		}
+
+		for k := range m {
+			walk(prefix+"/"+escapePathToken(k), m[k])
+		}
+	}
+
+	for k := range config {
+		if systemFields[k] {
+			continue
+		}
+
+		walk("/"+escapePathToken(k), config[k])
+	}
+
+	sort.Strings(res)
+
+	return res
+}
+
+func escapePathToken(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+func splitPath(path string) []string {
+	tokens, _ := parseJSONPointer(path)
+
+	return tokens
+}
+
+func pathsOverlap(a, b string) bool {
+	return a == b || strings.HasPrefix(a, b+"/") || strings.HasPrefix(b, a+"/")
+}
+
+func containsPath(paths []string, path string) bool {
+	for i := range paths {
+		if paths[i] == path {
+			return true
+		}
+	}
+
+	return false
+}
+
+func ownedByAny(entries []ManagedFieldsEntry, path string) bool {
+	for i := range entries {
+		for j := range entries[i].Fields {
+			if pathsOverlap(entries[i].Fields[j], path) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func setPath(item GenericItem, tokens []string, value interface{}) {
+	node := map[string]interface{}(item)
+
+	for i := 0; i < len(tokens)-1; i++ {
+		child, ok := node[tokens[i]].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			node[tokens[i]] = child
+		}
+
+		node = child
+	}
+
+	node[tokens[len(tokens)-1]] = value
+}
@@ -0,0 +1,100 @@
+package core_test
+
+import (
+	"bytes"
+	"github.com/applicaset/core"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+)
+
+var _ = Describe("Server-side apply", Ordered, func() {
+	var svc core.Service
+	svc = core.NewStore()
+	svc = core.NewAutoFields(svc)
+
+	h := core.NewHandler(svc)
+
+	apply := func(query, body string) *http.Response {
+		req := httptest.NewRequest(http.MethodPatch, "/acme/deployments/web?"+query, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", core.ApplyPatchType)
+
+		w := httptest.NewRecorder()
+
+		h.ServeHTTP(w, req)
+
+		return w.Result()
+	}
+
+	It("should create item on first apply", func() {
+		res := apply("fieldManager=ci", `{"spec":{"replicas":1,"image":"web:1"}}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		rsp := decodeBody(res)
+		Expect(rsp).Should(HaveKeyWithValue("id", "web"))
+		Expect(rsp).Should(HaveKey("createdAt"))
+		Expect(rsp["managedFields"]).Should(HaveLen(1))
+	})
+
+	It("should fail on conflicting apply", func() {
+		res := apply("fieldManager=autoscaler", `{"spec":{"replicas":2}}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusConflict))
+
+		rsp := decodeBody(res)
+		Expect(rsp).Should(HaveKeyWithValue("message", "Apply conflict"))
+		Expect(rsp["error"]).Should(ContainSubstring("/spec/replicas"))
+	})
+
+	It("should share fields applied with the same value", func() {
+		res := apply("fieldManager=autoscaler", `{"spec":{"replicas":1}}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+
+		rsp := decodeBody(res)
+		Expect(rsp["managedFields"]).Should(HaveLen(2))
+	})
+
+	It("should take over fields by force", func() {
+		res := apply("fieldManager=autoscaler&force=true", `{"spec":{"replicas":3}}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+
+		rsp := decodeBody(res)
+		Expect(rsp["spec"]).Should(HaveKeyWithValue("replicas", float64(3)))
+
+		for _, entry := range rsp["managedFields"].([]interface{}) {
+			entry := entry.(map[string]interface{})
+			if entry["manager"] == "ci" {
+				Expect(entry["fields"]).Should(ConsistOf("/spec/image"))
+			}
+		}
+	})
+
+	It("should remove fields no longer applied by their only manager", func() {
+		res := apply("fieldManager=ci", `{"labels":{"app":"web"}}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+
+		rsp := decodeBody(res)
+		Expect(rsp["spec"]).ShouldNot(HaveKey("image"))
+		Expect(rsp["spec"]).Should(HaveKeyWithValue("replicas", float64(3)))
+		Expect(rsp["labels"]).Should(HaveKeyWithValue("app", "web"))
+	})
+
+	It("should not create items with preconditions", func() {
+		req := httptest.NewRequest(http.MethodPatch, "/acme/deployments/api?fieldManager=ci", bytes.NewBufferString(`{"spec":{"replicas":1}}`))
+		req.Header.Set("Content-Type", core.ApplyPatchType)
+		req.Header.Set("If-Match", `"1"`)
+
+		w := httptest.NewRecorder()
+
+		h.ServeHTTP(w, req)
+		Expect(w.Code).Should(Equal(http.StatusPreconditionFailed))
+
+		res := doRequest(h, http.MethodGet, "/acme/deployments/api", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNotFound))
+	})
+
+	It("should require field manager", func() {
+		res := apply("", `{"spec":{}}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusBadRequest))
+	})
+})
@@ -44,6 +44,10 @@ func (af *AutoFields) Delete(ctx context.Context, groupKind string, id string) e
 	return af.next.Delete(ctx, groupKind, id)
 }
 
+func (af *AutoFields) Unwrap() Service {
+	return af.next
+}
+
 var _ Service = new(AutoFields)
 
 func NewAutoFields(next Service) *AutoFields {
@@ -0,0 +1,211 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type BatchOperation struct {
+	Op        string             `json:"op"`
+	Group     string             `json:"group"`
+	Kind      string             `json:"kind"`
+	ID        string             `json:"id,omitempty"`
+	PatchType string             `json:"patchType,omitempty"`
+	Body      stdjson.RawMessage `json:"body,omitempty"`
+}
+
+type BatchRequest struct {
+	Operations  []BatchOperation `json:"operations"`
+	StopOnError bool             `json:"stopOnError"`
+	Atomic      bool             `json:"atomic"`
+}
+
+type BatchResult struct {
+	Status int                `json:"status"`
+	Body   stdjson.RawMessage `json:"body,omitempty"`
+}
+
+type BatchResponse struct {
+	Results    []BatchResult `json:"results"`
+	RolledBack bool          `json:"rolledBack,omitempty"`
+}
+
+// responseRecorder keeps a response in memory, so it can be embedded in another one.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newResponseRecorder() *responseRecorder {
+	return &responseRecorder{
+		header: make(http.Header),
+		status: http.StatusOK,
+	}
+}
+
+func (rec *responseRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	return rec.body.Write(b)
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+}
+
+var errBatchOperationFailed = errors.New("batch operation failed")
+
+// BatchHandler runs each operation of a batch as a request to next, which is expected to route like NewHandler.
+// Atomic batches run in a transaction of svc and stop at the first failure, which rolls back every operation.
+// Their operations are admitted ahead of the transaction, see Admission.
+// A batch with the dryRun query parameter runs every operation as a dry run.
+func BatchHandler(svc Service, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req BatchRequest
+
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			_ = json.NewEncoder(w).Encode(HTTPError{
+				Message: "Invalid request",
+				Error:   err.Error(),
+			})
+
+			return
+		}
+
+		res := BatchResponse{Results: make([]BatchResult, 0, len(req.Operations))}
+
+		run := func(ctx context.Context) error {
+			for i := range req.Operations {
+				result := runBatchOperation(ctx, next, req.Operations[i])
+
+				res.Results = append(res.Results, result)
+
+				if result.Status < http.StatusBadRequest {
+					continue
+				}
+
+				if req.Atomic {
+					return errBatchOperationFailed
+				}
+
+				if req.StopOnError {
+					break
+				}
+			}
+
+			return nil
+		}
+
+		ctx := requestContext(r)
+
+		if !req.Atomic {
+			_ = run(ctx)
+		} else {
+			ctx = preadmit(ctx, svc, func(ctx context.Context) error {
+				for i := range req.Operations {
+					_ = runBatchOperation(ctx, next, req.Operations[i])
+				}
+
+				return nil
+			})
+
+			err = Tx(ctx, svc, run)
+			if err != nil && !errors.Is(err, errBatchOperationFailed) {
+				writeError(w, err)
+
+				return
+			}
+
+			res.RolledBack = err != nil
+		}
+
+		_ = json.NewEncoder(w).Encode(res)
+	}
+}
+
+func runBatchOperation(ctx context.Context, next http.Handler, op BatchOperation) BatchResult {
+	subReq, err := newBatchOperationRequest(ctx, op)
+	if err != nil {
+		body, _ := json.Marshal(HTTPError{
+			Message: "Invalid operation",
+			Error:   err.Error(),
+		})
+
+		return BatchResult{Status: http.StatusBadRequest, Body: body}
+	}
+
+	rec := newResponseRecorder()
+
+	next.ServeHTTP(rec, subReq)
+
+	res := BatchResult{Status: rec.status}
+
+	if body := bytes.TrimSpace(rec.body.Bytes()); len(body) > 0 {
+		res.Body = body
+	}
+
+	return res
+}
+
+func newBatchOperationRequest(ctx context.Context, op BatchOperation) (*http.Request, error) {
+	if op.Group == "" || op.Kind == "" {
+		return nil, fmt.Errorf("group and kind are required")
+	}
+
+	target := "/" + url.PathEscape(op.Group) + "/" + url.PathEscape(op.Kind)
+
+	var method string
+
+	switch op.Op {
+	case "create":
+		method = http.MethodPost
+	case "replace":
+		method = http.MethodPut
+	case "patch":
+		method = http.MethodPatch
+	case "delete":
+		method = http.MethodDelete
+	default:
+		return nil, fmt.Errorf("unknown operation '%s'", op.Op)
+	}
+
+	if method != http.MethodPost {
+		if op.ID == "" {
+			return nil, fmt.Errorf("id is required for %s", op.Op)
+		}
+
+		target += "/" + url.PathEscape(op.ID)
+	}
+
+	// each operation is routed from scratch, instead of as a sub router of the batch request
+	ctx = context.WithValue(ctx, chi.RouteCtxKey, nil)
+
+	req, err := http.NewRequestWithContext(ctx, method, target, strings.NewReader(string(op.Body)))
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case method != http.MethodPatch:
+		req.Header.Set("Content-Type", "application/json")
+	case op.PatchType != "":
+		req.Header.Set("Content-Type", op.PatchType)
+	default:
+		req.Header.Set("Content-Type", MergePatchType)
+	}
+
+	return req, nil
+}
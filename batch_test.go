@@ -0,0 +1,139 @@
+package core_test
+
+import (
+	"encoding/json"
+	"github.com/applicaset/core"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"net/http"
+)
+
+var _ = Describe("Batch", func() {
+	var h *core.Handler
+
+	BeforeEach(func() {
+		var svc core.Service
+		svc = core.NewStore()
+		svc = core.NewAutoFields(svc)
+
+		h = core.NewHandler(svc)
+	})
+
+	batch := func(body string) core.BatchResponse {
+		res := doRequest(h, http.MethodPost, "/_batch", body)
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+
+		defer func() { _ = res.Body.Close() }()
+
+		var rsp core.BatchResponse
+
+		err := json.NewDecoder(res.Body).Decode(&rsp)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		return rsp
+	}
+
+	It("should run operations across kinds", func() {
+		rsp := batch(`{"operations":[
+			{"op":"create","group":"acme","kind":"orders","body":{"id":"order1","total":10}},
+			{"op":"create","group":"acme","kind":"stock","body":{"id":"item1","count":5}},
+			{"op":"patch","group":"acme","kind":"stock","id":"item1","body":{"count":4}},
+			{"op":"replace","group":"acme","kind":"orders","id":"order1","body":{"id":"order1","total":12}},
+			{"op":"delete","group":"acme","kind":"orders","id":"order1"}
+		]}`)
+
+		Expect(rsp.Results).Should(HaveLen(5))
+		Expect(rsp.Results[0].Status).Should(Equal(http.StatusCreated))
+		Expect(rsp.Results[1].Status).Should(Equal(http.StatusCreated))
+		Expect(rsp.Results[2].Status).Should(Equal(http.StatusOK))
+		Expect(rsp.Results[3].Status).Should(Equal(http.StatusNoContent))
+		Expect(rsp.Results[4].Status).Should(Equal(http.StatusNoContent))
+
+		var item map[string]interface{}
+
+		err := json.Unmarshal(rsp.Results[2].Body, &item)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(item).Should(HaveKeyWithValue("count", float64(4)))
+	})
+
+	It("should report failures per operation", func() {
+		rsp := batch(`{"operations":[
+			{"op":"delete","group":"acme","kind":"orders","id":"order1"},
+			{"op":"unknown","group":"acme","kind":"orders"},
+			{"op":"create","group":"acme","kind":"orders","body":{"id":"order1"}}
+		]}`)
+
+		Expect(rsp.Results).Should(HaveLen(3))
+		Expect(rsp.Results[0].Status).Should(Equal(http.StatusNotFound))
+		Expect(rsp.Results[1].Status).Should(Equal(http.StatusBadRequest))
+		Expect(rsp.Results[2].Status).Should(Equal(http.StatusCreated))
+	})
+
+	It("should stop at first failure", func() {
+		rsp := batch(`{"stopOnError":true,"operations":[
+			{"op":"create","group":"acme","kind":"orders","body":{"id":"order1"}},
+			{"op":"create","group":"acme","kind":"orders","body":{"id":"order1"}},
+			{"op":"create","group":"acme","kind":"orders","body":{"id":"order2"}}
+		]}`)
+
+		Expect(rsp.Results).Should(HaveLen(2))
+		Expect(rsp.Results[1].Status).Should(Equal(http.StatusConflict))
+
+		res := doRequest(h, http.MethodGet, "/acme/orders/order2", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNotFound))
+	})
+
+	It("should fail on invalid request", func() {
+		res := doRequest(h, http.MethodPost, "/_batch", `{"operations":`)
+		Expect(res.StatusCode).Should(Equal(http.StatusBadRequest))
+	})
+})
+
+var _ = Describe("Atomic batch", func() {
+	var h *core.Handler
+
+	BeforeEach(func() {
+		var svc core.Service
+		svc = core.NewStore()
+		svc = core.NewAutoFields(svc)
+
+		h = core.NewHandler(svc)
+
+		res := doRequest(h, http.MethodPost, "/acme/stock", `{"id":"item1","count":1}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+	})
+
+	It("should commit all operations", func() {
+		res := doRequest(h, http.MethodPost, "/_batch", `{"atomic":true,"operations":[
+			{"op":"create","group":"acme","kind":"orders","body":{"id":"order1"}},
+			{"op":"patch","group":"acme","kind":"stock","id":"item1","body":{"count":0}}
+		]}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+
+		body := decodeBody(res)
+		Expect(body).ShouldNot(HaveKey("rolledBack"))
+
+		res = doRequest(h, http.MethodGet, "/acme/orders/order1", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+	})
+
+	It("should roll back all operations on failure", func() {
+		res := doRequest(h, http.MethodPost, "/_batch", `{"atomic":true,"operations":[
+			{"op":"create","group":"acme","kind":"orders","body":{"id":"order1"}},
+			{"op":"patch","group":"acme","kind":"stock","id":"item1","body":{"count":0}},
+			{"op":"delete","group":"acme","kind":"stock","id":"item2"},
+			{"op":"create","group":"acme","kind":"orders","body":{"id":"order2"}}
+		]}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+
+		body := decodeBody(res)
+		Expect(body).Should(HaveKeyWithValue("rolledBack", true))
+		Expect(body["results"]).Should(HaveLen(3))
+
+		res = doRequest(h, http.MethodGet, "/acme/orders/order1", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNotFound))
+
+		res = doRequest(h, http.MethodGet, "/acme/stock/item1", "")
+		Expect(decodeBody(res)).Should(HaveKeyWithValue("count", float64(1)))
+	})
+})
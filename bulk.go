@@ -0,0 +1,281 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strconv"
+)
+
+type BulkResponse struct {
+	Count  int      `json:"count"`
+	IDs    []string `json:"ids"`
+	DryRun bool     `json:"dryRun,omitempty"`
+}
+
+// inTx runs fn in a transaction of svc, or without one if svc does not support them.
+func inTx(ctx context.Context, svc Service, fn func(ctx context.Context) error) error {
+	if _, ok := Lookup[Transactor](svc); !ok {
+		return fn(ctx)
+	}
+
+	return Tx(ctx, svc, fn)
+}
+
+func matchingItems(ctx context.Context, svc Service, groupKind string, filter *Expr) ([]GenericItem, error) {
+	items, err := svc.List(ctx, groupKind)
+	if err != nil {
+		return nil, err
+	}
+
+	res := items[:0]
+
+	for i := range items {
+		ok, err := filter.Match(items[i])
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			res = append(res, items[i])
+		}
+	}
+
+	return res, nil
+}
+
+var errNoLongerMatches = errors.New("item no longer matches the filter")
+
+// BulkUpdate applies fn to every item of groupKind matching filter and returns the ids of the updated items.
+// Items which change concurrently are checked against filter again. With dryRun nothing is updated.
+func BulkUpdate(ctx context.Context, svc Service, groupKind string, filter *Expr, fn func(item GenericItem) (GenericItem, error), dryRun bool) ([]string, error) {
+	var res []string
+
+	update := func(ctx context.Context) error {
+		res = make([]string, 0)
+
+		items, err := matchingItems(ctx, svc, groupKind, filter)
+		if err != nil {
+			return err
+		}
+
+		for i := range items {
+			id := items[i].GetID()
+
+			if dryRun {
+				res = append(res, id)
+
+				continue
+			}
+
+			_, err = Update(ctx, svc, groupKind, id, func(item GenericItem) (GenericItem, error) {
+				ok, err := filter.Match(item)
+				if err != nil {
+					return nil, err
+				}
+
+				if !ok {
+					return nil, errNoLongerMatches
+				}
+
+				return fn(item)
+			})
+			if errors.Is(err, errNoLongerMatches) || errors.As(err, &ItemNotFoundError{}) {
+				continue
+			}
+
+			if err != nil {
+				return err
+			}
+
+			res = append(res, id)
+		}
+
+		return nil
+	}
+
+	err := inTx(preadmit(ctx, svc, update), svc, update)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// BulkDelete deletes every item of groupKind matching filter and returns the ids of the deleted items.
+// Items which change concurrently are checked against filter again. With dryRun nothing is deleted.
+func BulkDelete(ctx context.Context, svc Service, groupKind string, filter *Expr, dryRun bool) ([]string, error) {
+	var res []string
+
+	del := func(ctx context.Context) error {
+		res = make([]string, 0)
+
+		items, err := matchingItems(ctx, svc, groupKind, filter)
+		if err != nil {
+			return err
+		}
+
+		for i := range items {
+			id := items[i].GetID()
+
+			if dryRun {
+				res = append(res, id)
+
+				continue
+			}
+
+			deleted, err := deleteMatching(ctx, svc, groupKind, items[i], filter)
+			if err != nil {
+				return err
+			}
+
+			if deleted {
+				res = append(res, id)
+			}
+		}
+
+		return nil
+	}
+
+	err := inTx(preadmit(ctx, svc, del), svc, del)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func deleteMatching(ctx context.Context, svc Service, groupKind string, item GenericItem, filter *Expr) (bool, error) {
+	id := item.GetID()
+
+	for ctx.Err() == nil {
+		err := svc.Delete(withResourceVersion(ctx, ResourceVersionOf(item)), groupKind, id)
+		if err == nil {
+			return true, nil
+		}
+
+		if errors.As(err, &ItemNotFoundError{}) {
+			return false, nil
+		}
+
+		if !errors.As(err, &ConflictError{}) {
+			return false, err
+		}
+
+		item, err = svc.Read(ctx, groupKind, id)
+		if errors.As(err, &ItemNotFoundError{}) {
+			return false, nil
+		}
+
+		if err != nil {
+			return false, err
+		}
+
+		ok, err := filter.Match(item)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	return false, ctx.Err()
+}
+
+// parseFilter parses the filter query parameter of r. If it is missing or invalid, the error is written to w.
+func parseFilter(w http.ResponseWriter, r *http.Request) (*Expr, bool) {
+	src := r.URL.Query().Get("filter")
+	if src == "" {
+		w.WriteHeader(http.StatusBadRequest)
+
+		_ = json.NewEncoder(w).Encode(HTTPError{
+			Message: "Invalid filter",
+			Error:   "filter is required",
+		})
+
+		return nil, false
+	}
+
+	filter, err := ParseExpr(src)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+
+		_ = json.NewEncoder(w).Encode(HTTPError{
+			Message: "Invalid filter",
+			Error:   err.Error(),
+		})
+
+		return nil, false
+	}
+
+	return filter, true
+}
+
+func BulkPatchHandler(svc Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		group := chi.URLParam(r, "group")
+		kind := chi.URLParam(r, "kind")
+
+		filter, ok := parseFilter(w, r)
+		if !ok {
+			return
+		}
+
+		patch, ok := decodePatch(w, r)
+		if !ok {
+			return
+		}
+
+		dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+
+		patch, err := rejectChangedComputedFields(r.Context(), svc, GetGroupKind(group, kind), patch)
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		ids, err := BulkUpdate(r.Context(), svc, GetGroupKind(group, kind), filter, patch, dryRun)
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(BulkResponse{Count: len(ids), IDs: ids, DryRun: dryRun})
+	}
+}
+
+func BulkDeleteHandler(svc Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		group := chi.URLParam(r, "group")
+		kind := chi.URLParam(r, "kind")
+
+		filter, ok := parseFilter(w, r)
+		if !ok {
+			return
+		}
+
+		policy, err := ParsePropagationPolicy(r.URL.Query().Get("propagationPolicy"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			_ = json.NewEncoder(w).Encode(HTTPError{
+				Message: "Invalid propagation policy",
+				Error:   err.Error(),
+			})
+
+			return
+		}
+
+		dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+
+		ids, err := BulkDelete(WithPropagationPolicy(r.Context(), policy), svc, GetGroupKind(group, kind), filter, dryRun)
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(BulkResponse{Count: len(ids), IDs: ids, DryRun: dryRun})
+	}
+}
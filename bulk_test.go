@@ -0,0 +1,125 @@
+package core_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/applicaset/core"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+)
+
+var _ = Describe("Bulk", func() {
+	var (
+		store *core.Store
+		h     *core.Handler
+	)
+
+	BeforeEach(func() {
+		store = core.NewStore()
+
+		var svc core.Service
+		svc = core.NewAutoFields(store)
+		svc = core.NewGarbageCollector(svc, 0)
+
+		h = core.NewHandler(svc)
+
+		for _, body := range []string{
+			`{"id":"order1","status":"pending","total":10}`,
+			`{"id":"order2","status":"pending","total":50}`,
+			`{"id":"order3","status":"shipped","total":20}`,
+		} {
+			res := doRequest(h, http.MethodPost, "/acme/orders", body)
+			Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+		}
+	})
+
+	bulk := func(method string, filter string, extra string, body string, contentType string) *http.Response {
+		req := httptest.NewRequest(method, "/acme/orders?filter="+url.QueryEscape(filter)+extra, bytes.NewBufferString(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		w := httptest.NewRecorder()
+
+		h.ServeHTTP(w, req)
+
+		return w.Result()
+	}
+
+	It("should patch matching items", func() {
+		// make updatedAt stale, so it is visible when it gets stamped again
+		item, err := store.Read(context.Background(), "acme/orders", "order1")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		item["updatedAt"] = "2000-01-01T00:00:00Z"
+		err = store.Replace(context.Background(), "acme/orders", "order1", item)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		res := bulk(http.MethodPatch, `status == "pending"`, "", `{"status":"cancelled"}`, core.MergePatchType)
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+
+		body := decodeBody(res)
+		Expect(body).Should(HaveKeyWithValue("count", float64(2)))
+		Expect(body["ids"]).Should(ConsistOf("order1", "order2"))
+
+		body = decodeBody(doRequest(h, http.MethodGet, "/acme/orders/order1", ""))
+		Expect(body).Should(HaveKeyWithValue("status", "cancelled"))
+		Expect(body["updatedAt"]).ShouldNot(Equal("2000-01-01T00:00:00Z"))
+
+		body = decodeBody(doRequest(h, http.MethodGet, "/acme/orders/order3", ""))
+		Expect(body).Should(HaveKeyWithValue("status", "shipped"))
+	})
+
+	It("should delete matching items", func() {
+		res := bulk(http.MethodDelete, `total >= 20`, "", "", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+
+		body := decodeBody(res)
+		Expect(body).Should(HaveKeyWithValue("count", float64(2)))
+		Expect(body["ids"]).Should(ConsistOf("order2", "order3"))
+
+		body = decodeBody(doRequest(h, http.MethodGet, "/acme/orders", ""))
+		Expect(body["items"]).Should(HaveLen(1))
+	})
+
+	It("should not change anything on dry run", func() {
+		res := bulk(http.MethodDelete, `total >= 20`, "&dryRun=true", "", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+
+		body := decodeBody(res)
+		Expect(body).Should(HaveKeyWithValue("dryRun", true))
+		Expect(body["ids"]).Should(ConsistOf("order2", "order3"))
+
+		res = bulk(http.MethodPatch, `true`, "&dryRun=true", `{"status":"cancelled"}`, core.MergePatchType)
+		Expect(decodeBody(res)).Should(HaveKeyWithValue("count", float64(3)))
+
+		body = decodeBody(doRequest(h, http.MethodGet, "/acme/orders", ""))
+		Expect(body["items"]).Should(HaveLen(3))
+
+		for _, item := range body["items"].([]interface{}) {
+			Expect(item).ShouldNot(HaveKeyWithValue("status", "cancelled"))
+		}
+	})
+
+	It("should reject missing and invalid filters", func() {
+		res := doRequest(h, http.MethodDelete, "/acme/orders", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusBadRequest))
+
+		res = bulk(http.MethodDelete, `total >=`, "", "", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusBadRequest))
+
+		res = bulk(http.MethodDelete, `status * 2`, "", "", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusBadRequest))
+	})
+
+	It("should reject patches changing ids", func() {
+		res := bulk(http.MethodPatch, `true`, "", `{"id":"other"}`, core.MergePatchType)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+
+		body := decodeBody(doRequest(h, http.MethodGet, "/acme/orders", ""))
+		Expect(body["items"]).Should(HaveLen(3))
+	})
+})
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"time"
+)
+
+func LastModifiedOf(item GenericItem) time.Time {
+	s, _ := item["updatedAt"].(string)
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return t
+}
+
+// listETag derives a weak entity tag from ids and resource versions of items.
+// Items are expected to be sorted by id.
+func listETag(items []GenericItem) string {
+	h := fnv.New64a()
+
+	for i := range items {
+		_, _ = fmt.Fprintf(h, "%s\x00%s\x00", items[i].GetID(), ResourceVersionOf(items[i]))
+	}
+
+	return fmt.Sprintf(`W/"%x"`, h.Sum64())
+}
+
+// setETag sets the entity tag of an item with resource version rv. Items without one have no entity tag.
+func setETag(w http.ResponseWriter, rv string) {
+	if rv != "" {
+		w.Header().Set("ETag", ETag(rv))
+	}
+}
+
+// writeCacheHeaders sets the cache headers of a response. An empty etag is left out.
+func writeCacheHeaders(w http.ResponseWriter, etag string, lastModified time.Time, cacheControl string) {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if cacheControl != "" {
+		w.Header().Set("Cache-Control", cacheControl)
+	}
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since in its absence, as described in RFC 7232.
+// Without an etag only * matches.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if tags := ParseETags(r.Header.Get("If-None-Match")); tags != nil {
+		for i := range tags {
+			if tags[i] == "*" || (etag != "" && tags[i] == ParseETags(etag)[0]) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || lastModified.IsZero() {
+		return false
+	}
+
+	return !lastModified.Truncate(time.Second).After(ims)
+}
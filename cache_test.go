@@ -0,0 +1,134 @@
+package core_test
+
+import (
+	"context"
+	"github.com/applicaset/core"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+var _ = Describe("Conditional requests", Ordered, func() {
+	var svc core.Service
+	svc = core.NewStore()
+	svc = core.NewAutoFields(svc)
+
+	h := core.NewHandler(svc,
+		core.WithDefaultCacheControl("no-cache"),
+		core.WithCacheControl("acme/foo", "max-age=60"),
+	)
+
+	conditionalGet := func(target, header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set(header, value)
+
+		w := httptest.NewRecorder()
+
+		h.ServeHTTP(w, req)
+
+		return w
+	}
+
+	BeforeAll(func() {
+		res := doRequest(h, http.MethodPost, "/acme/foo", `{"id":"foo1","bar":"baz"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		res = doRequest(h, http.MethodPost, "/acme/bar", `{"id":"bar1"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+	})
+
+	It("should return caching headers on read", func() {
+		res := doRequest(h, http.MethodGet, "/acme/foo/foo1", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+		Expect(res.Header.Get("ETag")).ShouldNot(BeEmpty())
+		Expect(res.Header.Get("Last-Modified")).ShouldNot(BeEmpty())
+		Expect(res.Header.Get("Cache-Control")).Should(Equal("max-age=60"))
+
+		res = doRequest(h, http.MethodGet, "/acme/bar/bar1", "")
+		Expect(res.Header.Get("Cache-Control")).Should(Equal("no-cache"))
+	})
+
+	It("should honor if-none-match on read", func() {
+		res := doRequest(h, http.MethodGet, "/acme/foo/foo1", "")
+
+		w := conditionalGet("/acme/foo/foo1", "If-None-Match", res.Header.Get("ETag"))
+		Expect(w.Code).Should(Equal(http.StatusNotModified))
+		Expect(w.Body.Len()).Should(BeZero())
+
+		w = conditionalGet("/acme/foo/foo1", "If-None-Match", `"0"`)
+		Expect(w.Code).Should(Equal(http.StatusOK))
+	})
+
+	It("should honor if-modified-since on read", func() {
+		w := conditionalGet("/acme/foo/foo1", "If-Modified-Since", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+		Expect(w.Code).Should(Equal(http.StatusNotModified))
+
+		w = conditionalGet("/acme/foo/foo1", "If-Modified-Since", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+		Expect(w.Code).Should(Equal(http.StatusOK))
+	})
+
+	It("should honor if-none-match on list until it changes", func() {
+		res := doRequest(h, http.MethodGet, "/acme/foo", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+
+		etag := res.Header.Get("ETag")
+		Expect(etag).Should(HavePrefix("W/"))
+
+		w := conditionalGet("/acme/foo", "If-None-Match", etag)
+		Expect(w.Code).Should(Equal(http.StatusNotModified))
+
+		res = doRequest(h, http.MethodPost, "/acme/foo", `{"id":"foo2"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		w = conditionalGet("/acme/foo", "If-None-Match", etag)
+		Expect(w.Code).Should(Equal(http.StatusOK))
+	})
+
+	It("should leave modification times out of lists", func() {
+		res := doRequest(h, http.MethodGet, "/acme/foo", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+		Expect(res.Header.Get("Last-Modified")).Should(BeEmpty())
+
+		res = doRequest(h, http.MethodDelete, "/acme/foo/foo2", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNoContent))
+
+		w := conditionalGet("/acme/foo", "If-Modified-Since", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+		Expect(w.Code).Should(Equal(http.StatusOK))
+	})
+})
+
+// unversioned serves items without resource versions, like services which do not track them.
+type unversioned struct {
+	core.Service
+}
+
+func (u unversioned) Read(ctx context.Context, groupKind string, id string) (core.GenericItem, error) {
+	item, err := u.Service.Read(ctx, groupKind, id)
+	if err != nil {
+		return nil, err
+	}
+
+	delete(item, "resourceVersion")
+
+	return item, nil
+}
+
+var _ = Describe("Conditional requests without resource versions", func() {
+	It("should neither send nor match entity tags", func() {
+		h := core.NewHandler(unversioned{core.NewStore()})
+
+		res := doRequest(h, http.MethodPost, "/acme/foo", `{"id":"foo1"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		req := httptest.NewRequest(http.MethodGet, "/acme/foo/foo1", nil)
+		req.Header.Set("If-None-Match", `""`)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		Expect(w.Code).Should(Equal(http.StatusOK))
+		Expect(w.Header()).ShouldNot(HaveKey("Etag"))
+	})
+})
@@ -0,0 +1,391 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	ChangesGroupKind = "core/changes"
+
+	defaultChangesLimit = 100
+	maxChangesLimit     = 1000
+)
+
+type actorKey struct{}
+
+// WithActor records who makes the changes of ctx, e.g. the authenticated user of a request.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+
+	return actor
+}
+
+type ChangeLogOptions struct {
+	// Retention is how long changes are kept. Zero keeps them forever.
+	Retention time.Duration
+	// CompactAfter is the age after which only the latest change of each item is kept. Zero never compacts.
+	CompactAfter time.Duration
+}
+
+type ChangesResponse struct {
+	Items []GenericItem `json:"items"`
+	// Next is the since to ask for the changes following these.
+	Next int64 `json:"next"`
+}
+
+// ChangeLog appends a change to ChangesGroupKind for every write, in the same transaction as the write if next supports them.
+// Changes are numbered by a gap-free sequence, so they can be tailed by asking for the ones after the last seen number.
+// They are written straight to the Service at the bottom of the chain of next, so the decorators in between neither
+// change them nor act on them.
+type ChangeLog struct {
+	next  Service
+	store Service
+	seq   *Sequences
+	opts  ChangeLogOptions
+
+	// index holds the numbers of the changes in order. Changes written before it has been loaded by Since are
+	// only in the store.
+	mu     sync.Mutex
+	index  []int64
+	loaded bool
+}
+
+func changeID(seq int64) string {
+	return fmt.Sprintf("%020d", seq)
+}
+
+func (cl *ChangeLog) List(ctx context.Context, groupKind string) ([]GenericItem, error) {
+	return cl.next.List(ctx, groupKind)
+}
+
+func (cl *ChangeLog) Create(ctx context.Context, groupKind string, req GenericItem) error {
+	return inTx(ctx, cl.next, func(ctx context.Context) error {
+		err := cl.next.Create(ctx, groupKind, req)
+		if err != nil {
+			return err
+		}
+
+		return cl.append(ctx, "create", groupKind, req.GetID(), nil, req)
+	})
+}
+
+func (cl *ChangeLog) Read(ctx context.Context, groupKind string, id string) (GenericItem, error) {
+	return cl.next.Read(ctx, groupKind, id)
+}
+
+func (cl *ChangeLog) Replace(ctx context.Context, groupKind string, id string, req GenericItem) error {
+	return inTx(ctx, cl.next, func(ctx context.Context) error {
+		before, err := cl.next.Read(ctx, groupKind, id)
+		if err != nil {
+			return err
+		}
+
+		err = cl.next.Replace(ctx, groupKind, id, req)
+		if err != nil {
+			return err
+		}
+
+		return cl.append(ctx, "replace", groupKind, id, before, req)
+	})
+}
+
+func (cl *ChangeLog) Delete(ctx context.Context, groupKind string, id string) error {
+	return inTx(ctx, cl.next, func(ctx context.Context) error {
+		before, err := cl.next.Read(ctx, groupKind, id)
+		if err != nil {
+			return err
+		}
+
+		err = cl.next.Delete(ctx, groupKind, id)
+		if err != nil {
+			return err
+		}
+
+		// items with finalizers are only marked for deletion
+		after, err := cl.next.Read(ctx, groupKind, id)
+		if err != nil {
+			after = nil
+		}
+
+		return cl.append(ctx, "delete", groupKind, id, before, after)
+	})
+}
+
+func (cl *ChangeLog) Unwrap() Service {
+	return cl.next
+}
+
+var _ Service = new(ChangeLog)
+
+func (cl *ChangeLog) append(ctx context.Context, operation string, groupKind string, id string, before, after GenericItem) error {
+	if groupKind == ChangesGroupKind || IsDryRun(ctx) {
+		return nil
+	}
+
+	seq, err := cl.seq.Next(ctx, KindSequence(ChangesGroupKind))
+	if err != nil {
+		return err
+	}
+
+	change := GenericItem{
+		"id":        changeID(seq),
+		"seq":       seq,
+		"operation": operation,
+		"groupKind": groupKind,
+		"itemId":    id,
+		"before":    nil,
+		"after":     nil,
+		"actor":     ActorFromContext(ctx),
+		"timestamp": time.Now().Format(time.RFC3339Nano),
+	}
+
+	if before != nil {
+		change["before"] = map[string]interface{}(before.DeepCopy())
+	}
+
+	if after != nil {
+		change["after"] = map[string]interface{}(after.DeepCopy())
+	}
+
+	err = cl.store.Create(ctx, ChangesGroupKind, change)
+	if err != nil {
+		return err
+	}
+
+	AfterCommit(ctx, func() { cl.indexAdd(seq) })
+
+	return nil
+}
+
+func (cl *ChangeLog) indexAdd(seq int64) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cl.insert(seq)
+}
+
+func (cl *ChangeLog) insert(seq int64) {
+	i := sort.Search(len(cl.index), func(i int) bool { return cl.index[i] >= seq })
+	if i < len(cl.index) && cl.index[i] == seq {
+		return
+	}
+
+	cl.index = append(cl.index, 0)
+	copy(cl.index[i+1:], cl.index[i:])
+	cl.index[i] = seq
+}
+
+func (cl *ChangeLog) indexRemove(seq int64) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	i := sort.Search(len(cl.index), func(i int) bool { return cl.index[i] >= seq })
+	if i < len(cl.index) && cl.index[i] == seq {
+		cl.index = append(cl.index[:i], cl.index[i+1:]...)
+	}
+}
+
+// indexAfter returns up to limit numbers of changes following since, loading the index first if needed.
+// The store is not listed while holding mu, as changes are indexed once their transaction commits.
+func (cl *ChangeLog) indexAfter(ctx context.Context, since int64, limit int) ([]int64, error) {
+	cl.mu.Lock()
+	loaded := cl.loaded
+	cl.mu.Unlock()
+
+	if !loaded {
+		changes, err := cl.store.List(ctx, ChangesGroupKind)
+		if err != nil && !errors.As(err, &GroupKindNotFoundError{}) {
+			return nil, err
+		}
+
+		cl.mu.Lock()
+
+		for i := range changes {
+			if seq, ok := toInt64(changes[i]["seq"]); ok {
+				cl.insert(seq)
+			}
+		}
+
+		cl.loaded = true
+
+		cl.mu.Unlock()
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	i := sort.Search(len(cl.index), func(i int) bool { return cl.index[i] > since })
+	res := cl.index[i:]
+
+	if len(res) > limit {
+		res = res[:limit]
+	}
+
+	return append([]int64(nil), res...), nil
+}
+
+// Since returns up to limit changes following the one numbered since, in order.
+func (cl *ChangeLog) Since(ctx context.Context, since int64, limit int) ([]GenericItem, error) {
+	seqs, err := cl.indexAfter(ctx, since, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]GenericItem, 0, len(seqs))
+
+	for _, seq := range seqs {
+		change, err := cl.store.Read(ctx, ChangesGroupKind, changeID(seq))
+		if err != nil {
+			// swept meanwhile
+			if errors.As(err, &ItemNotFoundError{}) || errors.As(err, &GroupKindNotFoundError{}) {
+				cl.indexRemove(seq)
+
+				continue
+			}
+
+			return nil, err
+		}
+
+		res = append(res, change)
+	}
+
+	return res, nil
+}
+
+// Sweep deletes the changes past retention, and the ones past CompactAfter which are followed by a change of the same item.
+func (cl *ChangeLog) Sweep(ctx context.Context) error {
+	changes, err := cl.store.List(ctx, ChangesGroupKind)
+	if err != nil {
+		if errors.As(err, &GroupKindNotFoundError{}) {
+			return nil
+		}
+
+		return err
+	}
+
+	now := time.Now()
+	latest := make(map[string]string)
+
+	for i := range changes {
+		latest[fmt.Sprint(changes[i]["groupKind"], "/", changes[i]["itemId"])] = changes[i].GetID()
+	}
+
+	for i := range changes {
+		s, _ := changes[i]["timestamp"].(string)
+
+		timestamp, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			continue
+		}
+
+		age := now.Sub(timestamp)
+
+		expired := cl.opts.Retention > 0 && age > cl.opts.Retention
+		compacted := cl.opts.CompactAfter > 0 && age > cl.opts.CompactAfter &&
+			latest[fmt.Sprint(changes[i]["groupKind"], "/", changes[i]["itemId"])] != changes[i].GetID()
+
+		if !expired && !compacted {
+			continue
+		}
+
+		err = cl.store.Delete(ctx, ChangesGroupKind, changes[i].GetID())
+		if err != nil && !errors.As(err, &ItemNotFoundError{}) {
+			return err
+		}
+
+		if seq, ok := toInt64(changes[i]["seq"]); ok {
+			cl.indexRemove(seq)
+		}
+	}
+
+	return nil
+}
+
+// Run sweeps the change log every interval until ctx is done.
+func (cl *ChangeLog) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = cl.Sweep(ctx)
+		}
+	}
+}
+
+func NewChangeLog(next Service, opts ChangeLogOptions) *ChangeLog {
+	store := innermost(next)
+
+	return &ChangeLog{
+		next:  next,
+		store: store,
+		seq:   NewSequences(store),
+		opts:  opts,
+	}
+}
+
+func ChangesHandler(cl *ChangeLog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			since int64
+			limit = defaultChangesLimit
+			err   error
+		)
+
+		if s := r.URL.Query().Get("since"); s != "" {
+			since, err = strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+
+				_ = json.NewEncoder(w).Encode(HTTPError{
+					Message: "Invalid since",
+					Error:   err.Error(),
+				})
+
+				return
+			}
+		}
+
+		if s := r.URL.Query().Get("limit"); s != "" {
+			limit, err = strconv.Atoi(s)
+			if err != nil || limit <= 0 || limit > maxChangesLimit {
+				w.WriteHeader(http.StatusBadRequest)
+
+				_ = json.NewEncoder(w).Encode(HTTPError{
+					Message: "Invalid limit",
+					Error:   fmt.Sprintf("limit must be between 1 and %d", maxChangesLimit),
+				})
+
+				return
+			}
+		}
+
+		changes, err := cl.Since(r.Context(), since, limit)
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		next := since
+		if len(changes) > 0 {
+			next, _ = toInt64(changes[len(changes)-1]["seq"])
+		}
+
+		_ = json.NewEncoder(w).Encode(ChangesResponse{Items: changes, Next: next})
+	}
+}
@@ -0,0 +1,194 @@
+package core_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/applicaset/core"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var _ = Describe("Change log", func() {
+	var h *core.Handler
+
+	BeforeEach(func() {
+		var svc core.Service
+		svc = core.NewAutoFields(core.NewStore())
+		svc = core.NewIDPolicies(svc, core.IDPolicy{})
+
+		changeLog := core.NewChangeLog(svc, core.ChangeLogOptions{})
+
+		h = core.NewHandler(changeLog, core.WithChangeLog(changeLog), core.WithActorHeader("X-Actor"))
+	})
+
+	It("should record every write in order", func() {
+		req := httptest.NewRequest(http.MethodPost, "/acme/orders", bytes.NewBufferString(`{"id":"o1","total":10}`))
+		req.Header.Set("X-Actor", "alice")
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		Expect(w.Code).Should(Equal(http.StatusCreated))
+
+		res := doRequest(h, http.MethodPut, "/acme/orders/o1", `{"id":"o1","total":20}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusNoContent))
+
+		res = doRequest(h, http.MethodDelete, "/acme/orders/o1", "")
+		Expect(res.StatusCode).Should(BeNumerically("<", 300))
+
+		body := decodeBody(doRequest(h, http.MethodGet, "/_changes", ""))
+		Expect(body["next"]).Should(BeEquivalentTo(3))
+
+		items := body["items"].([]interface{})
+		Expect(items).Should(HaveLen(3))
+
+		create := items[0].(map[string]interface{})
+		Expect(create["seq"]).Should(BeEquivalentTo(1))
+		Expect(create["operation"]).Should(Equal("create"))
+		Expect(create["groupKind"]).Should(Equal("acme/orders"))
+		Expect(create["itemId"]).Should(Equal("o1"))
+		Expect(create["actor"]).Should(Equal("alice"))
+		Expect(create["before"]).Should(BeNil())
+		Expect(create["after"]).Should(HaveKeyWithValue("total", BeEquivalentTo(10)))
+
+		replace := items[1].(map[string]interface{})
+		Expect(replace["operation"]).Should(Equal("replace"))
+		Expect(replace["before"]).Should(HaveKeyWithValue("total", BeEquivalentTo(10)))
+		Expect(replace["after"]).Should(HaveKeyWithValue("total", BeEquivalentTo(20)))
+
+		del := items[2].(map[string]interface{})
+		Expect(del["operation"]).Should(Equal("delete"))
+		Expect(del["before"]).Should(HaveKeyWithValue("total", BeEquivalentTo(20)))
+		Expect(del["after"]).Should(BeNil())
+
+		body = decodeBody(doRequest(h, http.MethodGet, "/_changes?since=1&limit=1", ""))
+		Expect(body["items"]).Should(HaveLen(1))
+		Expect(body["items"].([]interface{})[0]).Should(HaveKeyWithValue("seq", BeEquivalentTo(2)))
+		Expect(body["next"]).Should(BeEquivalentTo(2))
+
+		body = decodeBody(doRequest(h, http.MethodGet, "/_changes?since=3", ""))
+		Expect(body["items"]).Should(BeEmpty())
+		Expect(body["next"]).Should(BeEquivalentTo(3))
+	})
+
+	It("should not record failed writes or dry runs", func() {
+		res := doRequest(h, http.MethodPost, "/acme/orders?dryRun=true", `{"id":"o1"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		res = doRequest(h, http.MethodPost, "/_batch", `{"atomic":true,"operations":[
+			{"op":"create","group":"acme","kind":"orders","body":{"id":"o1"}},
+			{"op":"delete","group":"acme","kind":"orders","id":"missing"}
+		]}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+
+		res = doRequest(h, http.MethodPost, "/acme/orders", `{"id":"o2"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		body := decodeBody(doRequest(h, http.MethodGet, "/_changes", ""))
+		Expect(body["items"]).Should(HaveLen(1))
+		Expect(body["items"].([]interface{})[0]).Should(SatisfyAll(
+			HaveKeyWithValue("seq", BeEquivalentTo(1)),
+			HaveKeyWithValue("itemId", "o2"),
+		))
+	})
+
+	It("should not let clients write changes", func() {
+		res := doRequest(h, http.MethodPost, "/core/changes", `{"id":"00000000000000000002","seq":2}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusNotFound))
+
+		res = doRequest(h, http.MethodPost, "/acme/orders", `{"id":"o1"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		res = doRequest(h, http.MethodDelete, "/core/changes/00000000000000000001", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNotFound))
+
+		res = doRequest(h, http.MethodGet, "/core/changes", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNotFound))
+
+		res = doRequest(h, http.MethodPost, "/acme/orders", `{"id":"o2"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		body := decodeBody(doRequest(h, http.MethodGet, "/_changes", ""))
+		Expect(body["items"]).Should(HaveLen(2))
+	})
+
+	It("should reject invalid parameters", func() {
+		res := doRequest(h, http.MethodGet, "/_changes?since=abc", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusBadRequest))
+
+		res = doRequest(h, http.MethodGet, "/_changes?limit=0", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusBadRequest))
+	})
+})
+
+func TestChangeLogSweep(t *testing.T) {
+	ctx := core.WithActor(context.Background(), "bob")
+
+	store := core.NewStore()
+	changeLog := core.NewChangeLog(store, core.ChangeLogOptions{CompactAfter: time.Nanosecond})
+
+	require.NoError(t, changeLog.Create(ctx, "acme/orders", core.GenericItem{"id": "o1", "total": 1}))
+	require.NoError(t, changeLog.Create(ctx, "acme/orders", core.GenericItem{"id": "o2", "total": 1}))
+	require.NoError(t, changeLog.Replace(ctx, "acme/orders", "o1", core.GenericItem{"id": "o1", "total": 2}))
+	require.NoError(t, changeLog.Replace(ctx, "acme/orders", "o1", core.GenericItem{"id": "o1", "total": 3}))
+
+	time.Sleep(time.Millisecond)
+
+	require.NoError(t, changeLog.Sweep(ctx))
+
+	changes, err := changeLog.Since(ctx, 0, 100)
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	assert.Equal(t, "o2", changes[0]["itemId"])
+	assert.Equal(t, "o1", changes[1]["itemId"])
+	assert.EqualValues(t, 4, changes[1]["seq"])
+	assert.Equal(t, "bob", changes[1]["actor"])
+
+	changeLog = core.NewChangeLog(store, core.ChangeLogOptions{Retention: time.Nanosecond})
+
+	require.NoError(t, changeLog.Sweep(ctx))
+
+	changes, err = changeLog.Since(ctx, 0, 100)
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+
+	// numbering goes on after the log is emptied
+	require.NoError(t, changeLog.Delete(ctx, "acme/orders", "o2"))
+
+	changes, err = changeLog.Since(ctx, 0, 100)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.EqualValues(t, 5, changes[0]["seq"])
+}
+
+func TestChangeLogBypassesDecorators(t *testing.T) {
+	ctx := context.Background()
+
+	changeLog := core.NewChangeLog(core.NewAutoFields(core.NewStore()), core.ChangeLogOptions{})
+
+	require.NoError(t, changeLog.Create(ctx, "acme/orders", core.GenericItem{"id": "o1"}))
+
+	item, err := changeLog.Read(ctx, "acme/orders", "o1")
+	require.NoError(t, err)
+	assert.Contains(t, item, "uuid")
+
+	changes, err := changeLog.Since(ctx, 0, 100)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.NotContains(t, changes[0], "uuid")
+
+	for i := 2; i <= 5; i++ {
+		require.NoError(t, changeLog.Replace(ctx, "acme/orders", "o1", core.GenericItem{"id": "o1", "total": i}))
+	}
+
+	changes, err = changeLog.Since(ctx, 3, 2)
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	assert.EqualValues(t, 4, changes[0]["seq"])
+	assert.EqualValues(t, 5, changes[1]["seq"])
+}
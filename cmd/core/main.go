@@ -1,23 +1,135 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"fmt"
 	"github.com/applicaset/core"
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/nasermirzaei89/env"
 	"net/http"
+	"strings"
+	"time"
 )
 
+// parseInterval parses the duration of a periodic task, which must be positive.
+func parseInterval(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+
+	if d <= 0 {
+		return 0, fmt.Errorf("interval must be positive, got %s", s)
+	}
+
+	return d, nil
+}
+
 func main() {
+	gcInterval, err := parseInterval(env.GetString("GC_INTERVAL", "1m"))
+	if err != nil {
+		panic(fmt.Errorf("error on parse gc interval: %w", err))
+	}
+
+	idempotencyWindow, err := parseInterval(env.GetString("IDEMPOTENCY_WINDOW", "24h"))
+	if err != nil {
+		panic(fmt.Errorf("error on parse idempotency window: %w", err))
+	}
+
+	changesRetention, err := time.ParseDuration(env.GetString("CHANGES_RETENTION", "168h"))
+	if err != nil {
+		panic(fmt.Errorf("error on parse changes retention: %w", err))
+	}
+
+	changesCompactAfter, err := time.ParseDuration(env.GetString("CHANGES_COMPACT_AFTER", "0"))
+	if err != nil {
+		panic(fmt.Errorf("error on parse changes compact after: %w", err))
+	}
+
+	pluginsReloadInterval, err := parseInterval(env.GetString("PLUGINS_RELOAD_INTERVAL", "5s"))
+	if err != nil {
+		panic(fmt.Errorf("error on parse plugins reload interval: %w", err))
+	}
+
+	store := core.NewStore()
+
 	var svc core.Service
-	svc = core.NewStore()
+	svc = store
 	svc = core.NewAutoFields(svc)
+	svc = core.NewIDPolicies(svc, core.IDPolicy{})
+
+	seq := core.NewSequences(store)
+	svc = core.NewFinalizers(svc)
+
+	webhooks := core.NewWebhooks(svc, core.WebhookOptions{})
+	svc = webhooks
+
+	go webhooks.Run(context.Background())
+
+	changeLog := core.NewChangeLog(svc, core.ChangeLogOptions{
+		Retention:    changesRetention,
+		CompactAfter: changesCompactAfter,
+	})
+	svc = changeLog
+
+	go changeLog.Run(context.Background(), gcInterval)
+
+	gc := core.NewGarbageCollector(svc, gcInterval)
+	svc = gc
+
+	go gc.Run(context.Background())
+
+	svc = core.NewStateMachines(svc)
+	svc = core.NewComputedFields(svc)
+
+	plugins := core.NewPlugins(svc, core.PluginOptions{Dir: env.GetString("PLUGINS_DIR", "plugins")})
+
+	if err := plugins.Load(context.Background()); err != nil {
+		panic(fmt.Errorf("error on load plugins: %w", err))
+	}
+
+	svc = plugins
+
+	go plugins.Run(context.Background(), pluginsReloadInterval)
+
+	svc = core.NewScripts(svc, core.ScriptOptions{})
+	svc = core.NewAdmission(svc, core.AdmissionOptions{})
+
+	expvar.Publish("gc", expvar.Func(func() interface{} { return gc.Metrics() }))
+	expvar.Publish("webhooks", expvar.Func(func() interface{} { return webhooks.Metrics() }))
+
+	idempotency := core.NewIdempotency(store, idempotencyWindow)
+
+	go idempotency.Run(context.Background())
+
+	opts := []core.HandlerOption{
+		core.WithDefaultCacheControl(env.GetString("DEFAULT_CACHE_CONTROL", "no-cache")),
+		core.WithIdempotency(idempotency),
+		core.WithChangeLog(changeLog),
+	}
+
+	if names := env.GetString("SEQUENCES", ""); names != "" {
+		opts = append(opts, core.WithSequences(seq, strings.Split(names, ",")...))
+	}
+
+	if header := env.GetString("ACTOR_HEADER", ""); header != "" {
+		opts = append(opts, core.WithActorHeader(header))
+	}
+
+	if env.GetBool("UPSERT", false) {
+		opts = append(opts, core.WithUpsert())
+	}
+
+	h := core.NewHandler(svc, opts...)
 
-	h := core.NewHandler(svc)
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.Handle("/", h)
 
 	apiAddress := env.GetString("API_ADDRESS", ":8080")
 
-	if err := http.ListenAndServe(apiAddress, h); err != nil {
+	if err := http.ListenAndServe(apiAddress, mux); err != nil {
 		panic(fmt.Errorf("error on listen and serve http"))
 	}
 }
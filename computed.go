@@ -0,0 +1,515 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const ComputedFieldsGroupKind = "core/computedfields"
+
+const (
+	// ComputedStored fields are evaluated on write and stored with the item.
+	ComputedStored = "stored"
+	// ComputedVirtual fields are evaluated on read and never stored.
+	ComputedVirtual = "virtual"
+)
+
+// ComputedFields derives fields of items from expressions, like `total = sum(lines[*].price * lines[*].qty)`.
+// Fields are defined as items of ComputedFieldsGroupKind with the groupKind they belong to, the field, its expr
+// and a mode, ComputedStored by default or ComputedVirtual. Fields are evaluated in the order of the ids of their
+// definitions, virtual ones after the stored ones. A virtual field which can not be evaluated reads as null.
+//
+// Writing a definition recomputes the stored fields of all the items of its kind, in one transaction if the service
+// supports them. Clients can not set computed fields with any of the routes of NewHandler.
+//
+// Stored fields are evaluated before the services below assign fields like a generated id, createdAt, updatedAt
+// and resourceVersion, so expressions of stored fields should not refer to them: they are missing on create, but
+// set when the fields are recomputed.
+type ComputedFields struct {
+	next Service
+
+	mu    sync.Mutex
+	exprs map[string]compiledExpr
+}
+
+type compiledExpr struct {
+	resourceVersion string
+	expr            *Expr
+}
+
+type computedField struct {
+	field   string
+	expr    *Expr
+	virtual bool
+}
+
+func (c *ComputedFields) List(ctx context.Context, groupKind string) ([]GenericItem, error) {
+	res, err := c.next.List(ctx, groupKind)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := c.fieldsOf(ctx, groupKind)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range res {
+		evalVirtualFields(fields, res[i])
+	}
+
+	return res, nil
+}
+
+func (c *ComputedFields) Create(ctx context.Context, groupKind string, req GenericItem) error {
+	if groupKind == ComputedFieldsGroupKind {
+		err := validateComputedField(req)
+		if err != nil {
+			return err
+		}
+
+		return inTx(ctx, c.next, func(ctx context.Context) error {
+			err := c.next.Create(ctx, groupKind, req)
+			if err != nil {
+				return err
+			}
+
+			_, err = c.recompute(ctx, computedGroupKindOf(req))
+
+			return err
+		})
+	}
+
+	fields, err := c.fieldsOf(ctx, groupKind)
+	if err != nil {
+		return err
+	}
+
+	err = evalStoredFields(fields, req)
+	if err != nil {
+		return err
+	}
+
+	err = c.next.Create(ctx, groupKind, req)
+	if err != nil {
+		return err
+	}
+
+	evalVirtualFields(fields, req)
+
+	return nil
+}
+
+func (c *ComputedFields) Read(ctx context.Context, groupKind string, id string) (GenericItem, error) {
+	res, err := c.next.Read(ctx, groupKind, id)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := c.fieldsOf(ctx, groupKind)
+	if err != nil {
+		return nil, err
+	}
+
+	evalVirtualFields(fields, res)
+
+	return res, nil
+}
+
+func (c *ComputedFields) Replace(ctx context.Context, groupKind string, id string, req GenericItem) error {
+	if groupKind == ComputedFieldsGroupKind {
+		err := validateComputedField(req)
+		if err != nil {
+			return err
+		}
+
+		return inTx(ctx, c.next, func(ctx context.Context) error {
+			old, err := c.next.Read(ctx, groupKind, id)
+			if err != nil {
+				return err
+			}
+
+			err = c.next.Replace(ctx, groupKind, id, req)
+			if err != nil {
+				return err
+			}
+
+			oldGroupKind, oldField := computedGroupKindOf(old), computedFieldOf(old)
+
+			if oldGroupKind != computedGroupKindOf(req) {
+				_, err = c.recompute(ctx, oldGroupKind, oldField)
+				if err != nil {
+					return err
+				}
+
+				oldField = ""
+			}
+
+			_, err = c.recompute(ctx, computedGroupKindOf(req), oldField)
+
+			return err
+		})
+	}
+
+	fields, err := c.fieldsOf(ctx, groupKind)
+	if err != nil {
+		return err
+	}
+
+	err = evalStoredFields(fields, req)
+	if err != nil {
+		return err
+	}
+
+	err = c.next.Replace(ctx, groupKind, id, req)
+	if err != nil {
+		return err
+	}
+
+	evalVirtualFields(fields, req)
+
+	return nil
+}
+
+func (c *ComputedFields) Delete(ctx context.Context, groupKind string, id string) error {
+	if groupKind == ComputedFieldsGroupKind {
+		return inTx(ctx, c.next, func(ctx context.Context) error {
+			old, err := c.next.Read(ctx, groupKind, id)
+			if err != nil {
+				return err
+			}
+
+			err = c.next.Delete(ctx, groupKind, id)
+			if err != nil {
+				return err
+			}
+
+			_, err = c.recompute(ctx, computedGroupKindOf(old), computedFieldOf(old))
+
+			return err
+		})
+	}
+
+	return c.next.Delete(ctx, groupKind, id)
+}
+
+func (c *ComputedFields) Unwrap() Service {
+	return c.next
+}
+
+var _ Service = new(ComputedFields)
+
+// Recompute evaluates the stored fields of every item of groupKind again and returns how many items changed.
+func (c *ComputedFields) Recompute(ctx context.Context, groupKind string) (int, error) {
+	return c.recompute(ctx, groupKind)
+}
+
+// recompute also drops the stale fields of removed definitions, unless they are still computed.
+func (c *ComputedFields) recompute(ctx context.Context, groupKind string, stale ...string) (int, error) {
+	var res int
+
+	err := inTx(ctx, c.next, func(ctx context.Context) error {
+		res = 0
+
+		fields, err := c.fieldsOf(ctx, groupKind)
+		if err != nil {
+			return err
+		}
+
+		items, err := c.next.List(ctx, groupKind)
+		if err != nil {
+			if errors.As(err, &GroupKindNotFoundError{}) {
+				return nil
+			}
+
+			return err
+		}
+
+		for i := range items {
+			item := items[i].DeepCopy()
+
+			for _, field := range stale {
+				delete(item, field)
+			}
+
+			err = evalStoredFields(fields, item)
+			if err != nil {
+				return err
+			}
+
+			if reflect.DeepEqual(item, items[i]) {
+				continue
+			}
+
+			err = c.next.Replace(ctx, groupKind, item.GetID(), item)
+			if err != nil {
+				return err
+			}
+
+			res++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return res, nil
+}
+
+func computedGroupKindOf(definition GenericItem) string {
+	groupKind, _ := definition["groupKind"].(string)
+
+	return groupKind
+}
+
+func computedFieldOf(definition GenericItem) string {
+	field, _ := definition["field"].(string)
+
+	return field
+}
+
+func validateComputedField(definition GenericItem) error {
+	if groupKind := computedGroupKindOf(definition); !strings.Contains(groupKind, "/") || groupKind == ComputedFieldsGroupKind {
+		return FieldError{Field: "groupKind", Reason: "must be a group/kind"}
+	}
+
+	if field := computedFieldOf(definition); field == "" || field == "id" {
+		return FieldError{Field: "field", Reason: "must be the name of a field other than id"}
+	}
+
+	src, ok := definition["expr"].(string)
+	if !ok {
+		return FieldError{Field: "expr", Reason: "must be a string"}
+	}
+
+	_, err := ParseExpr(src)
+	if err != nil {
+		return FieldError{Field: "expr", Reason: err.Error()}
+	}
+
+	if v, ok := definition["mode"]; ok && v != ComputedStored && v != ComputedVirtual {
+		return FieldError{Field: "mode", Reason: "must be stored or virtual"}
+	}
+
+	return nil
+}
+
+// fieldsOf returns the computed fields of groupKind, stored ones first.
+func (c *ComputedFields) fieldsOf(ctx context.Context, groupKind string) ([]computedField, error) {
+	if groupKind == ComputedFieldsGroupKind {
+		return nil, nil
+	}
+
+	definitions, err := c.next.List(ctx, ComputedFieldsGroupKind)
+	if err != nil {
+		if errors.As(err, &GroupKindNotFoundError{}) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	sort.Slice(definitions, func(i, j int) bool { return definitions[i].GetID() < definitions[j].GetID() })
+
+	res := make([]computedField, 0)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.forgetDeleted(definitions)
+
+	for i := range definitions {
+		if computedGroupKindOf(definitions[i]) != groupKind {
+			continue
+		}
+
+		id, rv := definitions[i].GetID(), ResourceVersionOf(definitions[i])
+
+		compiled, ok := c.exprs[id]
+		if !ok || compiled.resourceVersion != rv {
+			src, _ := definitions[i]["expr"].(string)
+
+			expr, err := ParseExpr(src)
+			if err != nil {
+				return nil, err
+			}
+
+			compiled = compiledExpr{resourceVersion: rv, expr: expr}
+			c.exprs[id] = compiled
+		}
+
+		res = append(res, computedField{
+			field:   computedFieldOf(definitions[i]),
+			expr:    compiled.expr,
+			virtual: definitions[i]["mode"] == ComputedVirtual,
+		})
+	}
+
+	sort.SliceStable(res, func(i, j int) bool { return !res[i].virtual && res[j].virtual })
+
+	return res, nil
+}
+
+// forgetDeleted drops the compiled expressions of definitions which do not exist anymore.
+func (c *ComputedFields) forgetDeleted(definitions []GenericItem) {
+	defined := make(map[string]bool, len(definitions))
+	for i := range definitions {
+		defined[definitions[i].GetID()] = true
+	}
+
+	for id := range c.exprs {
+		if !defined[id] {
+			delete(c.exprs, id)
+		}
+	}
+}
+
+// evalStoredFields sets the stored fields of item and drops the virtual ones.
+func evalStoredFields(fields []computedField, item GenericItem) error {
+	for i := range fields {
+		if fields[i].virtual {
+			delete(item, fields[i].field)
+
+			continue
+		}
+
+		v, err := fields[i].expr.Eval(item)
+		if err != nil {
+			return FieldError{Field: fields[i].field, Reason: err.Error()}
+		}
+
+		item[fields[i].field] = v
+	}
+
+	return nil
+}
+
+func evalVirtualFields(fields []computedField, item GenericItem) {
+	for i := range fields {
+		if !fields[i].virtual {
+			continue
+		}
+
+		v, err := fields[i].expr.Eval(item)
+		if err != nil {
+			v = nil
+		}
+
+		item[fields[i].field] = v
+	}
+}
+
+// rejectComputedFields refuses items from clients which set computed fields of groupKind. Fields which keep the
+// value the item of id has are let through, so clients can write back what they read.
+func rejectComputedFields(ctx context.Context, svc Service, groupKind string, id string, item GenericItem) error {
+	c, ok := Lookup[*ComputedFields](svc)
+	if !ok {
+		return nil
+	}
+
+	fields, err := c.fieldsOf(ctx, groupKind)
+	if err != nil {
+		return err
+	}
+
+	var (
+		old  GenericItem
+		read bool
+	)
+
+	for i := range fields {
+		v, ok := item[fields[i].field]
+		if !ok {
+			continue
+		}
+
+		if !read && id != "" {
+			old, err = svc.Read(ctx, groupKind, id)
+			if err != nil && !errors.As(err, &ItemNotFoundError{}) {
+				return err
+			}
+
+			read = true
+		}
+
+		if old == nil || !reflect.DeepEqual(normalizeJSONValue(v), normalizeJSONValue(old[fields[i].field])) {
+			return FieldError{Field: fields[i].field, Reason: "is computed and can not be set"}
+		}
+	}
+
+	return nil
+}
+
+// rejectChangedComputedFields wraps fn, which changes items of groupKind for clients, to refuse results changing
+// computed fields. The fields are looked up right away, so fn may run in a transaction.
+func rejectChangedComputedFields(ctx context.Context, svc Service, groupKind string, fn func(item GenericItem) (GenericItem, error)) (func(item GenericItem) (GenericItem, error), error) {
+	c, ok := Lookup[*ComputedFields](svc)
+	if !ok {
+		return fn, nil
+	}
+
+	fields, err := c.fieldsOf(ctx, groupKind)
+	if err != nil || len(fields) == 0 {
+		return fn, err
+	}
+
+	return func(item GenericItem) (GenericItem, error) {
+		old := item.DeepCopy()
+
+		res, err := fn(item)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range fields {
+			v, ok := res[fields[i].field]
+			if !ok {
+				continue
+			}
+
+			if !reflect.DeepEqual(normalizeJSONValue(v), normalizeJSONValue(old[fields[i].field])) {
+				return nil, FieldError{Field: fields[i].field, Reason: "is computed and can not be set"}
+			}
+		}
+
+		return res, nil
+	}, nil
+}
+
+// rejectComputedPaths refuses increments of groupKind, whose dot separated paths lead into computed fields.
+func rejectComputedPaths(ctx context.Context, svc Service, groupKind string, deltas map[string]float64) error {
+	c, ok := Lookup[*ComputedFields](svc)
+	if !ok {
+		return nil
+	}
+
+	fields, err := c.fieldsOf(ctx, groupKind)
+	if err != nil {
+		return err
+	}
+
+	for path := range deltas {
+		field, _, _ := strings.Cut(path, ".")
+
+		for i := range fields {
+			if fields[i].field == field {
+				return FieldError{Field: path, Reason: "is computed and can not be set"}
+			}
+		}
+	}
+
+	return nil
+}
+
+func NewComputedFields(next Service) *ComputedFields {
+	return &ComputedFields{
+		next:  next,
+		exprs: make(map[string]compiledExpr),
+	}
+}
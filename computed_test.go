@@ -0,0 +1,154 @@
+package core_test
+
+import (
+	"context"
+	"github.com/applicaset/core"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+var _ = Describe("Computed fields", func() {
+	var (
+		store *core.Store
+		h     *core.Handler
+	)
+
+	BeforeEach(func() {
+		store = core.NewStore()
+		h = core.NewHandler(core.NewComputedFields(core.NewAutoFields(store)))
+	})
+
+	define := func(body string) {
+		res := doRequest(h, http.MethodPost, "/core/computedfields", body)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+	}
+
+	It("should store fields computed on write and keep them read-only", func() {
+		define(`{"id":"total","groupKind":"acme/orders","field":"total","expr":"sum(lines[*].price * lines[*].qty)"}`)
+
+		res := doRequest(h, http.MethodPost, "/acme/orders", `{"id":"o1","lines":[{"price":2.5,"qty":2},{"price":4,"qty":1}]}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+		Expect(decodeBody(res)["total"]).Should(BeEquivalentTo(9))
+
+		res = doRequest(h, http.MethodPost, "/acme/orders", `{"id":"o2","lines":[],"total":100}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+		Expect(decodeBody(res)["error"]).Should(ContainSubstring("total"))
+
+		res = doRequest(h, http.MethodPut, "/acme/orders/o1", `{"id":"o1","lines":[{"price":1,"qty":3}],"total":100}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+
+		// what was read can be written back
+		res = doRequest(h, http.MethodPut, "/acme/orders/o1", `{"id":"o1","lines":[{"price":1,"qty":3}],"total":9}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusNoContent))
+
+		item, err := store.Read(context.Background(), "acme/orders", "o1")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(item["total"]).Should(BeEquivalentTo(3))
+	})
+
+	It("should keep computed fields read-only for patches, applies and increments", func() {
+		define(`{"id":"total","groupKind":"acme/orders","field":"total","expr":"sum(lines[*].price * lines[*].qty)"}`)
+
+		res := doRequest(h, http.MethodPost, "/acme/orders", `{"id":"o1","lines":[{"price":2,"qty":1}]}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		patch := func(target string, contentType string, body string) *http.Response {
+			req := httptest.NewRequest(http.MethodPatch, target, strings.NewReader(body))
+			req.Header.Set("Content-Type", contentType)
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			return w.Result()
+		}
+
+		res = patch("/acme/orders/o1", core.MergePatchType, `{"total":100}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+		Expect(decodeBody(res)["error"]).Should(ContainSubstring("total"))
+
+		res = patch("/acme/orders/o1", core.JSONPatchType, `[{"op":"replace","path":"/total","value":100}]`)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+
+		res = patch("/acme/orders?filter=true", core.MergePatchType, `{"total":100}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+
+		res = patch("/acme/orders/o1?fieldManager=m1", core.ApplyPatchType, `{"id":"o1","total":100}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+
+		res = doRequest(h, http.MethodPost, "/acme/orders/o1/_increment", `{"total":1}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+
+		res = patch("/acme/orders/o1", core.MergePatchType, `{"lines":[{"price":2,"qty":3}]}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+		Expect(decodeBody(res)["total"]).Should(BeEquivalentTo(6))
+
+		item, err := store.Read(context.Background(), "acme/orders", "o1")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(item["total"]).Should(BeEquivalentTo(6))
+	})
+
+	It("should compute virtual fields on read without storing them", func() {
+		define(`{"id":"lineCount","groupKind":"acme/orders","field":"lineCount","expr":"len(lines)","mode":"virtual"}`)
+
+		res := doRequest(h, http.MethodPost, "/acme/orders", `{"id":"o1","lines":[{"price":1,"qty":1}]}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+		Expect(decodeBody(res)["lineCount"]).Should(BeEquivalentTo(1))
+
+		res = doRequest(h, http.MethodGet, "/acme/orders/o1", "")
+		Expect(decodeBody(res)["lineCount"]).Should(BeEquivalentTo(1))
+
+		item, err := store.Read(context.Background(), "acme/orders", "o1")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(item).ShouldNot(HaveKey("lineCount"))
+
+		res = doRequest(h, http.MethodPost, "/acme/orders", `{"id":"o2","lineCount":3}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+	})
+
+	It("should recompute stored fields when definitions change", func() {
+		res := doRequest(h, http.MethodPost, "/acme/orders", `{"id":"o1","lines":[{"price":2,"qty":3}]}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		res = doRequest(h, http.MethodPost, "/acme/orders", `{"id":"o2"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		define(`{"id":"total","groupKind":"acme/orders","field":"total","expr":"sum(lines[*].price * lines[*].qty)"}`)
+
+		item, err := store.Read(context.Background(), "acme/orders", "o1")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(item["total"]).Should(BeEquivalentTo(6))
+
+		item, err = store.Read(context.Background(), "acme/orders", "o2")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(item["total"]).Should(BeEquivalentTo(0))
+
+		res = doRequest(h, http.MethodPut, "/core/computedfields/total", `{"id":"total","groupKind":"acme/orders","field":"gross","expr":"sum(lines[*].price * lines[*].qty) * 2"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusNoContent))
+
+		item, err = store.Read(context.Background(), "acme/orders", "o1")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(item).ShouldNot(HaveKey("total"))
+		Expect(item["gross"]).Should(BeEquivalentTo(12))
+
+		res = doRequest(h, http.MethodDelete, "/core/computedfields/total", "")
+		Expect(res.StatusCode).Should(BeNumerically("<", 300))
+
+		item, err = store.Read(context.Background(), "acme/orders", "o1")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(item).ShouldNot(HaveKey("gross"))
+	})
+
+	It("should validate definitions", func() {
+		res := doRequest(h, http.MethodPost, "/core/computedfields", `{"id":"c1","groupKind":"acme/orders","field":"total","expr":"sum("}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+
+		res = doRequest(h, http.MethodPost, "/core/computedfields", `{"id":"c1","groupKind":"acme/orders","field":"id","expr":"1"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+
+		res = doRequest(h, http.MethodPost, "/core/computedfields", `{"id":"c1","groupKind":"acme/orders","field":"total","expr":"1","mode":"lazy"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+	})
+})
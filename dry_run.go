@@ -0,0 +1,32 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+)
+
+type dryRunKey struct{}
+
+// WithDryRun marks the operations made with ctx as dry runs. They pass through the whole chain,
+// but the terminal Service only checks them without writing anything.
+// Decorators with side effects beyond the Service are expected to skip them as well.
+func WithDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, true)
+}
+
+func IsDryRun(ctx context.Context) bool {
+	dryRun, _ := ctx.Value(dryRunKey{}).(bool)
+
+	return dryRun
+}
+
+// requestContext returns the context of r, marked as dry run if the dryRun query parameter is set.
+func requestContext(r *http.Request) context.Context {
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+	if dryRun {
+		return WithDryRun(r.Context())
+	}
+
+	return r.Context()
+}
@@ -0,0 +1,125 @@
+package core_test
+
+import (
+	"bytes"
+	"github.com/applicaset/core"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+)
+
+var _ = Describe("Dry run", func() {
+	var h *core.Handler
+
+	BeforeEach(func() {
+		var svc core.Service
+		svc = core.NewStore()
+		seq := core.NewSequences(svc)
+		svc = core.NewAutoFields(svc)
+		svc = core.NewIDPolicies(svc, core.IDPolicy{Generate: core.SequenceID(seq)})
+		svc = core.NewFinalizers(svc)
+		svc = core.NewGarbageCollector(svc, 0)
+
+		h = core.NewHandler(svc)
+
+		res := doRequest(h, http.MethodPost, "/acme/foo", `{"id":"foo1","bar":"baz"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+	})
+
+	It("should return created item without storing it", func() {
+		res := doRequest(h, http.MethodPost, "/acme/foo?dryRun=true", `{"bar":"qux"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+		Expect(res.Header.Get("ETag")).Should(BeEmpty())
+
+		body := decodeBody(res)
+		Expect(body).Should(HaveKeyWithValue("id", "1"))
+		Expect(body).Should(HaveKey("uuid"))
+		Expect(body).Should(HaveKey("createdAt"))
+		Expect(body).ShouldNot(HaveKey("resourceVersion"))
+
+		res = doRequest(h, http.MethodGet, "/acme/foo/1", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNotFound))
+
+		// the dry run has not taken a value of the sequence
+		res = doRequest(h, http.MethodPost, "/acme/foo", `{"bar":"qux"}`)
+		Expect(decodeBody(res)).Should(HaveKeyWithValue("id", "1"))
+	})
+
+	It("should validate created items", func() {
+		res := doRequest(h, http.MethodPost, "/acme/foo?dryRun=true", `{"id":"foo1"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusConflict))
+
+		res = doRequest(h, http.MethodPost, "/acme/foo?dryRun=true", `{"id":"-foo"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusBadRequest))
+	})
+
+	It("should return replaced item without storing it", func() {
+		res := doRequest(h, http.MethodPut, "/acme/foo/foo1?dryRun=true", `{"id":"foo1","bar":"qux"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+
+		body := decodeBody(res)
+		Expect(body).Should(HaveKeyWithValue("bar", "qux"))
+		Expect(body).Should(HaveKey("updatedAt"))
+
+		body = decodeBody(doRequest(h, http.MethodGet, "/acme/foo/foo1", ""))
+		Expect(body).Should(HaveKeyWithValue("bar", "baz"))
+	})
+
+	It("should not patch items", func() {
+		req := httptest.NewRequest(http.MethodPatch, "/acme/foo/foo1?dryRun=true", bytes.NewBufferString(`{"bar":"qux"}`))
+		req.Header.Set("Content-Type", core.MergePatchType)
+
+		w := httptest.NewRecorder()
+
+		h.ServeHTTP(w, req)
+
+		res := w.Result()
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+		Expect(decodeBody(res)).Should(HaveKeyWithValue("bar", "qux"))
+
+		body := decodeBody(doRequest(h, http.MethodGet, "/acme/foo/foo1", ""))
+		Expect(body).Should(HaveKeyWithValue("bar", "baz"))
+	})
+
+	It("should not delete items", func() {
+		res := doRequest(h, http.MethodDelete, "/acme/foo/foo1?dryRun=true", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNoContent))
+
+		res = doRequest(h, http.MethodGet, "/acme/foo/foo1", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+
+		res = doRequest(h, http.MethodDelete, "/acme/foo/foo2?dryRun=true", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNotFound))
+
+		res = doRequest(h, http.MethodDelete, "/acme/foo/foo1?dryRun=true&resourceVersion=0", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusConflict))
+	})
+
+	It("should not mark items with finalizers for deletion", func() {
+		res := doRequest(h, http.MethodPost, "/acme/foo", `{"id":"foo2","finalizers":["acme/cleanup"]}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		res = doRequest(h, http.MethodDelete, "/acme/foo/foo2?dryRun=true", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusAccepted))
+		Expect(decodeBody(res)).Should(HaveKey("deletionTimestamp"))
+
+		res = doRequest(h, http.MethodGet, "/acme/foo/foo2", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+		Expect(decodeBody(res)).ShouldNot(HaveKey("deletionTimestamp"))
+	})
+
+	It("should run batches as dry runs", func() {
+		res := doRequest(h, http.MethodPost, "/_batch?dryRun=true", `{"operations":[
+			{"op":"create","group":"acme","kind":"bar","body":{"id":"bar1"}},
+			{"op":"delete","group":"acme","kind":"foo","id":"foo1"}
+		]}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+
+		res = doRequest(h, http.MethodGet, "/acme/bar", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNotFound))
+
+		res = doRequest(h, http.MethodGet, "/acme/foo/foo1", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+	})
+})
@@ -26,3 +26,37 @@ type GroupKindNotFoundError struct {
 func (err GroupKindNotFoundError) Error() string {
 	return fmt.Sprintf("kind with group '%s' and name '%s' not found", err.Group, err.Kind)
 }
+
+type ConflictError struct {
+	ID string
+}
+
+func (err ConflictError) Error() string {
+	return fmt.Sprintf("item with id '%s' has been modified", err.ID)
+}
+
+type PreconditionFailedError struct {
+	ID string
+}
+
+func (err PreconditionFailedError) Error() string {
+	return fmt.Sprintf("precondition failed for item with id '%s'", err.ID)
+}
+
+type InvalidIDError struct {
+	ID     interface{}
+	Reason string
+}
+
+func (err InvalidIDError) Error() string {
+	return fmt.Sprintf("invalid id '%v': %s", err.ID, err.Reason)
+}
+
+type FieldError struct {
+	Field  string
+	Reason string
+}
+
+func (err FieldError) Error() string {
+	return fmt.Sprintf("invalid field '%s': %s", err.Field, err.Reason)
+}
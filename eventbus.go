@@ -0,0 +1,471 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+)
+
+// EventHandler handles an event published by an EventBus.
+type EventHandler func(ctx context.Context, e Event) error
+
+type subscriberOptions struct {
+	async   bool
+	workers int
+	onError func(ctx context.Context, e Event, err error)
+}
+
+type SubscriberOption func(o *subscriberOptions)
+
+// Async delivers events in the background by workers goroutines instead of before the write returns.
+// Events of an item are always handled by the same worker, so they keep their order.
+func Async(workers int) SubscriberOption {
+	return func(o *subscriberOptions) {
+		o.async = true
+		o.workers = workers
+	}
+}
+
+// WithErrorHandler is called with the errors and panics of the handler of a subscriber. By default they are dropped.
+func WithErrorHandler(fn func(ctx context.Context, e Event, err error)) SubscriberOption {
+	return func(o *subscriberOptions) {
+		o.onError = fn
+	}
+}
+
+// EventBus publishes the writes made through it to the Go handlers subscribed to their kinds, once they are committed.
+// Events of the same item reach every subscriber in the order the writes committed.
+//
+// Synchronous subscribers are called before the write returns, one after the other in the order they subscribed.
+// If events of the item are already being delivered by another goroutine, the write waits for that goroutine
+// to deliver its event too. Handlers may write to the item of their event, as long as they use the context they get.
+// Writes made in a transaction are delivered in the background once it commits.
+type EventBus struct {
+	next Service
+
+	mu     sync.Mutex
+	subs   map[string][]*subscriber
+	queues map[string]*itemQueue
+}
+
+type subscriber struct {
+	groupKind string
+	types     []EventType
+	fn        EventHandler
+	opts      subscriberOptions
+	workers   []*eventQueue
+}
+
+// busDelivery is an event on its way to the synchronous subscribers.
+type busDelivery struct {
+	event Event
+	subs  []*subscriber
+	done  chan struct{}
+}
+
+type itemQueue struct {
+	deliveries []*busDelivery
+	running    bool
+}
+
+type deliveringKey struct{}
+
+func itemKey(groupKind string, id string) string {
+	return groupKind + "/" + id
+}
+
+func (b *EventBus) List(ctx context.Context, groupKind string) ([]GenericItem, error) {
+	return b.next.List(ctx, groupKind)
+}
+
+func (b *EventBus) Create(ctx context.Context, groupKind string, req GenericItem) error {
+	return b.write(ctx, func(ctx context.Context) (*busDelivery, error) {
+		err := b.next.Create(ctx, groupKind, req)
+		if err != nil {
+			return nil, err
+		}
+
+		return b.publish(ctx, EventAdded, groupKind, req), nil
+	})
+}
+
+func (b *EventBus) Read(ctx context.Context, groupKind string, id string) (GenericItem, error) {
+	return b.next.Read(ctx, groupKind, id)
+}
+
+func (b *EventBus) Replace(ctx context.Context, groupKind string, id string, req GenericItem) error {
+	return b.write(ctx, func(ctx context.Context) (*busDelivery, error) {
+		err := b.next.Replace(ctx, groupKind, id, req)
+		if err != nil {
+			return nil, err
+		}
+
+		return b.publish(ctx, EventModified, groupKind, req), nil
+	})
+}
+
+func (b *EventBus) Delete(ctx context.Context, groupKind string, id string) error {
+	return b.write(ctx, func(ctx context.Context) (*busDelivery, error) {
+		item, err := b.next.Read(ctx, groupKind, id)
+		if err != nil {
+			return nil, err
+		}
+
+		err = b.next.Delete(ctx, groupKind, id)
+		if err != nil {
+			return nil, err
+		}
+
+		// items with finalizers are only marked for deletion
+		current, err := b.next.Read(ctx, groupKind, id)
+		if err == nil {
+			return b.publish(ctx, EventModified, groupKind, current), nil
+		}
+
+		return b.publish(ctx, EventDeleted, groupKind, item), nil
+	})
+}
+
+func (b *EventBus) Unwrap() Service {
+	return b.next
+}
+
+var _ Service = new(EventBus)
+
+// Subscribe calls fn with the events of groupKind, or only with the ones of types if it is not empty.
+// It returns a function which ends the subscription.
+func (b *EventBus) Subscribe(groupKind string, types []EventType, fn EventHandler, opts ...SubscriberOption) func() {
+	sub := &subscriber{
+		groupKind: groupKind,
+		types:     types,
+		fn:        fn,
+	}
+
+	for i := range opts {
+		opts[i](&sub.opts)
+	}
+
+	if sub.opts.async {
+		if sub.opts.workers <= 0 {
+			sub.opts.workers = 1
+		}
+
+		sub.workers = make([]*eventQueue, sub.opts.workers)
+
+		for i := range sub.workers {
+			sub.workers[i] = newEventQueue()
+
+			go sub.work(sub.workers[i])
+		}
+	}
+
+	b.mu.Lock()
+	b.subs[groupKind] = append(b.subs[groupKind], sub)
+	b.mu.Unlock()
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+
+			subs := b.subs[groupKind]
+
+			for i := range subs {
+				if subs[i] == sub {
+					b.subs[groupKind] = append(subs[:i:i], subs[i+1:]...)
+
+					break
+				}
+			}
+
+			for i := range sub.workers {
+				sub.workers[i].close()
+			}
+		})
+	}
+}
+
+func (b *EventBus) OnCreated(groupKind string, fn func(ctx context.Context, item GenericItem) error, opts ...SubscriberOption) func() {
+	return b.Subscribe(groupKind, []EventType{EventAdded}, itemHandler(fn), opts...)
+}
+
+// OnUpdated is also called for items marked for deletion which wait for their finalizers.
+func (b *EventBus) OnUpdated(groupKind string, fn func(ctx context.Context, item GenericItem) error, opts ...SubscriberOption) func() {
+	return b.Subscribe(groupKind, []EventType{EventModified}, itemHandler(fn), opts...)
+}
+
+// OnDeleted is called with the item as it was before it was deleted.
+func (b *EventBus) OnDeleted(groupKind string, fn func(ctx context.Context, item GenericItem) error, opts ...SubscriberOption) func() {
+	return b.Subscribe(groupKind, []EventType{EventDeleted}, itemHandler(fn), opts...)
+}
+
+func itemHandler(fn func(ctx context.Context, item GenericItem) error) EventHandler {
+	return func(ctx context.Context, e Event) error {
+		return fn(ctx, e.Object)
+	}
+}
+
+func (sub *subscriber) wants(eventType EventType) bool {
+	if len(sub.types) == 0 {
+		return true
+	}
+
+	for i := range sub.types {
+		if sub.types[i] == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handle calls the handler of sub, turning its panics into errors.
+func (sub *subscriber) handle(ctx context.Context, e Event) {
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("event handler panicked: %v", r)
+			}
+		}()
+
+		e.Object = e.Object.DeepCopy()
+
+		return sub.fn(ctx, e)
+	}()
+
+	if err != nil && sub.opts.onError != nil {
+		sub.opts.onError(ctx, e, err)
+	}
+}
+
+func (sub *subscriber) work(q *eventQueue) {
+	for {
+		e, ok := q.pop()
+		if !ok {
+			return
+		}
+
+		sub.handle(context.Background(), e)
+	}
+}
+
+// write runs fn in a transaction and delivers the event it publishes to the synchronous subscribers.
+func (b *EventBus) write(ctx context.Context, fn func(ctx context.Context) (*busDelivery, error)) error {
+	var delivery *busDelivery
+
+	err := inTx(ctx, b.next, func(ctx context.Context) error {
+		var err error
+
+		delivery, err = fn(ctx)
+
+		return err
+	})
+	if err != nil || delivery == nil {
+		return err
+	}
+
+	if _, ok := ctx.Value(afterCommitKey{}).(afterCommitter); ok {
+		// the surrounding transaction commits after the write returns, so its events are delivered in the background
+		AfterCommit(ctx, func() {
+			go b.deliver(context.Background(), delivery)
+		})
+
+		return nil
+	}
+
+	b.deliver(ctx, delivery)
+
+	return nil
+}
+
+// publish hands the event of a write over to the subscribers once it is committed.
+// Asynchronous subscribers get it right away, synchronous ones through the returned delivery.
+func (b *EventBus) publish(ctx context.Context, eventType EventType, groupKind string, item GenericItem) *busDelivery {
+	if IsDryRun(ctx) {
+		return nil
+	}
+
+	delivery := &busDelivery{
+		event: Event{
+			Type:            eventType,
+			GroupKind:       groupKind,
+			ID:              item.GetID(),
+			ResourceVersion: ResourceVersionOf(item),
+			Object:          item.DeepCopy(),
+		},
+		done: make(chan struct{}),
+	}
+
+	// commit callbacks run in commit order, so this is where the order of events is settled
+	AfterCommit(ctx, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		key := itemKey(groupKind, delivery.event.ID)
+
+		for _, sub := range b.subs[groupKind] {
+			if !sub.wants(eventType) {
+				continue
+			}
+
+			if sub.opts.async {
+				h := fnv.New32a()
+				_, _ = h.Write([]byte(key))
+
+				sub.workers[h.Sum32()%uint32(len(sub.workers))].push(delivery.event)
+
+				continue
+			}
+
+			delivery.subs = append(delivery.subs, sub)
+		}
+
+		if len(delivery.subs) == 0 {
+			close(delivery.done)
+
+			return
+		}
+
+		q, ok := b.queues[key]
+		if !ok {
+			q = new(itemQueue)
+			b.queues[key] = q
+		}
+
+		q.deliveries = append(q.deliveries, delivery)
+	})
+
+	return delivery
+}
+
+// deliver calls the synchronous subscribers of the committed events of the item of d, in order, unless another
+// goroutine does already. It returns when d is delivered, or right away if ctx is delivering an event of the item.
+func (b *EventBus) deliver(ctx context.Context, d *busDelivery) {
+	if d == nil {
+		return
+	}
+
+	key := itemKey(d.event.GroupKind, d.event.ID)
+
+	b.mu.Lock()
+
+	q, ok := b.queues[key]
+	if !ok || q.running {
+		b.mu.Unlock()
+
+		if delivering, _ := ctx.Value(deliveringKey{}).(map[string]bool); delivering[key] {
+			return
+		}
+
+		// d is either delivered already or being delivered by another goroutine
+		select {
+		case <-d.done:
+		case <-ctx.Done():
+		}
+
+		return
+	}
+
+	q.running = true
+
+	delivering, _ := ctx.Value(deliveringKey{}).(map[string]bool)
+	nested := make(map[string]bool, len(delivering)+1)
+
+	for k := range delivering {
+		nested[k] = true
+	}
+
+	nested[key] = true
+	handlerCtx := context.WithValue(ctx, deliveringKey{}, nested)
+
+	for len(q.deliveries) > 0 {
+		next := q.deliveries[0]
+		q.deliveries = q.deliveries[1:]
+
+		b.mu.Unlock()
+
+		for _, sub := range next.subs {
+			sub.handle(handlerCtx, next.event)
+		}
+
+		close(next.done)
+
+		b.mu.Lock()
+	}
+
+	delete(b.queues, key)
+
+	b.mu.Unlock()
+}
+
+func NewEventBus(next Service) *EventBus {
+	return &EventBus{
+		next:   next,
+		subs:   make(map[string][]*subscriber),
+		queues: make(map[string]*itemQueue),
+	}
+}
+
+// eventQueue is an unbounded queue of events, so publishing never waits for slow subscribers.
+type eventQueue struct {
+	mu     sync.Mutex
+	events []Event
+	closed bool
+	ready  chan struct{}
+}
+
+func (q *eventQueue) push(e Event) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return
+	}
+
+	q.events = append(q.events, e)
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (q *eventQueue) pop() (Event, bool) {
+	for {
+		q.mu.Lock()
+
+		if q.closed {
+			q.mu.Unlock()
+
+			return Event{}, false
+		}
+
+		if len(q.events) > 0 {
+			e := q.events[0]
+			q.events = q.events[1:]
+			q.mu.Unlock()
+
+			return e, true
+		}
+
+		q.mu.Unlock()
+
+		<-q.ready
+	}
+}
+
+func (q *eventQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+
+	close(q.ready)
+}
+
+func newEventQueue() *eventQueue {
+	return &eventQueue{ready: make(chan struct{}, 1)}
+}
@@ -0,0 +1,230 @@
+package core_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/applicaset/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEventBusDeliversBeforeWriteReturns(t *testing.T) {
+	ctx := context.Background()
+
+	bus := core.NewEventBus(core.NewStore())
+
+	var created, deleted []string
+
+	bus.OnCreated("acme/orders", func(ctx context.Context, item core.GenericItem) error {
+		created = append(created, item.GetID())
+
+		return nil
+	})
+
+	bus.OnDeleted("acme/orders", func(ctx context.Context, item core.GenericItem) error {
+		deleted = append(deleted, fmt.Sprint(item["total"]))
+
+		return nil
+	})
+
+	require.NoError(t, bus.Create(ctx, "acme/orders", core.GenericItem{"id": "o1", "total": 10}))
+	assert.Equal(t, []string{"o1"}, created)
+
+	require.NoError(t, bus.Create(core.WithDryRun(ctx), "acme/orders", core.GenericItem{"id": "o2"}))
+	require.NoError(t, bus.Create(ctx, "acme/invoices", core.GenericItem{"id": "i1"}))
+	require.NoError(t, bus.Replace(ctx, "acme/orders", "o1", core.GenericItem{"id": "o1", "total": 20}))
+	assert.Equal(t, []string{"o1"}, created)
+
+	require.NoError(t, bus.Delete(ctx, "acme/orders", "o1"))
+	assert.Equal(t, []string{"20"}, deleted)
+
+	assert.Error(t, bus.Delete(ctx, "acme/orders", "o1"))
+	assert.Len(t, deleted, 1)
+}
+
+func TestEventBusHandlesErrorsPerSubscriber(t *testing.T) {
+	ctx := context.Background()
+
+	bus := core.NewEventBus(core.NewStore())
+
+	var errs []error
+
+	bus.OnCreated("acme/orders", func(ctx context.Context, item core.GenericItem) error {
+		return errors.New("failed")
+	}, core.WithErrorHandler(func(ctx context.Context, e core.Event, err error) {
+		errs = append(errs, err)
+	}))
+
+	bus.OnCreated("acme/orders", func(ctx context.Context, item core.GenericItem) error {
+		panic("oops")
+	}, core.WithErrorHandler(func(ctx context.Context, e core.Event, err error) {
+		errs = append(errs, err)
+	}))
+
+	called := false
+
+	unsubscribe := bus.OnCreated("acme/orders", func(ctx context.Context, item core.GenericItem) error {
+		called = true
+
+		return nil
+	})
+
+	require.NoError(t, bus.Create(ctx, "acme/orders", core.GenericItem{"id": "o1"}))
+	require.Len(t, errs, 2)
+	assert.EqualError(t, errs[0], "failed")
+	assert.EqualError(t, errs[1], "event handler panicked: oops")
+	assert.True(t, called)
+
+	called = false
+
+	unsubscribe()
+
+	require.NoError(t, bus.Create(ctx, "acme/orders", core.GenericItem{"id": "o2"}))
+	assert.False(t, called)
+}
+
+func TestEventBusDeliversTransactionsOnCommit(t *testing.T) {
+	ctx := context.Background()
+
+	bus := core.NewEventBus(core.NewStore())
+
+	delivered := make(chan string, 10)
+
+	bus.OnCreated("acme/orders", func(ctx context.Context, item core.GenericItem) error {
+		delivered <- item.GetID()
+
+		return nil
+	})
+
+	err := core.Tx(ctx, bus, func(ctx context.Context) error {
+		err := bus.Create(ctx, "acme/orders", core.GenericItem{"id": "o1"})
+		if err != nil {
+			return err
+		}
+
+		return errors.New("rolled back")
+	})
+	require.Error(t, err)
+
+	err = core.Tx(ctx, bus, func(ctx context.Context) error {
+		return bus.Create(ctx, "acme/orders", core.GenericItem{"id": "o2"})
+	})
+	require.NoError(t, err)
+
+	select {
+	case id := <-delivered:
+		assert.Equal(t, "o2", id)
+	case <-time.After(time.Second):
+		t.Fatal("event of the committed transaction is not delivered")
+	}
+
+	assert.Empty(t, delivered)
+}
+
+func TestEventBusKeepsOrderPerItem(t *testing.T) {
+	ctx := context.Background()
+
+	bus := core.NewEventBus(core.NewStore())
+
+	var (
+		mu    sync.Mutex
+		syncs = make(map[string][]int)
+		async = make(map[string][]int)
+		wg    sync.WaitGroup
+	)
+
+	record := func(seen map[string][]int) core.EventHandler {
+		return func(ctx context.Context, e core.Event) error {
+			rv, err := strconv.Atoi(e.ResourceVersion)
+			require.NoError(t, err)
+
+			mu.Lock()
+			seen[e.ID] = append(seen[e.ID], rv)
+			mu.Unlock()
+
+			wg.Done()
+
+			return nil
+		}
+	}
+
+	bus.Subscribe("acme/counters", nil, record(syncs))
+	bus.Subscribe("acme/counters", nil, record(async), core.Async(4))
+
+	const items, writers, writes = 3, 4, 25
+
+	wg.Add(2 * items * (1 + writers*writes))
+
+	for i := 0; i < items; i++ {
+		require.NoError(t, bus.Create(ctx, "acme/counters", core.GenericItem{"id": fmt.Sprint("c", i), "n": 0}))
+	}
+
+	var writersWG sync.WaitGroup
+
+	for i := 0; i < items; i++ {
+		for j := 0; j < writers; j++ {
+			writersWG.Add(1)
+
+			go func(id string) {
+				defer writersWG.Done()
+
+				for k := 0; k < writes; k++ {
+					_, err := core.Increment(ctx, bus, "acme/counters", id, map[string]float64{"n": 1})
+					assert.NoError(t, err)
+				}
+			}(fmt.Sprint("c", i))
+		}
+	}
+
+	writersWG.Wait()
+	wg.Wait()
+
+	for _, seen := range []map[string][]int{syncs, async} {
+		require.Len(t, seen, items)
+
+		for id := range seen {
+			assert.Len(t, seen[id], 1+writers*writes)
+			assert.IsIncreasing(t, seen[id], id)
+		}
+	}
+}
+
+func TestEventBusHandlersWriteTheirItem(t *testing.T) {
+	ctx := context.Background()
+
+	bus := core.NewEventBus(core.NewStore())
+
+	var statuses []string
+
+	bus.OnUpdated("acme/orders", func(ctx context.Context, item core.GenericItem) error {
+		statuses = append(statuses, fmt.Sprint(item["status"]))
+
+		return nil
+	})
+
+	bus.OnCreated("acme/orders", func(ctx context.Context, item core.GenericItem) error {
+		item["status"] = "accepted"
+
+		return bus.Replace(ctx, "acme/orders", item.GetID(), item)
+	})
+
+	done := make(chan error)
+
+	go func() {
+		done <- bus.Create(ctx, "acme/orders", core.GenericItem{"id": "o1", "status": "new"})
+	}()
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("create does not return")
+	}
+
+	assert.Equal(t, []string{"accepted"}, statuses)
+}
@@ -0,0 +1,888 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expr is a parsed expression over the fields of an item, e.g. `status == "active" && spec.replicas > 2`.
+//
+// It supports field paths with dots and brackets, string, number, boolean, null and list literals,
+// the operators || && ! == != < <= > >= in + - * / % and the functions len, contains, startsWith, endsWith and sum.
+// Missing fields evaluate to null. The wildcard in `lines[*].price` collects a field of every element of a list,
+// and arithmetic on lists applies element-wise, so `sum(lines[*].price * lines[*].qty)` totals the lines.
+type Expr struct {
+	src  string
+	root exprNode
+}
+
+type InvalidExprError struct {
+	Expr   string
+	Reason string
+}
+
+func (err InvalidExprError) Error() string {
+	return fmt.Sprintf("invalid expression '%s': %s", err.Expr, err.Reason)
+}
+
+const (
+	maxExprLength = 16 << 10
+	maxExprDepth  = 64
+)
+
+// ParseExpr parses src, which may be up to 16KB long and nest up to 64 levels deep.
+func ParseExpr(src string) (*Expr, error) {
+	if len(src) > maxExprLength {
+		return nil, InvalidExprError{Expr: src[:64] + "...", Reason: fmt.Sprintf("longer than %d bytes", maxExprLength)}
+	}
+
+	p := exprParser{src: src}
+
+	err := p.tokenize()
+	if err != nil {
+		return nil, InvalidExprError{Expr: src, Reason: err.Error()}
+	}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, InvalidExprError{Expr: src, Reason: err.Error()}
+	}
+
+	if p.pos < len(p.tokens) {
+		return nil, InvalidExprError{Expr: src, Reason: fmt.Sprintf("unexpected '%s'", p.tokens[p.pos].text)}
+	}
+
+	return &Expr{src: src, root: root}, nil
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+func (e *Expr) Eval(item GenericItem) (interface{}, error) {
+	res, err := e.root.eval(map[string]interface{}(item))
+	if err != nil {
+		return nil, InvalidExprError{Expr: e.src, Reason: err.Error()}
+	}
+
+	return res, nil
+}
+
+// Match reports whether the expression evaluates to a truthy value for item.
+func (e *Expr) Match(item GenericItem) (bool, error) {
+	res, err := e.Eval(item)
+	if err != nil {
+		return false, err
+	}
+
+	return truthy(res), nil
+}
+
+func truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	default:
+		return true
+	}
+}
+
+type exprTokenKind int
+
+const (
+	tokenNumber exprTokenKind = iota
+	tokenString
+	tokenIdent
+	tokenOperator
+)
+
+type exprToken struct {
+	kind exprTokenKind
+	text string
+}
+
+type exprParser struct {
+	src    string
+	tokens []exprToken
+	pos    int
+	depth  int
+}
+
+var exprOperators = []string{"||", "&&", "==", "!=", "<=", ">=", "<", ">", "!", "+", "-", "*", "/", "%", "(", ")", "[", "]", ",", "."}
+
+func (p *exprParser) tokenize() error {
+	src := p.src
+
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+
+			p.tokens = append(p.tokens, exprToken{kind: tokenNumber, text: src[i:j]})
+			i = j
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(src) && rune(src[j]) != c {
+				if src[j] == '\\' {
+					j++
+				}
+
+				j++
+			}
+
+			if j >= len(src) {
+				return fmt.Errorf("unterminated string")
+			}
+
+			s := src[i : j+1]
+			if c == '\'' {
+				s = `"` + strings.ReplaceAll(strings.ReplaceAll(s[1:len(s)-1], `\'`, `'`), `"`, `\"`) + `"`
+			}
+
+			unquoted, err := strconv.Unquote(s)
+			if err != nil {
+				return fmt.Errorf("invalid string %s", src[i:j+1])
+			}
+
+			p.tokens = append(p.tokens, exprToken{kind: tokenString, text: unquoted})
+			i = j + 1
+		case c == '_' || c == '$' || unicode.IsLetter(c):
+			j := i
+			for j < len(src) && (src[j] == '_' || src[j] == '$' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+
+			p.tokens = append(p.tokens, exprToken{kind: tokenIdent, text: src[i:j]})
+			i = j
+		default:
+			matched := false
+
+			for _, op := range exprOperators {
+				if strings.HasPrefix(src[i:], op) {
+					p.tokens = append(p.tokens, exprToken{kind: tokenOperator, text: op})
+					i += len(op)
+					matched = true
+
+					break
+				}
+			}
+
+			if !matched {
+				return fmt.Errorf("unexpected character '%c'", c)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p *exprParser) peek(texts ...string) (string, bool) {
+	if p.pos >= len(p.tokens) {
+		return "", false
+	}
+
+	tok := p.tokens[p.pos]
+
+	for i := range texts {
+		if (tok.kind == tokenOperator || tok.kind == tokenIdent) && tok.text == texts[i] {
+			return tok.text, true
+		}
+	}
+
+	return "", false
+}
+
+func (p *exprParser) accept(texts ...string) (string, bool) {
+	text, ok := p.peek(texts...)
+	if ok {
+		p.pos++
+	}
+
+	return text, ok
+}
+
+func (p *exprParser) expect(text string) error {
+	if _, ok := p.accept(text); !ok {
+		if p.pos >= len(p.tokens) {
+			return fmt.Errorf("expected '%s' at end", text)
+		}
+
+		return fmt.Errorf("expected '%s' instead of '%s'", text, p.tokens[p.pos].text)
+	}
+
+	return nil
+}
+
+func (p *exprParser) parseBinary(next func() (exprNode, error), ops ...string) (exprNode, error) {
+	left, err := next()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op, ok := p.accept(ops...)
+		if !ok {
+			return left, nil
+		}
+
+		right, err := next()
+		if err != nil {
+			return nil, err
+		}
+
+		left = binaryNode{op: op, left: left, right: right}
+	}
+}
+
+// enter counts a level of nesting, which the caller leaves by calling leave.
+func (p *exprParser) enter() error {
+	p.depth++
+	if p.depth > maxExprDepth {
+		return fmt.Errorf("nested deeper than %d levels", maxExprDepth)
+	}
+
+	return nil
+}
+
+func (p *exprParser) leave() {
+	p.depth--
+}
+
+// parseOr parses a full expression, which is where groups, lists, arguments and indexes nest.
+func (p *exprParser) parseOr() (exprNode, error) {
+	defer p.leave()
+
+	err := p.enter()
+	if err != nil {
+		return nil, err
+	}
+
+	return p.parseBinary(p.parseAnd, "||")
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	return p.parseBinary(p.parseComparison, "&&")
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	return p.parseBinary(p.parseSum, "==", "!=", "<=", ">=", "<", ">", "in")
+}
+
+func (p *exprParser) parseSum() (exprNode, error) {
+	return p.parseBinary(p.parseProduct, "+", "-")
+}
+
+func (p *exprParser) parseProduct() (exprNode, error) {
+	return p.parseBinary(p.parseUnary, "*", "/", "%")
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if op, ok := p.accept("!", "-"); ok {
+		defer p.leave()
+
+		err := p.enter()
+		if err != nil {
+			return nil, err
+		}
+
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return unaryNode{op: op, operand: operand}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end")
+	}
+
+	tok := p.tokens[p.pos]
+	p.pos++
+
+	switch tok.kind {
+	case tokenNumber:
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number '%s'", tok.text)
+		}
+
+		return literalNode{value: f}, nil
+	case tokenString:
+		return literalNode{value: tok.text}, nil
+	case tokenIdent:
+		switch tok.text {
+		case "true":
+			return literalNode{value: true}, nil
+		case "false":
+			return literalNode{value: false}, nil
+		case "null":
+			return literalNode{value: nil}, nil
+		}
+
+		if _, ok := p.accept("("); ok {
+			return p.parseCall(tok.text)
+		}
+
+		return p.parsePath(tok.text)
+	}
+
+	switch tok.text {
+	case "(":
+		res, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		return res, p.expect(")")
+	case "[":
+		items, err := p.parseList("]")
+		if err != nil {
+			return nil, err
+		}
+
+		return listNode{items: items}, nil
+	default:
+		return nil, fmt.Errorf("unexpected '%s'", tok.text)
+	}
+}
+
+func (p *exprParser) parseList(end string) ([]exprNode, error) {
+	var res []exprNode
+
+	if _, ok := p.accept(end); ok {
+		return res, nil
+	}
+
+	for {
+		item, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, item)
+
+		if _, ok := p.accept(","); !ok {
+			return res, p.expect(end)
+		}
+	}
+}
+
+func (p *exprParser) parseCall(name string) (exprNode, error) {
+	fn, ok := exprFunctions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function '%s'", name)
+	}
+
+	args, err := p.parseList(")")
+	if err != nil {
+		return nil, err
+	}
+
+	return callNode{name: name, fn: fn, args: args}, nil
+}
+
+func (p *exprParser) parsePath(root string) (exprNode, error) {
+	res := pathNode{segments: []exprNode{literalNode{value: root}}}
+
+	for {
+		if _, ok := p.accept("."); ok {
+			if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokenIdent {
+				return nil, fmt.Errorf("expected field name after '.'")
+			}
+
+			res.segments = append(res.segments, literalNode{value: p.tokens[p.pos].text})
+			p.pos++
+
+			continue
+		}
+
+		if _, ok := p.accept("["); ok {
+			if _, ok := p.accept("*"); ok {
+				err := p.expect("]")
+				if err != nil {
+					return nil, err
+				}
+
+				res.segments = append(res.segments, wildcardNode{})
+
+				continue
+			}
+
+			index, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+
+			err = p.expect("]")
+			if err != nil {
+				return nil, err
+			}
+
+			res.segments = append(res.segments, index)
+
+			continue
+		}
+
+		return res, nil
+	}
+}
+
+type exprNode interface {
+	eval(item map[string]interface{}) (interface{}, error)
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (n literalNode) eval(map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+type listNode struct {
+	items []exprNode
+}
+
+func (n listNode) eval(item map[string]interface{}) (interface{}, error) {
+	res := make([]interface{}, len(n.items))
+
+	for i := range n.items {
+		v, err := n.items[i].eval(item)
+		if err != nil {
+			return nil, err
+		}
+
+		res[i] = v
+	}
+
+	return res, nil
+}
+
+type pathNode struct {
+	segments []exprNode
+}
+
+func (n pathNode) eval(item map[string]interface{}) (interface{}, error) {
+	return n.walk(item, item, n.segments)
+}
+
+// walk follows segments from node. A wildcard maps the rest of the segments over the elements of a list.
+func (n pathNode) walk(item map[string]interface{}, node interface{}, segments []exprNode) (interface{}, error) {
+	for i := range segments {
+		if _, ok := segments[i].(wildcardNode); ok {
+			list, _ := node.([]interface{})
+			res := make([]interface{}, len(list))
+
+			for j := range list {
+				v, err := n.walk(item, list[j], segments[i+1:])
+				if err != nil {
+					return nil, err
+				}
+
+				res[j] = v
+			}
+
+			return res, nil
+		}
+
+		key, err := segments[i].eval(item)
+		if err != nil {
+			return nil, err
+		}
+
+		switch parent := node.(type) {
+		case map[string]interface{}:
+			s, ok := key.(string)
+			if !ok {
+				return nil, nil
+			}
+
+			node = parent[s]
+		case []interface{}:
+			f, ok := key.(float64)
+			if !ok || f != math.Trunc(f) || f < 0 || int(f) >= len(parent) {
+				return nil, nil
+			}
+
+			node = parent[int(f)]
+		default:
+			return nil, nil
+		}
+	}
+
+	return normalizeJSONValue(node), nil
+}
+
+// wildcardNode is the [*] segment of a path.
+type wildcardNode struct{}
+
+func (wildcardNode) eval(map[string]interface{}) (interface{}, error) {
+	return nil, nil
+}
+
+type unaryNode struct {
+	op      string
+	operand exprNode
+}
+
+func (n unaryNode) eval(item map[string]interface{}) (interface{}, error) {
+	v, err := n.operand.eval(item)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.op == "!" {
+		return !truthy(v), nil
+	}
+
+	f, ok := v.(float64)
+	if !ok {
+		return nil, fmt.Errorf("can not negate %s", exprTypeOf(v))
+	}
+
+	return -f, nil
+}
+
+type binaryNode struct {
+	op          string
+	left, right exprNode
+}
+
+func (n binaryNode) eval(item map[string]interface{}) (interface{}, error) {
+	left, err := n.left.eval(item)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "&&":
+		if !truthy(left) {
+			return false, nil
+		}
+
+		right, err := n.right.eval(item)
+		if err != nil {
+			return nil, err
+		}
+
+		return truthy(right), nil
+	case "||":
+		if truthy(left) {
+			return true, nil
+		}
+
+		right, err := n.right.eval(item)
+		if err != nil {
+			return nil, err
+		}
+
+		return truthy(right), nil
+	}
+
+	right, err := n.right.eval(item)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return reflect.DeepEqual(left, right), nil
+	case "!=":
+		return !reflect.DeepEqual(left, right), nil
+	case "<", "<=", ">", ">=":
+		return compareValues(n.op, left, right), nil
+	case "in":
+		list, ok := right.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("can not look up in %s", exprTypeOf(right))
+		}
+
+		for i := range list {
+			if reflect.DeepEqual(left, list[i]) {
+				return true, nil
+			}
+		}
+
+		return false, nil
+	}
+
+	return arithmetic(n.op, left, right)
+}
+
+// arithmetic applies op to numbers, or to strings for +. Lists are combined element-wise, and a value which is not
+// a list is applied to every element of the other.
+func arithmetic(op string, left, right interface{}) (interface{}, error) {
+	ll, lok := left.([]interface{})
+	rl, rok := right.([]interface{})
+
+	if lok || rok {
+		if lok && rok && len(ll) != len(rl) {
+			return nil, fmt.Errorf("can not apply '%s' to lists of %d and %d items", op, len(ll), len(rl))
+		}
+
+		n := len(ll)
+		if rok {
+			n = len(rl)
+		}
+
+		res := make([]interface{}, n)
+
+		for i := range res {
+			l, r := left, right
+
+			if lok {
+				l = ll[i]
+			}
+
+			if rok {
+				r = rl[i]
+			}
+
+			v, err := arithmetic(op, l, r)
+			if err != nil {
+				return nil, err
+			}
+
+			res[i] = v
+		}
+
+		return res, nil
+	}
+
+	if op == "+" {
+		if ls, ok := left.(string); ok {
+			if rs, ok := right.(string); ok {
+				return ls + rs, nil
+			}
+		}
+	}
+
+	l, lok := left.(float64)
+	r, rok := right.(float64)
+
+	if !lok || !rok {
+		return nil, fmt.Errorf("can not apply '%s' to %s and %s", op, exprTypeOf(left), exprTypeOf(right))
+	}
+
+	switch op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+
+		return l / r, nil
+	default:
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+
+		return math.Mod(l, r), nil
+	}
+}
+
+// compareValues orders numbers and strings. Values of other or mismatched types are not ordered.
+func compareValues(op string, left, right interface{}) bool {
+	var cmp int
+
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return false
+		}
+
+		switch {
+		case l < r:
+			cmp = -1
+		case l > r:
+			cmp = 1
+		}
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return false
+		}
+
+		cmp = strings.Compare(l, r)
+	default:
+		return false
+	}
+
+	switch op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+type exprFunction func(args []interface{}) (interface{}, error)
+
+type callNode struct {
+	name string
+	fn   exprFunction
+	args []exprNode
+}
+
+func (n callNode) eval(item map[string]interface{}) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+
+	for i := range n.args {
+		v, err := n.args[i].eval(item)
+		if err != nil {
+			return nil, err
+		}
+
+		args[i] = v
+	}
+
+	res, err := n.fn(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", n.name, err.Error())
+	}
+
+	return res, nil
+}
+
+var exprFunctions = map[string]exprFunction{
+	"len": func(args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("expected 1 argument")
+		}
+
+		switch v := args[0].(type) {
+		case nil:
+			return float64(0), nil
+		case string:
+			return float64(len([]rune(v))), nil
+		case []interface{}:
+			return float64(len(v)), nil
+		case map[string]interface{}:
+			return float64(len(v)), nil
+		default:
+			return nil, fmt.Errorf("can not measure %s", exprTypeOf(v))
+		}
+	},
+	"contains": func(args []interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("expected 2 arguments")
+		}
+
+		switch v := args[0].(type) {
+		case nil:
+			return false, nil
+		case string:
+			s, ok := args[1].(string)
+
+			return ok && strings.Contains(v, s), nil
+		case []interface{}:
+			for i := range v {
+				if reflect.DeepEqual(v[i], args[1]) {
+					return true, nil
+				}
+			}
+
+			return false, nil
+		default:
+			return nil, fmt.Errorf("can not look up in %s", exprTypeOf(v))
+		}
+	},
+	"startsWith": stringPredicate(strings.HasPrefix),
+	"endsWith":   stringPredicate(strings.HasSuffix),
+	"sum": func(args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("expected 1 argument")
+		}
+
+		return sumValues(args[0])
+	},
+}
+
+// sumValues adds up the numbers of nested lists, skipping nulls.
+func sumValues(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		return v, nil
+	case []interface{}:
+		var res float64
+
+		for i := range v {
+			n, err := sumValues(normalizeJSONValue(v[i]))
+			if err != nil {
+				return 0, err
+			}
+
+			res += n
+		}
+
+		return res, nil
+	default:
+		return 0, fmt.Errorf("can not add up %s", exprTypeOf(v))
+	}
+}
+
+func stringPredicate(fn func(s, arg string) bool) exprFunction {
+	return func(args []interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("expected 2 arguments")
+		}
+
+		s, ok := args[0].(string)
+		arg, argOK := args[1].(string)
+
+		return ok && argOK && fn(s, arg), nil
+	}
+}
+
+func exprTypeOf(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
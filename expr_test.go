@@ -0,0 +1,96 @@
+package core_test
+
+import (
+	"github.com/applicaset/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestExprEval(t *testing.T) {
+	item := core.GenericItem{
+		"id":     "item1",
+		"status": "active",
+		"count":  int64(3),
+		"tags":   []interface{}{"a", "b"},
+		"spec": map[string]interface{}{
+			"replicas": float64(2),
+			"ports":    []interface{}{map[string]interface{}{"port": float64(80)}},
+		},
+		"lines": []interface{}{
+			map[string]interface{}{"price": float64(2.5), "qty": int64(2)},
+			map[string]interface{}{"price": float64(4), "qty": int64(1)},
+		},
+	}
+
+	tests := []struct {
+		expr string
+		want interface{}
+	}{
+		{`status == "active"`, true},
+		{`status != 'active'`, false},
+		{`count > 2 && spec.replicas <= 2`, true},
+		{`count < 2 || !(status == "active")`, false},
+		{`spec.ports[0].port == 80`, true},
+		{`spec["replicas"] * 2 + 1`, float64(5)},
+		{`count % 2`, float64(1)},
+		{`-count`, float64(-3)},
+		{`missing == null`, true},
+		{`missing.deep.field`, nil},
+		{`status in ["active", "pending"]`, true},
+		{`len(tags) == 2 && contains(tags, "b")`, true},
+		{`startsWith(id, "item") && endsWith(id, "1")`, true},
+		{`id + "-" + status`, "item1-active"},
+		{`"b" > "a"`, true},
+		{`status > 1`, false},
+		{`lines[*].qty`, []interface{}{float64(2), float64(1)}},
+		{`sum(lines[*].price * lines[*].qty)`, float64(9)},
+		{`sum(lines[*].price) * 2`, float64(13)},
+		{`lines[*].price + 1`, []interface{}{float64(3.5), float64(5)}},
+		{`sum(missing[*].price)`, float64(0)},
+	}
+
+	for _, tt := range tests {
+		e, err := core.ParseExpr(tt.expr)
+		require.NoError(t, err, tt.expr)
+
+		got, err := e.Eval(item)
+		require.NoError(t, err, tt.expr)
+		assert.Equal(t, tt.want, got, tt.expr)
+	}
+}
+
+func TestExprErrors(t *testing.T) {
+	for _, src := range []string{``, `status ==`, `(count`, `"open`, `unknown(1)`, `a.`, `count #`, `a[*`} {
+		_, err := core.ParseExpr(src)
+		assert.ErrorAs(t, err, &core.InvalidExprError{}, src)
+	}
+
+	for _, src := range []string{
+		strings.Repeat("(", 450000) + "1" + strings.Repeat(")", 450000),
+		strings.Repeat("(", 100) + "1" + strings.Repeat(")", 100),
+		strings.Repeat("[", 100) + strings.Repeat("]", 100),
+		strings.Repeat("!", 100) + "true",
+		strings.Repeat("len(", 100) + "a" + strings.Repeat(")", 100),
+		strings.Repeat("1 + ", 10000) + "1",
+	} {
+		_, err := core.ParseExpr(src)
+		assert.ErrorAs(t, err, &core.InvalidExprError{}, src[:8])
+	}
+
+	_, err := core.ParseExpr(strings.Repeat("(", 50) + "1" + strings.Repeat(")", 50))
+	require.NoError(t, err)
+
+	e, err := core.ParseExpr(`status * 2`)
+	require.NoError(t, err)
+
+	_, err = e.Eval(core.GenericItem{"status": "active"})
+	assert.ErrorAs(t, err, &core.InvalidExprError{})
+
+	e, err = core.ParseExpr(`[1, 2] * [3]`)
+	require.NoError(t, err)
+
+	_, err = e.Eval(core.GenericItem{})
+	assert.ErrorAs(t, err, &core.InvalidExprError{})
+}
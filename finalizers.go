@@ -0,0 +1,104 @@
+package core
+
+import (
+	"context"
+	"time"
+)
+
+func GetFinalizers(item GenericItem) []string {
+	finalizers, _ := item["finalizers"].([]interface{})
+
+	res := make([]string, 0, len(finalizers))
+
+	for i := range finalizers {
+		if s, ok := finalizers[i].(string); ok && s != "" {
+			res = append(res, s)
+		}
+	}
+
+	return res
+}
+
+func IsDeleting(item GenericItem) bool {
+	_, ok := item["deletionTimestamp"]
+
+	return ok
+}
+
+// Finalizers defers deletion of items which have finalizers.
+// Deleting such an item only sets its deletionTimestamp, and it is purged once a Replace removes the last finalizer.
+type Finalizers struct {
+	next Service
+}
+
+func (f *Finalizers) List(ctx context.Context, groupKind string) ([]GenericItem, error) {
+	return f.next.List(ctx, groupKind)
+}
+
+func (f *Finalizers) Create(ctx context.Context, groupKind string, req GenericItem) error {
+	delete(req, "deletionTimestamp")
+
+	return f.next.Create(ctx, groupKind, req)
+}
+
+func (f *Finalizers) Read(ctx context.Context, groupKind string, id string) (GenericItem, error) {
+	return f.next.Read(ctx, groupKind, id)
+}
+
+func (f *Finalizers) Replace(ctx context.Context, groupKind string, id string, req GenericItem) error {
+	return retryOnConflict(ResourceVersionOf(req) == "", func() error {
+		current, err := f.next.Read(ctx, groupKind, id)
+		if err != nil {
+			return err
+		}
+
+		ctx := withResourceVersion(ctx, ResourceVersionOf(current))
+
+		if !IsDeleting(current) {
+			delete(req, "deletionTimestamp")
+
+			return f.next.Replace(ctx, groupKind, id, req)
+		}
+
+		if len(GetFinalizers(req)) == 0 {
+			return f.next.Delete(ctx, groupKind, id)
+		}
+
+		req["deletionTimestamp"] = current["deletionTimestamp"]
+
+		return f.next.Replace(ctx, groupKind, id, req)
+	})
+}
+
+func (f *Finalizers) Delete(ctx context.Context, groupKind string, id string) error {
+	return retryOnConflict(PreconditionsFromContext(ctx).ResourceVersion == "", func() error {
+		current, err := f.next.Read(ctx, groupKind, id)
+		if err != nil {
+			return err
+		}
+
+		ctx := withResourceVersion(ctx, ResourceVersionOf(current))
+
+		if len(GetFinalizers(current)) == 0 {
+			return f.next.Delete(ctx, groupKind, id)
+		}
+
+		if IsDeleting(current) {
+			return nil
+		}
+
+		current["deletionTimestamp"] = time.Now().Format(time.RFC3339)
+
+		return f.next.Replace(ctx, groupKind, id, current)
+	})
+}
+
+func (f *Finalizers) Unwrap() Service {
+	return f.next
+}
+
+var _ Service = new(Finalizers)
+
+func NewFinalizers(next Service) *Finalizers {
+	return &Finalizers{next: next}
+}
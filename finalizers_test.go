@@ -0,0 +1,66 @@
+package core_test
+
+import (
+	"github.com/applicaset/core"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"net/http"
+)
+
+var _ = Describe("Finalizers", Ordered, func() {
+	var svc core.Service
+	svc = core.NewStore()
+	svc = core.NewAutoFields(svc)
+	svc = core.NewFinalizers(svc)
+
+	h := core.NewHandler(svc)
+
+	BeforeAll(func() {
+		res := doRequest(h, http.MethodPost, "/acme/foo", `{"id":"foo1","finalizers":["acme/cleanup","acme/audit"]}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+	})
+
+	It("should mark item as deleting", func() {
+		res := doRequest(h, http.MethodDelete, "/acme/foo/foo1", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusAccepted))
+
+		rsp := decodeBody(res)
+		Expect(rsp).Should(HaveKey("deletionTimestamp"))
+	})
+
+	It("should expose pending deletion on read", func() {
+		res := doRequest(h, http.MethodGet, "/acme/foo/foo1", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+
+		rsp := decodeBody(res)
+		Expect(rsp).Should(HaveKey("deletionTimestamp"))
+		Expect(rsp["finalizers"]).Should(HaveLen(2))
+	})
+
+	It("should keep deletion timestamp while finalizers remain", func() {
+		res := doRequest(h, http.MethodPut, "/acme/foo/foo1", `{"id":"foo1","finalizers":["acme/audit"]}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusNoContent))
+
+		rsp := decodeBody(doRequest(h, http.MethodGet, "/acme/foo/foo1", ""))
+		Expect(rsp).Should(HaveKey("deletionTimestamp"))
+	})
+
+	It("should purge item after last finalizer is removed", func() {
+		res := doRequest(h, http.MethodPut, "/acme/foo/foo1", `{"id":"foo1","finalizers":[]}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusNoContent))
+
+		res = doRequest(h, http.MethodGet, "/acme/foo/foo1", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNotFound))
+	})
+
+	It("should delete items without finalizers immediately", func() {
+		res := doRequest(h, http.MethodPost, "/acme/foo", `{"id":"foo2","deletionTimestamp":"2000-01-01T00:00:00Z"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		rsp := decodeBody(res)
+		Expect(rsp).ShouldNot(HaveKey("deletionTimestamp"))
+
+		res = doRequest(h, http.MethodDelete, "/acme/foo/foo2", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNoContent))
+	})
+})
@@ -0,0 +1,485 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const StateMachinesGroupKind = "core/statemachines"
+
+const defaultStateField = "status"
+
+type InvalidTransitionError struct {
+	From   string
+	To     string
+	Reason string
+}
+
+func (err InvalidTransitionError) Error() string {
+	return fmt.Sprintf("can not transition from '%s' to '%s': %s", err.From, err.To, err.Reason)
+}
+
+type TransitionNotFoundError struct {
+	GroupKind string
+	Name      string
+}
+
+func (err TransitionNotFoundError) Error() string {
+	return fmt.Sprintf("transition '%s' of kind '%s' not found", err.Name, err.GroupKind)
+}
+
+// StateTransition is a transition as hooks get it.
+type StateTransition struct {
+	Name  string
+	Field string
+	From  string
+	To    string
+}
+
+// TransitionHook may change item, or refuse the transition by returning an error, preferably a VetoError.
+type TransitionHook func(ctx context.Context, item GenericItem, t StateTransition) error
+
+// StateMachines enforces the legal transitions of state fields. Machines are items of StateMachinesGroupKind with
+// the groupKind they belong to, the field holding the state, status by default, an optional initial state and
+// transitions, each with a name, the states it goes from, from any if there are none, the state it goes to and an
+// optional guard expression, which must match the item after the transition.
+//
+// A replace changing a state must match a transition of its machine, and creates must start in the initial state.
+// Hooks registered for a transition run with the write, in one transaction if the service supports them, so they
+// must not block, see Transactor.
+type StateMachines struct {
+	next Service
+
+	mu       sync.RWMutex
+	machines map[string]cachedStateMachine
+	before   map[transitionHookKey][]TransitionHook
+	after    map[transitionHookKey][]TransitionHook
+}
+
+type cachedStateMachine struct {
+	resourceVersion string
+	machine         *stateMachine
+}
+
+type stateMachine struct {
+	field       string
+	initial     string
+	transitions []stateTransition
+}
+
+type stateTransition struct {
+	name  string
+	from  []string
+	to    string
+	guard *Expr
+}
+
+type transitionHookKey struct {
+	groupKind string
+	name      string
+}
+
+// BeforeTransition registers fn to run before the transition of groupKind with given name is written, or before
+// any of its transitions if name is empty.
+func (sm *StateMachines) BeforeTransition(groupKind string, name string, fn TransitionHook) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	key := transitionHookKey{groupKind: groupKind, name: name}
+	sm.before[key] = append(sm.before[key], fn)
+}
+
+// AfterTransition is like BeforeTransition, with hooks which run after the write. They undo it by failing.
+func (sm *StateMachines) AfterTransition(groupKind string, name string, fn TransitionHook) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	key := transitionHookKey{groupKind: groupKind, name: name}
+	sm.after[key] = append(sm.after[key], fn)
+}
+
+func (sm *StateMachines) List(ctx context.Context, groupKind string) ([]GenericItem, error) {
+	return sm.next.List(ctx, groupKind)
+}
+
+func (sm *StateMachines) Create(ctx context.Context, groupKind string, req GenericItem) error {
+	if groupKind == StateMachinesGroupKind {
+		_, err := parseStateMachine(req)
+		if err != nil {
+			return err
+		}
+
+		return sm.next.Create(ctx, groupKind, req)
+	}
+
+	machines, err := sm.machinesOf(ctx, groupKind)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range machines {
+		if m.initial == "" {
+			continue
+		}
+
+		switch req[m.field] {
+		case nil:
+			req[m.field] = m.initial
+		case m.initial:
+		default:
+			return FieldError{Field: m.field, Reason: fmt.Sprintf("must start as '%s'", m.initial)}
+		}
+	}
+
+	return sm.next.Create(ctx, groupKind, req)
+}
+
+func (sm *StateMachines) Read(ctx context.Context, groupKind string, id string) (GenericItem, error) {
+	return sm.next.Read(ctx, groupKind, id)
+}
+
+func (sm *StateMachines) Replace(ctx context.Context, groupKind string, id string, req GenericItem) error {
+	if groupKind == StateMachinesGroupKind {
+		_, err := parseStateMachine(req)
+		if err != nil {
+			return err
+		}
+
+		return sm.next.Replace(ctx, groupKind, id, req)
+	}
+
+	return inTx(ctx, sm.next, func(ctx context.Context) error {
+		machines, err := sm.machinesOf(ctx, groupKind)
+		if err != nil {
+			return err
+		}
+
+		if len(machines) == 0 {
+			return sm.next.Replace(ctx, groupKind, id, req)
+		}
+
+		old, err := sm.next.Read(ctx, groupKind, id)
+		if err != nil {
+			return err
+		}
+
+		transitions := make([]StateTransition, 0, len(machines))
+
+		for _, m := range machines {
+			t, ok, err := m.transitionOf(transitionNameFromContext(ctx), old, req)
+			if err != nil {
+				return err
+			}
+
+			if ok {
+				transitions = append(transitions, t)
+			}
+		}
+
+		for _, t := range transitions {
+			err = runTransitionHooks(ctx, sm.hooksOf(sm.before, groupKind, t.Name), req, t)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = sm.next.Replace(ctx, groupKind, id, req)
+		if err != nil {
+			return err
+		}
+
+		for _, t := range transitions {
+			err = runTransitionHooks(ctx, sm.hooksOf(sm.after, groupKind, t.Name), req, t)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (sm *StateMachines) Delete(ctx context.Context, groupKind string, id string) error {
+	return sm.next.Delete(ctx, groupKind, id)
+}
+
+func (sm *StateMachines) Unwrap() Service {
+	return sm.next
+}
+
+var _ Service = new(StateMachines)
+
+func (sm *StateMachines) hooksOf(hooks map[transitionHookKey][]TransitionHook, groupKind string, name string) []TransitionHook {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	res := append([]TransitionHook(nil), hooks[transitionHookKey{groupKind: groupKind}]...)
+
+	return append(res, hooks[transitionHookKey{groupKind: groupKind, name: name}]...)
+}
+
+func runTransitionHooks(ctx context.Context, hooks []TransitionHook, item GenericItem, t StateTransition) error {
+	for i := range hooks {
+		err := hooks[i](ctx, item, t)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// machinesOf returns the state machines of groupKind, in the order of their ids.
+func (sm *StateMachines) machinesOf(ctx context.Context, groupKind string) ([]*stateMachine, error) {
+	definitions, err := sm.next.List(ctx, StateMachinesGroupKind)
+	if err != nil {
+		if errors.As(err, &GroupKindNotFoundError{}) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	sort.Slice(definitions, func(i, j int) bool { return definitions[i].GetID() < definitions[j].GetID() })
+
+	res := make([]*stateMachine, 0)
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.forgetDeleted(definitions)
+
+	for i := range definitions {
+		if g, _ := definitions[i]["groupKind"].(string); g != groupKind {
+			continue
+		}
+
+		id, rv := definitions[i].GetID(), ResourceVersionOf(definitions[i])
+
+		cached, ok := sm.machines[id]
+		if !ok || cached.resourceVersion != rv {
+			m, err := parseStateMachine(definitions[i])
+			if err != nil {
+				return nil, err
+			}
+
+			cached = cachedStateMachine{resourceVersion: rv, machine: m}
+			sm.machines[id] = cached
+		}
+
+		res = append(res, cached.machine)
+	}
+
+	return res, nil
+}
+
+// forgetDeleted drops the parsed machines of definitions which do not exist anymore.
+func (sm *StateMachines) forgetDeleted(definitions []GenericItem) {
+	defined := make(map[string]bool, len(definitions))
+	for i := range definitions {
+		defined[definitions[i].GetID()] = true
+	}
+
+	for id := range sm.machines {
+		if !defined[id] {
+			delete(sm.machines, id)
+		}
+	}
+}
+
+func parseStateMachine(definition GenericItem) (*stateMachine, error) {
+	res := stateMachine{field: defaultStateField}
+
+	if groupKind, _ := definition["groupKind"].(string); !strings.Contains(groupKind, "/") || strings.HasPrefix(groupKind, "core/") {
+		return nil, FieldError{Field: "groupKind", Reason: "must be a group/kind"}
+	}
+
+	if v, ok := definition["field"]; ok {
+		res.field, _ = v.(string)
+		if res.field == "" || res.field == "id" {
+			return nil, FieldError{Field: "field", Reason: "must be the name of a field other than id"}
+		}
+	}
+
+	if v, ok := definition["initial"]; ok {
+		res.initial, _ = v.(string)
+		if res.initial == "" {
+			return nil, FieldError{Field: "initial", Reason: "must be a state"}
+		}
+	}
+
+	transitions, ok := definition["transitions"].([]interface{})
+	if !ok || len(transitions) == 0 {
+		return nil, FieldError{Field: "transitions", Reason: "must be a list of transitions"}
+	}
+
+	names := make(map[string]bool)
+
+	for i := range transitions {
+		field := fmt.Sprintf("transitions[%d]", i)
+
+		t, ok := transitions[i].(map[string]interface{})
+		if !ok {
+			return nil, FieldError{Field: field, Reason: "must be an object"}
+		}
+
+		var st stateTransition
+
+		st.name, _ = t["name"].(string)
+		if st.name == "" || names[st.name] {
+			return nil, FieldError{Field: field + ".name", Reason: "must be a unique name"}
+		}
+
+		names[st.name] = true
+
+		st.to, _ = t["to"].(string)
+		if st.to == "" {
+			return nil, FieldError{Field: field + ".to", Reason: "must be a state"}
+		}
+
+		if v, ok := t["from"]; ok {
+			from, _ := v.([]interface{})
+			if len(from) == 0 {
+				return nil, FieldError{Field: field + ".from", Reason: "must be a list of states"}
+			}
+
+			for j := range from {
+				s, _ := from[j].(string)
+				if s == "" {
+					return nil, FieldError{Field: field + ".from", Reason: "must be a list of states"}
+				}
+
+				st.from = append(st.from, s)
+			}
+		}
+
+		if v, ok := t["guard"]; ok {
+			src, _ := v.(string)
+
+			guard, err := ParseExpr(src)
+			if err != nil {
+				return nil, FieldError{Field: field + ".guard", Reason: err.Error()}
+			}
+
+			st.guard = guard
+		}
+
+		res.transitions = append(res.transitions, st)
+	}
+
+	return &res, nil
+}
+
+func (t stateTransition) allows(from string) bool {
+	if t.from == nil {
+		return true
+	}
+
+	for i := range t.from {
+		if t.from[i] == from {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *stateMachine) transitionNamed(name string) (stateTransition, bool) {
+	for i := range m.transitions {
+		if m.transitions[i].name == name {
+			return m.transitions[i], true
+		}
+	}
+
+	return stateTransition{}, false
+}
+
+// transitionOf finds the transition from old to item. The transition named name is the only candidate if the
+// machine has one, otherwise unchanged states need none.
+func (m *stateMachine) transitionOf(name string, old, item GenericItem) (StateTransition, bool, error) {
+	from, _ := old[m.field].(string)
+	to, _ := item[m.field].(string)
+
+	candidates, reason := m.transitions, "no transition"
+
+	if t, ok := m.transitionNamed(name); ok {
+		candidates, reason = []stateTransition{t}, fmt.Sprintf("'%s' does not start from '%s'", name, from)
+	} else if from == to {
+		return StateTransition{}, false, nil
+	}
+
+	for _, t := range candidates {
+		if t.to != to || !t.allows(from) {
+			continue
+		}
+
+		if t.guard != nil {
+			ok, err := t.guard.Match(item)
+			if err != nil {
+				return StateTransition{}, false, err
+			}
+
+			if !ok {
+				reason = fmt.Sprintf("guard of '%s' is not satisfied", t.name)
+
+				continue
+			}
+		}
+
+		return StateTransition{Name: t.name, Field: m.field, From: from, To: to}, true, nil
+	}
+
+	return StateTransition{}, false, InvalidTransitionError{From: from, To: to, Reason: reason}
+}
+
+type transitionNameKey struct{}
+
+func transitionNameFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(transitionNameKey{}).(string)
+
+	return name
+}
+
+// Transition performs the transition of the item with given name, guarded by the resource version it has read,
+// and returns the item in its new state.
+func Transition(ctx context.Context, svc Service, groupKind string, id string, name string) (GenericItem, error) {
+	sm, ok := Lookup[*StateMachines](svc)
+	if !ok {
+		return nil, TransitionNotFoundError{GroupKind: groupKind, Name: name}
+	}
+
+	machines, err := sm.machinesOf(ctx, groupKind)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range machines {
+		t, ok := m.transitionNamed(name)
+		if !ok {
+			continue
+		}
+
+		ctx := context.WithValue(ctx, transitionNameKey{}, name)
+
+		return Update(ctx, svc, groupKind, id, func(item GenericItem) (GenericItem, error) {
+			item[m.field] = t.to
+
+			return item, nil
+		})
+	}
+
+	return nil, TransitionNotFoundError{GroupKind: groupKind, Name: name}
+}
+
+func NewStateMachines(next Service) *StateMachines {
+	return &StateMachines{
+		next:     next,
+		machines: make(map[string]cachedStateMachine),
+		before:   make(map[transitionHookKey][]TransitionHook),
+		after:    make(map[transitionHookKey][]TransitionHook),
+	}
+}
@@ -0,0 +1,126 @@
+package core_test
+
+import (
+	"context"
+	"github.com/applicaset/core"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+)
+
+var _ = Describe("State machines", func() {
+	var (
+		sm *core.StateMachines
+		h  *core.Handler
+	)
+
+	BeforeEach(func() {
+		sm = core.NewStateMachines(core.NewAutoFields(core.NewStore()))
+		h = core.NewHandler(sm)
+
+		res := doRequest(h, http.MethodPost, "/core/statemachines", `{
+			"id": "orders",
+			"groupKind": "acme/orders",
+			"initial": "draft",
+			"transitions": [
+				{"name": "submit", "from": ["draft"], "to": "submitted", "guard": "total > 0"},
+				{"name": "approve", "from": ["submitted"], "to": "approved"},
+				{"name": "reject", "from": ["submitted"], "to": "draft"}
+			]
+		}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+	})
+
+	It("should enforce transitions on replace", func() {
+		res := doRequest(h, http.MethodPost, "/acme/orders", `{"id":"o1","total":0}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+		Expect(decodeBody(res)["status"]).Should(Equal("draft"))
+
+		res = doRequest(h, http.MethodPost, "/acme/orders", `{"id":"o2","status":"approved"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+
+		res = doRequest(h, http.MethodPut, "/acme/orders/o1", `{"id":"o1","total":10,"status":"approved"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusConflict))
+		Expect(decodeBody(res)["message"]).Should(Equal("Invalid transition"))
+
+		res = doRequest(h, http.MethodPut, "/acme/orders/o1", `{"id":"o1","total":0,"status":"submitted"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusConflict))
+		Expect(decodeBody(res)["error"]).Should(ContainSubstring("guard of 'submit'"))
+
+		res = doRequest(h, http.MethodPut, "/acme/orders/o1", `{"id":"o1","total":10,"status":"submitted"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusNoContent))
+
+		// writes keeping the state need no transition
+		res = doRequest(h, http.MethodPut, "/acme/orders/o1", `{"id":"o1","total":20,"status":"submitted"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusNoContent))
+	})
+
+	It("should perform named transitions with their hooks", func() {
+		var performed []string
+
+		sm.BeforeTransition("acme/orders", "approve", func(ctx context.Context, item core.GenericItem, t core.StateTransition) error {
+			item["approvedFrom"] = t.From
+
+			return nil
+		})
+
+		sm.AfterTransition("acme/orders", "", func(ctx context.Context, item core.GenericItem, t core.StateTransition) error {
+			if t.Name == "reject" {
+				return core.VetoError{StatusCode: http.StatusForbidden, Reason: "orders are not rejected yet"}
+			}
+
+			performed = append(performed, t.Name)
+
+			return nil
+		})
+
+		res := doRequest(h, http.MethodPost, "/acme/orders", `{"id":"o1","total":10}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		res = doRequest(h, http.MethodPost, "/acme/orders/o1/_transition/submit", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+		Expect(decodeBody(res)["status"]).Should(Equal("submitted"))
+
+		res = doRequest(h, http.MethodPost, "/acme/orders/o1/_transition/submit", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusConflict))
+
+		res = doRequest(h, http.MethodPost, "/acme/orders/o1/_transition/cancel", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNotFound))
+		Expect(decodeBody(res)["message"]).Should(Equal("Transition not found"))
+
+		// a failing after hook undoes the transition
+		res = doRequest(h, http.MethodPost, "/acme/orders/o1/_transition/reject", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusForbidden))
+
+		res = doRequest(h, http.MethodGet, "/acme/orders/o1", "")
+		Expect(decodeBody(res)["status"]).Should(Equal("submitted"))
+
+		req := httptest.NewRequest(http.MethodPost, "/acme/orders/o1/_transition/approve", nil)
+		req.Header.Set("If-Match", `"stale"`)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		Expect(w.Code).Should(Equal(http.StatusPreconditionFailed))
+
+		res = doRequest(h, http.MethodPost, "/acme/orders/o1/_transition/approve", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+
+		body := decodeBody(res)
+		Expect(body["status"]).Should(Equal("approved"))
+		Expect(body["approvedFrom"]).Should(Equal("submitted"))
+
+		Expect(performed).Should(Equal([]string{"submit", "approve"}))
+	})
+
+	It("should validate state machines", func() {
+		res := doRequest(h, http.MethodPost, "/core/statemachines", `{"id":"m1","groupKind":"acme/invoices"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+
+		res = doRequest(h, http.MethodPost, "/core/statemachines", `{"id":"m1","groupKind":"acme/invoices","transitions":[{"name":"pay","to":"paid","guard":"total >"}]}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+
+		res = doRequest(h, http.MethodPost, "/core/statemachines", `{"id":"m1","groupKind":"acme/invoices","transitions":[{"name":"pay","to":"paid"},{"name":"pay","to":"void"}]}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+	})
+})
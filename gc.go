@@ -0,0 +1,542 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type PropagationPolicy string
+
+const (
+	PropagationPolicyForeground PropagationPolicy = "Foreground"
+	PropagationPolicyBackground PropagationPolicy = "Background"
+	PropagationPolicyOrphan     PropagationPolicy = "Orphan"
+)
+
+func ParsePropagationPolicy(s string) (PropagationPolicy, error) {
+	switch p := PropagationPolicy(s); p {
+	case PropagationPolicyForeground, PropagationPolicyBackground, PropagationPolicyOrphan:
+		return p, nil
+	case "":
+		return PropagationPolicyBackground, nil
+	default:
+		return "", fmt.Errorf("unknown propagation policy '%s'", s)
+	}
+}
+
+type propagationPolicyKey struct{}
+
+func WithPropagationPolicy(ctx context.Context, policy PropagationPolicy) context.Context {
+	return context.WithValue(ctx, propagationPolicyKey{}, policy)
+}
+
+func PropagationPolicyFromContext(ctx context.Context) PropagationPolicy {
+	if policy, ok := ctx.Value(propagationPolicyKey{}).(PropagationPolicy); ok {
+		return policy
+	}
+
+	return PropagationPolicyBackground
+}
+
+type OwnerReference struct {
+	Group string `json:"group"`
+	Kind  string `json:"kind"`
+	ID    string `json:"id"`
+	UUID  string `json:"uuid,omitempty"`
+}
+
+func (ref OwnerReference) GroupKind() string {
+	return GetGroupKind(ref.Group, ref.Kind)
+}
+
+// Refers reports whether ref points to item stored in groupKind.
+// A reference without uuid matches any incarnation of the item.
+func (ref OwnerReference) Refers(groupKind string, item GenericItem) bool {
+	if ref.GroupKind() != groupKind || ref.ID != item["id"] {
+		return false
+	}
+
+	return ref.UUID == "" || ref.UUID == item["uuid"]
+}
+
+// GetOwnerReferences returns well-formed entries of item's ownerReferences field.
+func GetOwnerReferences(item GenericItem) []OwnerReference {
+	refs, _ := item["ownerReferences"].([]interface{})
+
+	res := make([]OwnerReference, 0, len(refs))
+
+	for i := range refs {
+		m, ok := refs[i].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		var ref OwnerReference
+		ref.Group, _ = m["group"].(string)
+		ref.Kind, _ = m["kind"].(string)
+		ref.ID, _ = m["id"].(string)
+		ref.UUID, _ = m["uuid"].(string)
+
+		if ref.Kind == "" || ref.ID == "" {
+			continue
+		}
+
+		res = append(res, ref)
+	}
+
+	return res
+}
+
+func setOwnerReferences(item GenericItem, refs []OwnerReference) {
+	if len(refs) == 0 {
+		delete(item, "ownerReferences")
+
+		return
+	}
+
+	res := make([]interface{}, len(refs))
+	for i := range refs {
+		m := map[string]interface{}{
+			"group": refs[i].Group,
+			"kind":  refs[i].Kind,
+			"id":    refs[i].ID,
+		}
+
+		if refs[i].UUID != "" {
+			m["uuid"] = refs[i].UUID
+		}
+
+		res[i] = m
+	}
+
+	item["ownerReferences"] = res
+}
+
+type GCMetrics struct {
+	Sweeps    uint64    `json:"sweeps"`
+	Collected uint64    `json:"collected"`
+	Orphaned  uint64    `json:"orphaned"`
+	Errors    uint64    `json:"errors"`
+	Pending   int       `json:"pending"`
+	LastSweep time.Time `json:"lastSweep"`
+}
+
+// GarbageCollector deletes items whose owners, listed in ownerReferences, are gone.
+// Deletions are cascaded according to the PropagationPolicy found in context.
+// Its state is derived from stored items only, so a full sweep on start resumes any collection interrupted by a restart.
+type GarbageCollector struct {
+	next     Service
+	interval time.Duration
+	trigger  chan struct{}
+
+	mu      sync.Mutex
+	pending []pendingOwner
+
+	sweeps    uint64
+	collected uint64
+	orphaned  uint64
+	errors    uint64
+	lastSweep atomic.Value
+}
+
+type pendingOwner struct {
+	groupKind string
+	item      GenericItem
+}
+
+func (gc *GarbageCollector) List(ctx context.Context, groupKind string) ([]GenericItem, error) {
+	return gc.next.List(ctx, groupKind)
+}
+
+func (gc *GarbageCollector) Create(ctx context.Context, groupKind string, req GenericItem) error {
+	return gc.next.Create(ctx, groupKind, req)
+}
+
+func (gc *GarbageCollector) Read(ctx context.Context, groupKind string, id string) (GenericItem, error) {
+	return gc.next.Read(ctx, groupKind, id)
+}
+
+func (gc *GarbageCollector) Replace(ctx context.Context, groupKind string, id string, req GenericItem) error {
+	return gc.next.Replace(ctx, groupKind, id, req)
+}
+
+func (gc *GarbageCollector) Delete(ctx context.Context, groupKind string, id string) error {
+	switch PropagationPolicyFromContext(ctx) {
+	case PropagationPolicyForeground:
+		return gc.deleteForeground(ctx, groupKind, id, make(map[string]bool))
+	case PropagationPolicyOrphan:
+		return gc.deleteOrphan(ctx, groupKind, id)
+	default:
+		return gc.deleteBackground(ctx, groupKind, id)
+	}
+}
+
+func (gc *GarbageCollector) Unwrap() Service {
+	return gc.next
+}
+
+func (gc *GarbageCollector) deleteBackground(ctx context.Context, groupKind string, id string) error {
+	owner, err := gc.next.Read(ctx, groupKind, id)
+	if err != nil {
+		return err
+	}
+
+	err = gc.next.Delete(ctx, groupKind, id)
+	if err != nil {
+		return err
+	}
+
+	if IsDryRun(ctx) {
+		return nil
+	}
+
+	AfterCommit(ctx, func() {
+		gc.mu.Lock()
+		gc.pending = append(gc.pending, pendingOwner{groupKind: groupKind, item: owner})
+		gc.mu.Unlock()
+
+		select {
+		case gc.trigger <- struct{}{}:
+		default:
+		}
+	})
+
+	return nil
+}
+
+func (gc *GarbageCollector) deleteForeground(ctx context.Context, groupKind string, id string, visited map[string]bool) error {
+	visited[groupKind+"/"+id] = true
+
+	owner, err := gc.next.Read(ctx, groupKind, id)
+	if err != nil {
+		return err
+	}
+
+	dependents, err := gc.dependents(ctx, groupKind, owner)
+	if err != nil {
+		return err
+	}
+
+	dependentCtx := WithPreconditions(ctx, Preconditions{})
+
+	for i := range dependents {
+		dependentID := dependents[i].item.GetID()
+		if visited[dependents[i].groupKind+"/"+dependentID] {
+			continue
+		}
+
+		remaining, err := gc.remainingOwners(dependentCtx, dependents[i].item, groupKind, owner)
+		if err != nil {
+			return err
+		}
+
+		if len(remaining) > 0 {
+			err = gc.updateOwners(dependentCtx, dependents[i].groupKind, dependentID, func(ref OwnerReference) bool {
+				return !ref.Refers(groupKind, owner)
+			})
+		} else {
+			err = gc.deleteForeground(dependentCtx, dependents[i].groupKind, dependentID, visited)
+		}
+
+		if err != nil && !errors.As(err, &ItemNotFoundError{}) {
+			return err
+		}
+
+		if err == nil && len(remaining) == 0 && !IsDryRun(ctx) {
+			atomic.AddUint64(&gc.collected, 1)
+		}
+	}
+
+	return gc.next.Delete(ctx, groupKind, id)
+}
+
+func (gc *GarbageCollector) deleteOrphan(ctx context.Context, groupKind string, id string) error {
+	owner, err := gc.next.Read(ctx, groupKind, id)
+	if err != nil {
+		return err
+	}
+
+	dependents, err := gc.dependents(ctx, groupKind, owner)
+	if err != nil {
+		return err
+	}
+
+	dependentCtx := WithPreconditions(ctx, Preconditions{})
+
+	for i := range dependents {
+		err = gc.updateOwners(dependentCtx, dependents[i].groupKind, dependents[i].item.GetID(), func(ref OwnerReference) bool {
+			return !ref.Refers(groupKind, owner)
+		})
+		if err != nil {
+			if errors.As(err, &ItemNotFoundError{}) {
+				continue
+			}
+
+			return err
+		}
+
+		if !IsDryRun(ctx) {
+			atomic.AddUint64(&gc.orphaned, 1)
+		}
+	}
+
+	return gc.next.Delete(ctx, groupKind, id)
+}
+
+type dependent struct {
+	groupKind string
+	item      GenericItem
+}
+
+func (gc *GarbageCollector) dependents(ctx context.Context, groupKind string, owner GenericItem) ([]dependent, error) {
+	var res []dependent
+
+	err := gc.forEachOwned(ctx, func(dependentGroupKind string, item GenericItem, refs []OwnerReference) error {
+		for i := range refs {
+			if refs[i].Refers(groupKind, owner) {
+				res = append(res, dependent{groupKind: dependentGroupKind, item: item})
+
+				break
+			}
+		}
+
+		return nil
+	})
+
+	return res, err
+}
+
+func (gc *GarbageCollector) forEachOwned(ctx context.Context, fn func(groupKind string, item GenericItem, refs []OwnerReference) error) error {
+	lister, ok := Lookup[GroupKindLister](gc.next)
+	if !ok {
+		return errors.New("garbage collector requires a service that can list group kinds")
+	}
+
+	groupKinds, err := lister.ListGroupKinds(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, groupKind := range groupKinds {
+		items, err := gc.next.List(ctx, groupKind)
+		if err != nil {
+			if errors.As(err, &GroupKindNotFoundError{}) {
+				continue
+			}
+
+			return err
+		}
+
+		for i := range items {
+			refs := GetOwnerReferences(items[i])
+			if len(refs) == 0 {
+				continue
+			}
+
+			err = fn(groupKind, items[i], refs)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// remainingOwners returns references of item which still point to an existing owner, ignoring the owner being deleted.
+func (gc *GarbageCollector) remainingOwners(ctx context.Context, item GenericItem, deletedGroupKind string, deleted GenericItem) ([]OwnerReference, error) {
+	refs := GetOwnerReferences(item)
+
+	res := make([]OwnerReference, 0, len(refs))
+
+	for i := range refs {
+		if deleted != nil && refs[i].Refers(deletedGroupKind, deleted) {
+			continue
+		}
+
+		exists, err := gc.ownerExists(ctx, refs[i])
+		if err != nil {
+			return nil, err
+		}
+
+		if exists {
+			res = append(res, refs[i])
+		}
+	}
+
+	return res, nil
+}
+
+func (gc *GarbageCollector) ownerExists(ctx context.Context, ref OwnerReference) (bool, error) {
+	owner, err := gc.next.Read(ctx, ref.GroupKind(), ref.ID)
+	if err != nil {
+		if errors.As(err, &ItemNotFoundError{}) || errors.As(err, &GroupKindNotFoundError{}) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return ref.Refers(ref.GroupKind(), owner), nil
+}
+
+// updateOwners drops the owner references of an item for which keep returns false.
+func (gc *GarbageCollector) updateOwners(ctx context.Context, groupKind string, id string, keep func(ref OwnerReference) bool) error {
+	return retryOnConflict(true, func() error {
+		item, err := gc.next.Read(ctx, groupKind, id)
+		if err != nil {
+			return err
+		}
+
+		refs := GetOwnerReferences(item)
+
+		res := refs[:0]
+		for i := range refs {
+			if keep(refs[i]) {
+				res = append(res, refs[i])
+			}
+		}
+
+		setOwnerReferences(item, res)
+
+		return gc.next.Replace(ctx, groupKind, id, item)
+	})
+}
+
+// collect deletes or detaches every item whose owners are all gone.
+func (gc *GarbageCollector) collect(ctx context.Context, groupKind string, item GenericItem, refs []OwnerReference) error {
+	remaining, err := gc.remainingOwners(ctx, item, "", nil)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case len(remaining) == len(refs):
+		return nil
+	case len(remaining) == 0 && IsDeleting(item):
+		return nil
+	case len(remaining) > 0:
+		err = gc.updateOwners(ctx, groupKind, item.GetID(), func(ref OwnerReference) bool {
+			for i := range remaining {
+				if remaining[i] == ref {
+					return true
+				}
+			}
+
+			return false
+		})
+	default:
+		err = gc.deleteBackground(ctx, groupKind, item.GetID())
+		if err == nil {
+			atomic.AddUint64(&gc.collected, 1)
+		}
+	}
+
+	if errors.As(err, &ItemNotFoundError{}) {
+		return nil
+	}
+
+	return err
+}
+
+func (gc *GarbageCollector) collectPending(ctx context.Context) error {
+	gc.mu.Lock()
+	owners := gc.pending
+	gc.pending = nil
+	gc.mu.Unlock()
+
+	for i := range owners {
+		dependents, err := gc.dependents(ctx, owners[i].groupKind, owners[i].item)
+		if err != nil {
+			gc.mu.Lock()
+			gc.pending = append(gc.pending, owners[i:]...)
+			gc.mu.Unlock()
+
+			return err
+		}
+
+		for j := range dependents {
+			err = gc.collect(ctx, dependents[j].groupKind, dependents[j].item, GetOwnerReferences(dependents[j].item))
+			if err != nil {
+				atomic.AddUint64(&gc.errors, 1)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Sweep checks every owned item in the service and collects those whose owners are gone.
+func (gc *GarbageCollector) Sweep(ctx context.Context) error {
+	atomic.AddUint64(&gc.sweeps, 1)
+	defer gc.lastSweep.Store(time.Now())
+
+	return gc.forEachOwned(ctx, func(groupKind string, item GenericItem, refs []OwnerReference) error {
+		err := gc.collect(ctx, groupKind, item, refs)
+		if err != nil {
+			atomic.AddUint64(&gc.errors, 1)
+		}
+
+		return nil
+	})
+}
+
+// Run sweeps once, then collects dependents of background deletions as they happen and sweeps periodically until ctx is done.
+func (gc *GarbageCollector) Run(ctx context.Context) {
+	if err := gc.Sweep(ctx); err != nil {
+		atomic.AddUint64(&gc.errors, 1)
+	}
+
+	ticker := time.NewTicker(gc.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-gc.trigger:
+			if err := gc.collectPending(ctx); err != nil {
+				atomic.AddUint64(&gc.errors, 1)
+			}
+		case <-ticker.C:
+			if err := gc.collectPending(ctx); err != nil {
+				atomic.AddUint64(&gc.errors, 1)
+			}
+
+			if err := gc.Sweep(ctx); err != nil {
+				atomic.AddUint64(&gc.errors, 1)
+			}
+		}
+	}
+}
+
+func (gc *GarbageCollector) Metrics() GCMetrics {
+	gc.mu.Lock()
+	pending := len(gc.pending)
+	gc.mu.Unlock()
+
+	lastSweep, _ := gc.lastSweep.Load().(time.Time)
+
+	return GCMetrics{
+		Sweeps:    atomic.LoadUint64(&gc.sweeps),
+		Collected: atomic.LoadUint64(&gc.collected),
+		Orphaned:  atomic.LoadUint64(&gc.orphaned),
+		Errors:    atomic.LoadUint64(&gc.errors),
+		Pending:   pending,
+		LastSweep: lastSweep,
+	}
+}
+
+var _ Service = new(GarbageCollector)
+
+func NewGarbageCollector(next Service, interval time.Duration) *GarbageCollector {
+	return &GarbageCollector{
+		next:     next,
+		interval: interval,
+		trigger:  make(chan struct{}, 1),
+	}
+}
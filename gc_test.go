@@ -0,0 +1,144 @@
+package core_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/applicaset/core"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+func doRequest(h http.Handler, method, target, body string) *http.Response {
+	var reqBody io.Reader
+	if body != "" {
+		reqBody = bytes.NewBufferString(body)
+	}
+
+	req := httptest.NewRequest(method, target, reqBody)
+
+	w := httptest.NewRecorder()
+
+	h.ServeHTTP(w, req)
+
+	return w.Result()
+}
+
+func decodeBody(res *http.Response) map[string]interface{} {
+	defer func() { _ = res.Body.Close() }()
+
+	var rsp map[string]interface{}
+
+	err := json.NewDecoder(res.Body).Decode(&rsp)
+	Expect(err).ShouldNot(HaveOccurred())
+
+	return rsp
+}
+
+var _ = Describe("Garbage collector", func() {
+	var (
+		gc *core.GarbageCollector
+		h  *core.Handler
+	)
+
+	BeforeEach(func() {
+		var svc core.Service
+		svc = core.NewStore()
+		svc = core.NewAutoFields(svc)
+
+		gc = core.NewGarbageCollector(svc, time.Hour)
+
+		h = core.NewHandler(gc)
+
+		res := doRequest(h, http.MethodPost, "/acme/orders", `{"id":"order1"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		res = doRequest(h, http.MethodPost, "/acme/lines", `{"id":"line1","ownerReferences":[{"group":"acme","kind":"orders","id":"order1"}]}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		res = doRequest(h, http.MethodPost, "/acme/notes", `{"id":"note1","ownerReferences":[{"group":"acme","kind":"lines","id":"line1"}]}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+	})
+
+	It("should delete dependents before owner in foreground", func() {
+		res := doRequest(h, http.MethodDelete, "/acme/orders/order1?propagationPolicy=Foreground", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNoContent))
+
+		res = doRequest(h, http.MethodGet, "/acme/lines/line1", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNotFound))
+
+		res = doRequest(h, http.MethodGet, "/acme/notes/note1", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNotFound))
+
+		Expect(gc.Metrics().Collected).Should(BeEquivalentTo(2))
+	})
+
+	It("should collect dependents in background", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go gc.Run(ctx)
+
+		res := doRequest(h, http.MethodDelete, "/acme/orders/order1", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNoContent))
+
+		Eventually(func() int {
+			return doRequest(h, http.MethodGet, "/acme/notes/note1", "").StatusCode
+		}).Should(Equal(http.StatusNotFound))
+
+		res = doRequest(h, http.MethodGet, "/acme/lines/line1", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNotFound))
+	})
+
+	It("should keep dependents which have other owners", func() {
+		res := doRequest(h, http.MethodPost, "/acme/orders", `{"id":"order2"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		res = doRequest(h, http.MethodPost, "/acme/lines", `{"id":"line2","ownerReferences":[{"group":"acme","kind":"orders","id":"order1"},{"group":"acme","kind":"orders","id":"order2"}]}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		res = doRequest(h, http.MethodDelete, "/acme/orders/order1", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNoContent))
+
+		Expect(gc.Sweep(context.Background())).Should(Succeed())
+
+		rsp := decodeBody(doRequest(h, http.MethodGet, "/acme/lines/line2", ""))
+		Expect(rsp["ownerReferences"]).Should(HaveLen(1))
+
+		res = doRequest(h, http.MethodGet, "/acme/lines/line1", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNotFound))
+	})
+
+	It("should orphan dependents", func() {
+		res := doRequest(h, http.MethodDelete, "/acme/orders/order1?propagationPolicy=Orphan", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNoContent))
+
+		Expect(gc.Sweep(context.Background())).Should(Succeed())
+
+		rsp := decodeBody(doRequest(h, http.MethodGet, "/acme/lines/line1", ""))
+		Expect(rsp).ShouldNot(HaveKey("ownerReferences"))
+		Expect(gc.Metrics().Orphaned).Should(BeEquivalentTo(1))
+	})
+
+	It("should collect dependents of owners deleted before it started", func() {
+		res := doRequest(core.NewHandler(gc.Unwrap()), http.MethodDelete, "/acme/orders/order1", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNoContent))
+
+		Expect(gc.Sweep(context.Background())).Should(Succeed())
+
+		res = doRequest(h, http.MethodGet, "/acme/lines/line1", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNotFound))
+	})
+
+	It("should fail on unknown propagation policy", func() {
+		res := doRequest(h, http.MethodDelete, "/acme/orders/order1?propagationPolicy=Sideways", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusBadRequest))
+
+		rsp := decodeBody(res)
+		Expect(rsp).Should(HaveKeyWithValue("message", "Invalid propagation policy"))
+	})
+})
@@ -2,8 +2,13 @@ package core
 
 import (
 	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type HTTPError struct {
@@ -23,44 +28,123 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.r.ServeHTTP(w, r)
 }
 
-func NewHandler(svc Service) *Handler {
+// internalGroupKinds hold the state of the service itself, so they are not served to clients.
+var internalGroupKinds = map[string]bool{
+	ChangesGroupKind:           true,
+	IdempotencyGroupKind:       true,
+	SequencesGroupKind:         true,
+	WebhookDeliveriesGroupKind: true,
+	WebhookSecretsGroupKind:    true,
+}
+
+// hideInternalKinds answers requests for internal kinds as if they did not exist.
+func hideInternalKinds(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, p := range []string{r.URL.Path, r.URL.RawPath} {
+			parts := strings.SplitN(strings.TrimPrefix(p, "/"), "/", 3)
+			if len(parts) >= 2 && internalGroupKinds[GetGroupKind(parts[0], parts[1])] {
+				writeError(w, GroupKindNotFoundError{Group: parts[0], Kind: parts[1]})
+
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func NewHandler(svc Service, opts ...HandlerOption) *Handler {
+	o := newHandlerOptions(opts)
+
 	h := new(Handler)
 
 	h.r = chi.NewRouter()
+	h.r.Use(hideInternalKinds)
 
-	h.r.Get("/{group}/{kind}", ListHandler(svc))
-	h.r.Post("/{group}/{kind}", CreateHandler(svc))
-	h.r.Get("/{group}/{kind}/{id}", ReadHandler(svc))
-	h.r.Put("/{group}/{kind}/{id}", ReplaceHandler(svc))
+	if o.actorHeader != "" {
+		h.r.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if actor := r.Header.Get(o.actorHeader); actor != "" {
+					r = r.WithContext(WithActor(r.Context(), actor))
+				}
+
+				next.ServeHTTP(w, r)
+			})
+		})
+	}
+
+	idempotent := func(next http.Handler) http.Handler { return next }
+	if o.idempotency != nil {
+		idempotent = o.idempotency.Handler
+	}
+
+	h.r.Get("/{group}/{kind}", ListHandler(svc, opts...))
+	h.r.Method(http.MethodPost, "/{group}/{kind}", idempotent(CreateHandler(svc)))
+	h.r.Patch("/{group}/{kind}", BulkPatchHandler(svc))
+	h.r.Delete("/{group}/{kind}", BulkDeleteHandler(svc))
+	h.r.Get("/{group}/{kind}/{id}", ReadHandler(svc, opts...))
+	h.r.Put("/{group}/{kind}/{id}", ReplaceHandler(svc, opts...))
+	h.r.Patch("/{group}/{kind}/{id}", PatchHandler(svc))
 	h.r.Delete("/{group}/{kind}/{id}", DeleteHandler(svc))
+	h.r.Post("/{group}/{kind}/{id}/_increment", IncrementHandler(svc))
+	h.r.Post("/{group}/{kind}/{id}/_transition/{name}", TransitionHandler(svc))
+	h.r.Method(http.MethodPost, "/_batch", idempotent(BatchHandler(svc, h.r)))
+	h.r.Get("/_ws", WebSocketHandler(svc, o.webSocket))
+
+	if o.changeLog != nil {
+		h.r.Get("/_changes", ChangesHandler(o.changeLog))
+	}
+
+	if o.sequences != nil {
+		h.r.Post("/_sequences/{name}", SequenceHandler(o.sequences, o.sequenceNames...))
+	}
 
 	return h
 }
 
-func ListHandler(svc Service) http.HandlerFunc {
+func ListHandler(svc Service, opts ...HandlerOption) http.HandlerFunc {
+	o := newHandlerOptions(opts)
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		group := chi.URLParam(r, "group")
 		kind := chi.URLParam(r, "kind")
 
-		res, err := svc.List(r.Context(), GetGroupKind(group, kind))
-		if err != nil {
-			switch {
-			case errors.As(err, &GroupKindNotFoundError{}):
-				w.WriteHeader(http.StatusNotFound)
+		if isWatch(r) {
+			serveWatch(w, r, svc, GetGroupKind(group, kind), "")
 
-				_ = json.NewEncoder(w).Encode(HTTPError{
-					Message: "Invalid kind",
-					Error:   err.Error(),
-				})
-			default:
-				w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
 
-				_ = json.NewEncoder(w).Encode(HTTPError{
-					Message: "Unexpected error occurred",
-					Error:   err.Error(),
-				})
+		var (
+			res []GenericItem
+			err error
+		)
+
+		if r.URL.Query().Has("filter") {
+			filter, ok := parseFilter(w, r)
+			if !ok {
+				return
 			}
 
+			res, err = matchingItems(r.Context(), svc, GetGroupKind(group, kind), filter)
+		} else {
+			res, err = svc.List(r.Context(), GetGroupKind(group, kind))
+		}
+
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		// lists have no modification time, as removed items would not change it
+		etag := listETag(res)
+
+		writeCacheHeaders(w, etag, time.Time{}, o.cacheControlOf(GetGroupKind(group, kind)))
+
+		if notModified(r, etag, time.Time{}) {
+			w.WriteHeader(http.StatusNotModified)
+
 			return
 		}
 
@@ -75,7 +159,7 @@ func CreateHandler(svc Service) http.HandlerFunc {
 
 		var req GenericItem
 
-		err := json.NewDecoder(r.Body).Decode(&req)
+		err := decodeItem(r, &req)
 		if err != nil {
 			// TODO: check whether it's a client error or not
 			w.WriteHeader(http.StatusBadRequest)
@@ -88,64 +172,61 @@ func CreateHandler(svc Service) http.HandlerFunc {
 			return
 		}
 
-		err = svc.Create(r.Context(), GetGroupKind(group, kind), req)
+		ctx := requestContext(r)
+
+		err = rejectComputedFields(ctx, svc, GetGroupKind(group, kind), "", req)
 		if err != nil {
-			switch {
-			case errors.As(err, &ItemExistsError{}):
-				w.WriteHeader(http.StatusConflict)
+			writeError(w, err)
 
-				_ = json.NewEncoder(w).Encode(HTTPError{
-					Message: "Item exists",
-					Error:   err.Error(),
-				})
-			default:
-				w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
 
-				_ = json.NewEncoder(w).Encode(HTTPError{
-					Message: "Unexpected error occurred",
-					Error:   err.Error(),
-				})
-			}
+		err = svc.Create(ctx, GetGroupKind(group, kind), req)
+		if err != nil {
+			writeError(w, err)
 
 			return
 		}
 
+		if !IsDryRun(ctx) {
+			setETag(w, ResourceVersionOf(req))
+		}
+
 		w.WriteHeader(http.StatusCreated)
 		_ = json.NewEncoder(w).Encode(req)
 	}
 }
 
-func ReadHandler(svc Service) http.HandlerFunc {
+func ReadHandler(svc Service, opts ...HandlerOption) http.HandlerFunc {
+	o := newHandlerOptions(opts)
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		group := chi.URLParam(r, "group")
 		kind := chi.URLParam(r, "kind")
 		id := chi.URLParam(r, "id")
 
+		if isWatch(r) {
+			serveWatch(w, r, svc, GetGroupKind(group, kind), id)
+
+			return
+		}
+
 		res, err := svc.Read(r.Context(), GetGroupKind(group, kind), id)
 		if err != nil {
-			switch {
-			case errors.As(err, &GroupKindNotFoundError{}):
-				w.WriteHeader(http.StatusNotFound)
-
-				_ = json.NewEncoder(w).Encode(HTTPError{
-					Message: "Invalid kind",
-					Error:   err.Error(),
-				})
-			case errors.As(err, &ItemNotFoundError{}):
-				w.WriteHeader(http.StatusNotFound)
-
-				_ = json.NewEncoder(w).Encode(HTTPError{
-					Message: "Item not found",
-					Error:   err.Error(),
-				})
-			default:
-				w.WriteHeader(http.StatusInternalServerError)
-
-				_ = json.NewEncoder(w).Encode(HTTPError{
-					Message: "Unexpected error occurred",
-					Error:   err.Error(),
-				})
-			}
+			writeError(w, err)
+
+			return
+		}
+
+		etag, lastModified := "", LastModifiedOf(res)
+		if rv := ResourceVersionOf(res); rv != "" {
+			etag = ETag(rv)
+		}
+
+		writeCacheHeaders(w, etag, lastModified, o.cacheControlOf(GetGroupKind(group, kind)))
+
+		if notModified(r, etag, lastModified) {
+			w.WriteHeader(http.StatusNotModified)
 
 			return
 		}
@@ -154,7 +235,9 @@ func ReadHandler(svc Service) http.HandlerFunc {
 	}
 }
 
-func ReplaceHandler(svc Service) http.HandlerFunc {
+func ReplaceHandler(svc Service, opts ...HandlerOption) http.HandlerFunc {
+	o := newHandlerOptions(opts)
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		group := chi.URLParam(r, "group")
 		kind := chi.URLParam(r, "kind")
@@ -162,7 +245,7 @@ func ReplaceHandler(svc Service) http.HandlerFunc {
 
 		var req GenericItem
 
-		err := json.NewDecoder(r.Body).Decode(&req)
+		err := decodeItem(r, &req)
 		if err != nil {
 			// TODO: check whether it's a client error or not
 			w.WriteHeader(http.StatusBadRequest)
@@ -175,70 +258,406 @@ func ReplaceHandler(svc Service) http.HandlerFunc {
 			return
 		}
 
-		err = svc.Replace(r.Context(), GetGroupKind(group, kind), id, req)
+		ctx := WithPreconditions(requestContext(r), Preconditions{
+			IfMatch: ParseETags(r.Header.Get("If-Match")),
+		})
+
+		err = rejectComputedFields(ctx, svc, GetGroupKind(group, kind), id, req)
 		if err != nil {
-			switch {
-			case errors.As(err, &GroupKindNotFoundError{}):
-				w.WriteHeader(http.StatusNotFound)
+			writeError(w, err)
 
-				_ = json.NewEncoder(w).Encode(HTTPError{
-					Message: "Invalid kind",
-					Error:   err.Error(),
-				})
-			case errors.As(err, &ItemNotFoundError{}):
-				w.WriteHeader(http.StatusNotFound)
+			return
+		}
 
-				_ = json.NewEncoder(w).Encode(HTTPError{
-					Message: "Item not found",
-					Error:   err.Error(),
-				})
-			default:
-				w.WriteHeader(http.StatusInternalServerError)
+		if o.upsert {
+			created, err := Upsert(ctx, svc, GetGroupKind(group, kind), id, req)
+			if err != nil {
+				writeError(w, err)
+
+				return
+			}
 
-				_ = json.NewEncoder(w).Encode(HTTPError{
-					Message: "Unexpected error occurred",
-					Error:   err.Error(),
-				})
+			if !IsDryRun(ctx) {
+				setETag(w, ResourceVersionOf(req))
 			}
 
+			if created {
+				w.WriteHeader(http.StatusCreated)
+			}
+
+			_ = json.NewEncoder(w).Encode(req)
+
+			return
+		}
+
+		err = svc.Replace(ctx, GetGroupKind(group, kind), id, req)
+		if err != nil {
+			writeError(w, err)
+
 			return
 		}
 
+		// a dry run responds with what would have been stored
+		if IsDryRun(ctx) {
+			_ = json.NewEncoder(w).Encode(req)
+
+			return
+		}
+
+		setETag(w, ResourceVersionOf(req))
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
+func PatchHandler(svc Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		group := chi.URLParam(r, "group")
+		kind := chi.URLParam(r, "kind")
+		id := chi.URLParam(r, "id")
+
+		contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if contentType == ApplyPatchType {
+			applyPatch(svc, w, r)
+
+			return
+		}
+
+		patch, ok := decodePatch(w, r)
+		if !ok {
+			return
+		}
+
+		ctx := WithPreconditions(requestContext(r), Preconditions{
+			IfMatch: ParseETags(r.Header.Get("If-Match")),
+		})
+
+		patch, err := rejectChangedComputedFields(ctx, svc, GetGroupKind(group, kind), patch)
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		res, err := Update(ctx, svc, GetGroupKind(group, kind), id, patch)
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		if !IsDryRun(ctx) {
+			setETag(w, ResourceVersionOf(res))
+		}
+
+		_ = json.NewEncoder(w).Encode(res)
+	}
+}
+
+// decodePatch decodes a merge patch or a json patch from the body of r. If it fails, the error is written to w.
+func decodePatch(w http.ResponseWriter, r *http.Request) (patch func(item GenericItem) (GenericItem, error), ok bool) {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var apply func(item GenericItem) (interface{}, error)
+
+	switch contentType {
+	case MergePatchType:
+		var patch interface{}
+
+		err := json.NewDecoder(r.Body).Decode(&patch)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			_ = json.NewEncoder(w).Encode(HTTPError{
+				Message: "Invalid request",
+				Error:   err.Error(),
+			})
+
+			return nil, false
+		}
+
+		apply = func(item GenericItem) (interface{}, error) {
+			return MergePatch(item, deepCopyValue(patch)), nil
+		}
+	case JSONPatchType:
+		var operations []map[string]interface{}
+
+		err := json.NewDecoder(r.Body).Decode(&operations)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			_ = json.NewEncoder(w).Encode(HTTPError{
+				Message: "Invalid request",
+				Error:   err.Error(),
+			})
+
+			return nil, false
+		}
+
+		apply = func(item GenericItem) (interface{}, error) {
+			ops := make([]map[string]interface{}, len(operations))
+			for i := range operations {
+				ops[i] = deepCopyValue(operations[i]).(map[string]interface{})
+			}
+
+			return ApplyJSONPatch(item, ops)
+		}
+	default:
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+
+		_ = json.NewEncoder(w).Encode(HTTPError{
+			Message: "Unsupported patch type",
+			Error:   fmt.Sprintf("content type '%s' is not supported", contentType),
+		})
+
+		return nil, false
+	}
+
+	return func(item GenericItem) (GenericItem, error) {
+		id := item["id"]
+
+		patched, err := apply(item)
+		if err != nil {
+			return nil, err
+		}
+
+		var res GenericItem
+
+		switch patched := patched.(type) {
+		case GenericItem:
+			res = patched
+		case map[string]interface{}:
+			res = patched
+		default:
+			return nil, InvalidPatchError{Reason: "patched item is not an object"}
+		}
+
+		if res["id"] != id {
+			return nil, InvalidPatchError{Reason: "id can not be changed"}
+		}
+
+		return res, nil
+	}, true
+}
+
+func applyPatch(svc Service, w http.ResponseWriter, r *http.Request) {
+	group := chi.URLParam(r, "group")
+	kind := chi.URLParam(r, "kind")
+	id := chi.URLParam(r, "id")
+
+	manager := r.URL.Query().Get("fieldManager")
+	if manager == "" {
+		w.WriteHeader(http.StatusBadRequest)
+
+		_ = json.NewEncoder(w).Encode(HTTPError{
+			Message: "Invalid request",
+			Error:   "fieldManager is required for apply",
+		})
+
+		return
+	}
+
+	force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+
+	var req GenericItem
+
+	err := decodeItem(r, &req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+
+		_ = json.NewEncoder(w).Encode(HTTPError{
+			Message: "Invalid request",
+			Error:   err.Error(),
+		})
+
+		return
+	}
+
+	ctx := WithPreconditions(requestContext(r), Preconditions{
+		IfMatch: ParseETags(r.Header.Get("If-Match")),
+	})
+
+	err = rejectComputedFields(ctx, svc, GetGroupKind(group, kind), id, req)
+	if err != nil {
+		writeError(w, err)
+
+		return
+	}
+
+	res, created, err := Apply(ctx, svc, GetGroupKind(group, kind), id, manager, req, force)
+	if err != nil {
+		writeError(w, err)
+
+		return
+	}
+
+	if !IsDryRun(ctx) {
+		setETag(w, ResourceVersionOf(res))
+	}
+
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	}
+
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+func IncrementHandler(svc Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		group := chi.URLParam(r, "group")
+		kind := chi.URLParam(r, "kind")
+		id := chi.URLParam(r, "id")
+
+		var req map[string]float64
+
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			_ = json.NewEncoder(w).Encode(HTTPError{
+				Message: "Invalid request",
+				Error:   err.Error(),
+			})
+
+			return
+		}
+
+		ctx := requestContext(r)
+
+		err = rejectComputedPaths(ctx, svc, GetGroupKind(group, kind), req)
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		res, err := Increment(ctx, svc, GetGroupKind(group, kind), id, req)
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		if !IsDryRun(ctx) {
+			setETag(w, ResourceVersionOf(res))
+		}
+
+		_ = json.NewEncoder(w).Encode(res)
+	}
+}
+
+func TransitionHandler(svc Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		group := chi.URLParam(r, "group")
+		kind := chi.URLParam(r, "kind")
+		id := chi.URLParam(r, "id")
+		name := chi.URLParam(r, "name")
+
+		ctx := WithPreconditions(requestContext(r), Preconditions{
+			IfMatch: ParseETags(r.Header.Get("If-Match")),
+		})
+
+		res, err := Transition(ctx, svc, GetGroupKind(group, kind), id, name)
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		if !IsDryRun(ctx) {
+			setETag(w, ResourceVersionOf(res))
+		}
+
+		_ = json.NewEncoder(w).Encode(res)
+	}
+}
+
+type SequenceResponse struct {
+	Name  string `json:"name"`
+	Value int64  `json:"value"`
+}
+
+// SequenceHandler hands out the next value of the sequence named in the path, if it is one of names.
+func SequenceHandler(seq *Sequences, names ...string) http.HandlerFunc {
+	served := make(map[string]bool, len(names))
+	for i := range names {
+		served[names[i]] = true
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "name")
+		if !served[name] {
+			writeError(w, ItemNotFoundError{ID: name})
+
+			return
+		}
+
+		value, err := seq.Next(r.Context(), name)
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(SequenceResponse{Name: name, Value: value})
+	}
+}
+
 func DeleteHandler(svc Service) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		group := chi.URLParam(r, "group")
 		kind := chi.URLParam(r, "kind")
 		id := chi.URLParam(r, "id")
 
-		err := svc.Delete(r.Context(), GetGroupKind(group, kind), id)
-		if err != nil {
-			switch {
-			case errors.As(err, &GroupKindNotFoundError{}):
-				w.WriteHeader(http.StatusNotFound)
-
-				_ = json.NewEncoder(w).Encode(HTTPError{
-					Message: "Invalid kind",
-					Error:   err.Error(),
-				})
-			case errors.As(err, &ItemNotFoundError{}):
-				w.WriteHeader(http.StatusNotFound)
-
-				_ = json.NewEncoder(w).Encode(HTTPError{
-					Message: "Item not found",
-					Error:   err.Error(),
-				})
-			default:
-				w.WriteHeader(http.StatusInternalServerError)
-
-				_ = json.NewEncoder(w).Encode(HTTPError{
-					Message: "Unexpected error occurred",
-					Error:   err.Error(),
-				})
+		policy, err := ParsePropagationPolicy(r.URL.Query().Get("propagationPolicy"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			_ = json.NewEncoder(w).Encode(HTTPError{
+				Message: "Invalid propagation policy",
+				Error:   err.Error(),
+			})
+
+			return
+		}
+
+		ctx := WithPropagationPolicy(requestContext(r), policy)
+		ctx = WithPreconditions(ctx, Preconditions{
+			IfMatch:         ParseETags(r.Header.Get("If-Match")),
+			ResourceVersion: r.URL.Query().Get("resourceVersion"),
+		})
+
+		// a dry run does not mark items with finalizers, so they are marked as the delete would
+		var marked GenericItem
+
+		if _, ok := Lookup[*Finalizers](svc); ok && IsDryRun(ctx) {
+			item, err := svc.Read(ctx, GetGroupKind(group, kind), id)
+			if err == nil && len(GetFinalizers(item)) > 0 {
+				marked = item
+
+				if !IsDeleting(marked) {
+					marked["deletionTimestamp"] = time.Now().Format(time.RFC3339)
+				}
 			}
+		}
+
+		err = svc.Delete(ctx, GetGroupKind(group, kind), id)
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		if marked != nil {
+			w.WriteHeader(http.StatusAccepted)
+			_ = json.NewEncoder(w).Encode(marked)
+
+			return
+		}
+
+		res, err := svc.Read(r.Context(), GetGroupKind(group, kind), id)
+		if err == nil && IsDeleting(res) {
+			w.WriteHeader(http.StatusAccepted)
+			_ = json.NewEncoder(w).Encode(res)
 
 			return
 		}
@@ -246,3 +665,168 @@ func DeleteHandler(svc Service) http.HandlerFunc {
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.As(err, &GroupKindNotFoundError{}):
+		w.WriteHeader(http.StatusNotFound)
+
+		_ = json.NewEncoder(w).Encode(HTTPError{
+			Message: "Invalid kind",
+			Error:   err.Error(),
+		})
+	case errors.As(err, &ItemNotFoundError{}):
+		w.WriteHeader(http.StatusNotFound)
+
+		_ = json.NewEncoder(w).Encode(HTTPError{
+			Message: "Item not found",
+			Error:   err.Error(),
+		})
+	case errors.As(err, &ItemExistsError{}):
+		w.WriteHeader(http.StatusConflict)
+
+		_ = json.NewEncoder(w).Encode(HTTPError{
+			Message: "Item exists",
+			Error:   err.Error(),
+		})
+	case errors.As(err, &ConflictError{}):
+		w.WriteHeader(http.StatusConflict)
+
+		_ = json.NewEncoder(w).Encode(HTTPError{
+			Message: "Item has been modified",
+			Error:   err.Error(),
+		})
+	case errors.As(err, &InvalidIDError{}):
+		w.WriteHeader(http.StatusBadRequest)
+
+		_ = json.NewEncoder(w).Encode(HTTPError{
+			Message: "Invalid id",
+			Error:   err.Error(),
+		})
+	case errors.As(err, &InvalidPatchError{}):
+		w.WriteHeader(http.StatusUnprocessableEntity)
+
+		_ = json.NewEncoder(w).Encode(HTTPError{
+			Message: "Invalid patch",
+			Error:   err.Error(),
+		})
+	case errors.As(err, &ApplyConflictError{}):
+		w.WriteHeader(http.StatusConflict)
+
+		_ = json.NewEncoder(w).Encode(HTTPError{
+			Message: "Apply conflict",
+			Error:   err.Error(),
+		})
+	case errors.As(err, &FieldError{}):
+		w.WriteHeader(http.StatusUnprocessableEntity)
+
+		_ = json.NewEncoder(w).Encode(HTTPError{
+			Message: "Invalid field",
+			Error:   err.Error(),
+		})
+	case errors.As(err, &PreconditionFailedError{}):
+		w.WriteHeader(http.StatusPreconditionFailed)
+
+		_ = json.NewEncoder(w).Encode(HTTPError{
+			Message: "Precondition failed",
+			Error:   err.Error(),
+		})
+	case errors.As(err, &InvalidExprError{}):
+		w.WriteHeader(http.StatusBadRequest)
+
+		_ = json.NewEncoder(w).Encode(HTTPError{
+			Message: "Invalid expression",
+			Error:   err.Error(),
+		})
+	case errors.As(err, &ResourceVersionExpiredError{}):
+		w.WriteHeader(http.StatusGone)
+
+		_ = json.NewEncoder(w).Encode(HTTPError{
+			Message: "Resource version expired",
+			Error:   err.Error(),
+		})
+	case errors.As(err, &WatchNotSupportedError{}):
+		w.WriteHeader(http.StatusNotImplemented)
+
+		_ = json.NewEncoder(w).Encode(HTTPError{
+			Message: "Watch not supported",
+			Error:   err.Error(),
+		})
+	case errors.As(err, &TxNotSupportedError{}):
+		w.WriteHeader(http.StatusNotImplemented)
+
+		_ = json.NewEncoder(w).Encode(HTTPError{
+			Message: "Transactions not supported",
+			Error:   err.Error(),
+		})
+	case errors.As(err, &TransitionNotFoundError{}):
+		w.WriteHeader(http.StatusNotFound)
+
+		_ = json.NewEncoder(w).Encode(HTTPError{
+			Message: "Transition not found",
+			Error:   err.Error(),
+		})
+	case errors.As(err, &InvalidTransitionError{}):
+		w.WriteHeader(http.StatusConflict)
+
+		_ = json.NewEncoder(w).Encode(HTTPError{
+			Message: "Invalid transition",
+			Error:   err.Error(),
+		})
+	case errors.As(err, &AdmissionWebhookError{}):
+		w.WriteHeader(http.StatusBadGateway)
+
+		_ = json.NewEncoder(w).Encode(HTTPError{
+			Message: "Admission webhook failed",
+			Error:   err.Error(),
+		})
+	case errors.As(err, &ScriptError{}):
+		w.WriteHeader(http.StatusInternalServerError)
+
+		_ = json.NewEncoder(w).Encode(HTTPError{
+			Message: "Script failed",
+			Error:   err.Error(),
+		})
+	case errors.As(err, &PluginError{}):
+		w.WriteHeader(http.StatusInternalServerError)
+
+		_ = json.NewEncoder(w).Encode(HTTPError{
+			Message: "Plugin failed",
+			Error:   err.Error(),
+		})
+	case errors.As(err, &VetoError{}):
+		var veto VetoError
+
+		errors.As(err, &veto)
+
+		status := veto.StatusCode
+		if status < 400 || status > 499 {
+			status = http.StatusBadRequest
+		}
+
+		w.WriteHeader(status)
+
+		_ = json.NewEncoder(w).Encode(HTTPError{
+			Message: "Operation vetoed",
+			Error:   err.Error(),
+		})
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+
+		_ = json.NewEncoder(w).Encode(HTTPError{
+			Message: "Unexpected error occurred",
+			Error:   err.Error(),
+		})
+	}
+}
+
+var errNotAnObject = errors.New("request body must be a json object")
+
+func decodeItem(r *http.Request, req *GenericItem) error {
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err == nil && *req == nil {
+		return errNotAnObject
+	}
+
+	return err
+}
@@ -0,0 +1,104 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+type HandlerOption func(*handlerOptions)
+
+type handlerOptions struct {
+	cacheControl        map[string]string
+	defaultCacheControl string
+	upsert              bool
+	sequences           *Sequences
+	sequenceNames       []string
+	idempotency         *Idempotency
+	webSocket           WebSocketOptions
+	changeLog           *ChangeLog
+	actorHeader         string
+}
+
+func newHandlerOptions(opts []HandlerOption) *handlerOptions {
+	res := &handlerOptions{
+		cacheControl: make(map[string]string),
+	}
+
+	for i := range opts {
+		opts[i](res)
+	}
+
+	return res
+}
+
+func (o *handlerOptions) cacheControlOf(groupKind string) string {
+	if v, ok := o.cacheControl[groupKind]; ok {
+		return v
+	}
+
+	return o.defaultCacheControl
+}
+
+// WithCacheControl sets the Cache-Control header returned on reads of groupKind.
+func WithCacheControl(groupKind string, value string) HandlerOption {
+	return func(o *handlerOptions) {
+		o.cacheControl[groupKind] = value
+	}
+}
+
+// WithDefaultCacheControl sets the Cache-Control header returned on reads of kinds without their own policy.
+func WithDefaultCacheControl(value string) HandlerOption {
+	return func(o *handlerOptions) {
+		o.defaultCacheControl = value
+	}
+}
+
+// WithUpsert makes ReplaceHandler create items which do not exist yet.
+func WithUpsert() HandlerOption {
+	return func(o *handlerOptions) {
+		o.upsert = true
+	}
+}
+
+// WithSequences exposes the sequences of seq with given names on POST /_sequences/{name}. Names must not contain
+// dots, which keeps the sequences of kinds, see KindSequence, and the internal ones of the service out of reach.
+func WithSequences(seq *Sequences, names ...string) HandlerOption {
+	for i := range names {
+		if names[i] == "" || strings.Contains(names[i], ".") {
+			panic(fmt.Errorf("invalid sequence name '%s'", names[i]))
+		}
+	}
+
+	return func(o *handlerOptions) {
+		o.sequences = seq
+		o.sequenceNames = names
+	}
+}
+
+// WithIdempotency replays the responses of creates and batches which repeat an Idempotency-Key.
+func WithIdempotency(idempotency *Idempotency) HandlerOption {
+	return func(o *handlerOptions) {
+		o.idempotency = idempotency
+	}
+}
+
+// WithWebSocketOptions configures the subscriptions of GET /_ws.
+func WithWebSocketOptions(opts WebSocketOptions) HandlerOption {
+	return func(o *handlerOptions) {
+		o.webSocket = opts
+	}
+}
+
+// WithChangeLog exposes the changes of changeLog on GET /_changes.
+func WithChangeLog(changeLog *ChangeLog) HandlerOption {
+	return func(o *handlerOptions) {
+		o.changeLog = changeLog
+	}
+}
+
+// WithActorHeader takes the actor of the changes made by a request from its header, as set by an authenticating proxy.
+func WithActorHeader(header string) HandlerOption {
+	return func(o *handlerOptions) {
+		o.actorHeader = header
+	}
+}
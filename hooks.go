@@ -0,0 +1,300 @@
+package core
+
+import (
+	"context"
+	"sync"
+)
+
+// VetoError is returned by hooks to refuse an operation. It is answered with StatusCode, which must be a 4xx,
+// or 400 otherwise.
+type VetoError struct {
+	StatusCode int
+	Reason     string
+}
+
+func (err VetoError) Error() string {
+	return "operation vetoed: " + err.Reason
+}
+
+// ItemHook may change item, or refuse the operation by returning an error, preferably a VetoError.
+type ItemHook func(ctx context.Context, item GenericItem) error
+
+// ReplaceHook is like ItemHook, with the item as it was before the replace as old.
+type ReplaceHook func(ctx context.Context, old, item GenericItem) error
+
+type ReadHook func(ctx context.Context, id string) error
+
+type ListHook func(ctx context.Context) error
+
+// AfterListHook returns the items to respond with, so it may add to, remove or change them.
+type AfterListHook func(ctx context.Context, items []GenericItem) ([]GenericItem, error)
+
+type kindHooks struct {
+	beforeCreate  []ItemHook
+	afterCreate   []ItemHook
+	beforeReplace []ReplaceHook
+	afterReplace  []ReplaceHook
+	beforeDelete  []ItemHook
+	afterDelete   []ItemHook
+	beforeRead    []ReadHook
+	afterRead     []ItemHook
+	beforeList    []ListHook
+	afterList     []AfterListHook
+}
+
+// Hooks calls the functions registered for a kind before and after its operations, in the order they were registered.
+// Before hooks of writes run ahead of the write, and replaces and deletes fail with ConflictError if the item
+// changes meanwhile. Writes and their after hooks run in one transaction if next supports them, so an after hook
+// which fails undoes the write, and after hooks must not block, see Transactor.
+// Changes made by after hooks of writes are not stored, but show in the response.
+type Hooks struct {
+	next Service
+
+	mu    sync.RWMutex
+	kinds map[string]*kindHooks
+}
+
+func (h *Hooks) hooksOf(groupKind string) kindHooks {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if kh, ok := h.kinds[groupKind]; ok {
+		return *kh
+	}
+
+	return kindHooks{}
+}
+
+func (h *Hooks) register(groupKind string, fn func(kh *kindHooks)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	kh, ok := h.kinds[groupKind]
+	if !ok {
+		kh = new(kindHooks)
+		h.kinds[groupKind] = kh
+	}
+
+	fn(kh)
+}
+
+func (h *Hooks) BeforeCreate(groupKind string, fn ItemHook) {
+	h.register(groupKind, func(kh *kindHooks) { kh.beforeCreate = append(kh.beforeCreate, fn) })
+}
+
+func (h *Hooks) AfterCreate(groupKind string, fn ItemHook) {
+	h.register(groupKind, func(kh *kindHooks) { kh.afterCreate = append(kh.afterCreate, fn) })
+}
+
+func (h *Hooks) BeforeReplace(groupKind string, fn ReplaceHook) {
+	h.register(groupKind, func(kh *kindHooks) { kh.beforeReplace = append(kh.beforeReplace, fn) })
+}
+
+func (h *Hooks) AfterReplace(groupKind string, fn ReplaceHook) {
+	h.register(groupKind, func(kh *kindHooks) { kh.afterReplace = append(kh.afterReplace, fn) })
+}
+
+// BeforeDelete hooks get the item about to be deleted. Changes to it are ignored.
+func (h *Hooks) BeforeDelete(groupKind string, fn ItemHook) {
+	h.register(groupKind, func(kh *kindHooks) { kh.beforeDelete = append(kh.beforeDelete, fn) })
+}
+
+// AfterDelete hooks get the item as it was before it was deleted.
+func (h *Hooks) AfterDelete(groupKind string, fn ItemHook) {
+	h.register(groupKind, func(kh *kindHooks) { kh.afterDelete = append(kh.afterDelete, fn) })
+}
+
+func (h *Hooks) BeforeRead(groupKind string, fn ReadHook) {
+	h.register(groupKind, func(kh *kindHooks) { kh.beforeRead = append(kh.beforeRead, fn) })
+}
+
+func (h *Hooks) AfterRead(groupKind string, fn ItemHook) {
+	h.register(groupKind, func(kh *kindHooks) { kh.afterRead = append(kh.afterRead, fn) })
+}
+
+func (h *Hooks) BeforeList(groupKind string, fn ListHook) {
+	h.register(groupKind, func(kh *kindHooks) { kh.beforeList = append(kh.beforeList, fn) })
+}
+
+func (h *Hooks) AfterList(groupKind string, fn AfterListHook) {
+	h.register(groupKind, func(kh *kindHooks) { kh.afterList = append(kh.afterList, fn) })
+}
+
+func runItemHooks(ctx context.Context, hooks []ItemHook, item GenericItem) error {
+	for i := range hooks {
+		err := hooks[i](ctx, item)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func runReplaceHooks(ctx context.Context, hooks []ReplaceHook, old, item GenericItem) error {
+	for i := range hooks {
+		err := hooks[i](ctx, old.DeepCopy(), item)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h *Hooks) List(ctx context.Context, groupKind string) ([]GenericItem, error) {
+	kh := h.hooksOf(groupKind)
+
+	for i := range kh.beforeList {
+		err := kh.beforeList[i](ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	items, err := h.next.List(ctx, groupKind)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range kh.afterList {
+		items, err = kh.afterList[i](ctx, items)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return items, nil
+}
+
+func (h *Hooks) Create(ctx context.Context, groupKind string, req GenericItem) error {
+	kh := h.hooksOf(groupKind)
+
+	err := runItemHooks(ctx, kh.beforeCreate, req)
+	if err != nil {
+		return err
+	}
+
+	return inTx(ctx, h.next, func(ctx context.Context) error {
+		err := h.next.Create(ctx, groupKind, req)
+		if err != nil {
+			return err
+		}
+
+		return runItemHooks(ctx, kh.afterCreate, req)
+	})
+}
+
+func (h *Hooks) Read(ctx context.Context, groupKind string, id string) (GenericItem, error) {
+	kh := h.hooksOf(groupKind)
+
+	for i := range kh.beforeRead {
+		err := kh.beforeRead[i](ctx, id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	item, err := h.next.Read(ctx, groupKind, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = runItemHooks(ctx, kh.afterRead, item)
+	if err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
+
+func (h *Hooks) Replace(ctx context.Context, groupKind string, id string, req GenericItem) error {
+	kh := h.hooksOf(groupKind)
+
+	if len(kh.beforeReplace) == 0 && len(kh.afterReplace) == 0 {
+		return h.next.Replace(ctx, groupKind, id, req)
+	}
+
+	old, err := h.next.Read(ctx, groupKind, id)
+	if err != nil {
+		return err
+	}
+
+	err = runReplaceHooks(ctx, kh.beforeReplace, old, req)
+	if err != nil {
+		return err
+	}
+
+	return inTx(ctx, h.next, func(ctx context.Context) error {
+		err := h.unchanged(ctx, groupKind, id, old)
+		if err != nil {
+			return err
+		}
+
+		err = h.next.Replace(withResourceVersion(ctx, ResourceVersionOf(old)), groupKind, id, req)
+		if err != nil {
+			return err
+		}
+
+		return runReplaceHooks(ctx, kh.afterReplace, old, req)
+	})
+}
+
+func (h *Hooks) Delete(ctx context.Context, groupKind string, id string) error {
+	kh := h.hooksOf(groupKind)
+
+	if len(kh.beforeDelete) == 0 && len(kh.afterDelete) == 0 {
+		return h.next.Delete(ctx, groupKind, id)
+	}
+
+	item, err := h.next.Read(ctx, groupKind, id)
+	if err != nil {
+		return err
+	}
+
+	err = runItemHooks(ctx, kh.beforeDelete, item.DeepCopy())
+	if err != nil {
+		return err
+	}
+
+	return inTx(ctx, h.next, func(ctx context.Context) error {
+		err := h.unchanged(ctx, groupKind, id, item)
+		if err != nil {
+			return err
+		}
+
+		err = h.next.Delete(withResourceVersion(ctx, ResourceVersionOf(item)), groupKind, id)
+		if err != nil {
+			return err
+		}
+
+		return runItemHooks(ctx, kh.afterDelete, item)
+	})
+}
+
+// unchanged returns ConflictError if item has changed since the before hooks got it.
+func (h *Hooks) unchanged(ctx context.Context, groupKind string, id string, item GenericItem) error {
+	current, err := h.next.Read(ctx, groupKind, id)
+	if err != nil {
+		return err
+	}
+
+	if ResourceVersionOf(current) != ResourceVersionOf(item) {
+		return ConflictError{ID: id}
+	}
+
+	return nil
+}
+
+func (h *Hooks) Unwrap() Service {
+	return h.next
+}
+
+var _ Service = new(Hooks)
+
+func NewHooks(next Service) *Hooks {
+	return &Hooks{
+		next:  next,
+		kinds: make(map[string]*kindHooks),
+	}
+}
@@ -0,0 +1,163 @@
+package core_test
+
+import (
+	"context"
+	"errors"
+	"github.com/applicaset/core"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"strings"
+)
+
+var _ = Describe("Hooks", func() {
+	var (
+		hooks *core.Hooks
+		h     *core.Handler
+	)
+
+	BeforeEach(func() {
+		hooks = core.NewHooks(core.NewAutoFields(core.NewStore()))
+		h = core.NewHandler(hooks)
+	})
+
+	It("should let before hooks change and veto writes", func() {
+		hooks.BeforeCreate("acme/orders", func(ctx context.Context, item core.GenericItem) error {
+			if _, ok := item["total"]; !ok {
+				return core.VetoError{StatusCode: http.StatusForbidden, Reason: "orders need a total"}
+			}
+
+			item["status"] = "new"
+
+			return nil
+		})
+
+		hooks.BeforeReplace("acme/orders", func(ctx context.Context, old, item core.GenericItem) error {
+			if old["status"] == "shipped" {
+				return core.VetoError{StatusCode: http.StatusConflict, Reason: "shipped orders can not change"}
+			}
+
+			return nil
+		})
+
+		hooks.BeforeDelete("acme/orders", func(ctx context.Context, item core.GenericItem) error {
+			return core.VetoError{Reason: "orders are kept"}
+		})
+
+		res := doRequest(h, http.MethodPost, "/acme/orders", `{"id":"o1"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusForbidden))
+		Expect(decodeBody(res)["message"]).Should(Equal("Operation vetoed"))
+
+		res = doRequest(h, http.MethodPost, "/acme/orders", `{"id":"o1","total":10}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+		Expect(decodeBody(res)["status"]).Should(Equal("new"))
+
+		res = doRequest(h, http.MethodPut, "/acme/orders/o1", `{"id":"o1","total":10,"status":"shipped"}`)
+		Expect(res.StatusCode).Should(BeNumerically("<", 300))
+
+		res = doRequest(h, http.MethodPut, "/acme/orders/o1", `{"id":"o1","total":20}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusConflict))
+
+		res = doRequest(h, http.MethodDelete, "/acme/orders/o1", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusBadRequest))
+
+		res = doRequest(h, http.MethodGet, "/acme/orders/o1", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+		Expect(decodeBody(res)["total"]).Should(BeEquivalentTo(10))
+	})
+
+	It("should undo writes whose after hooks fail", func() {
+		hooks.AfterCreate("acme/orders", func(ctx context.Context, item core.GenericItem) error {
+			if strings.HasPrefix(item.GetID(), "bad") {
+				return errors.New("notification failed")
+			}
+
+			return nil
+		})
+
+		res := doRequest(h, http.MethodPost, "/acme/orders", `{"id":"bad1"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusInternalServerError))
+
+		res = doRequest(h, http.MethodGet, "/acme/orders/bad1", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNotFound))
+
+		res = doRequest(h, http.MethodPost, "/acme/orders", `{"id":"good1"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+	})
+
+	It("should run before hooks outside of the write and refuse it if the item changed meanwhile", func() {
+		concurrently := func(fn func() error) error {
+			done := make(chan error)
+
+			go func() { done <- fn() }()
+
+			return <-done
+		}
+
+		hooks.BeforeReplace("acme/orders", func(ctx context.Context, old, item core.GenericItem) error {
+			return concurrently(func() error {
+				return hooks.Unwrap().Replace(context.Background(), "acme/orders", "o1", core.GenericItem{"id": "o1", "total": 30})
+			})
+		})
+
+		hooks.BeforeDelete("acme/orders", func(ctx context.Context, item core.GenericItem) error {
+			return concurrently(func() error {
+				return hooks.Unwrap().Replace(context.Background(), "acme/orders", "o1", core.GenericItem{"id": "o1", "total": 40})
+			})
+		})
+
+		res := doRequest(h, http.MethodPost, "/acme/orders", `{"id":"o1","total":10}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		res = doRequest(h, http.MethodPut, "/acme/orders/o1", `{"id":"o1","total":20}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusConflict))
+
+		res = doRequest(h, http.MethodDelete, "/acme/orders/o1", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusConflict))
+
+		res = doRequest(h, http.MethodGet, "/acme/orders/o1", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+		Expect(decodeBody(res)["total"]).Should(BeEquivalentTo(40))
+	})
+
+	It("should let after hooks enrich reads and lists", func() {
+		hooks.AfterRead("acme/orders", func(ctx context.Context, item core.GenericItem) error {
+			item["link"] = "/orders/" + item.GetID()
+
+			return nil
+		})
+
+		hooks.AfterList("acme/orders", func(ctx context.Context, items []core.GenericItem) ([]core.GenericItem, error) {
+			res := make([]core.GenericItem, 0, len(items))
+
+			for i := range items {
+				if items[i]["hidden"] != true {
+					res = append(res, items[i])
+				}
+			}
+
+			return res, nil
+		})
+
+		hooks.BeforeRead("acme/orders", func(ctx context.Context, id string) error {
+			if id == "secret" {
+				return core.VetoError{StatusCode: http.StatusForbidden, Reason: "not allowed"}
+			}
+
+			return nil
+		})
+
+		Expect(doRequest(h, http.MethodPost, "/acme/orders", `{"id":"o1"}`).StatusCode).Should(Equal(http.StatusCreated))
+		Expect(doRequest(h, http.MethodPost, "/acme/orders", `{"id":"o2","hidden":true}`).StatusCode).Should(Equal(http.StatusCreated))
+		Expect(doRequest(h, http.MethodPost, "/acme/orders", `{"id":"secret"}`).StatusCode).Should(Equal(http.StatusCreated))
+
+		body := decodeBody(doRequest(h, http.MethodGet, "/acme/orders/o1", ""))
+		Expect(body["link"]).Should(Equal("/orders/o1"))
+
+		res := doRequest(h, http.MethodGet, "/acme/orders/secret", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusForbidden))
+
+		body = decodeBody(doRequest(h, http.MethodGet, "/acme/orders", ""))
+		Expect(body["items"]).Should(HaveLen(2))
+	})
+})
@@ -0,0 +1,263 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	IdempotencyGroupKind = "core/idempotencykeys"
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	maxIdempotencyKeyLength = 255
+)
+
+// replayedHeaders are the response headers kept with an idempotency record.
+var replayedHeaders = []string{"Content-Type", "ETag", "Location"}
+
+// Idempotency records the responses of requests with an Idempotency-Key header and replays them for repeated keys
+// within window. Records are kept as items of IdempotencyGroupKind in svc, which NewHandler does not serve.
+type Idempotency struct {
+	svc    Service
+	window time.Duration
+}
+
+func idempotencyID(r *http.Request, key string) string {
+	sum := sha256.Sum256([]byte(r.Method + " " + r.URL.Path + "\n" + key))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func idempotencyExpired(record GenericItem, now time.Time) bool {
+	s, _ := record["expiresAt"].(string)
+
+	expiresAt, err := time.Parse(time.RFC3339Nano, s)
+
+	return err != nil || !now.Before(expiresAt)
+}
+
+func (i *Idempotency) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" || IsDryRun(requestContext(r)) {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			w.WriteHeader(http.StatusBadRequest)
+
+			_ = json.NewEncoder(w).Encode(HTTPError{
+				Message: "Invalid idempotency key",
+				Error:   "idempotency key is too long",
+			})
+
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			_ = json.NewEncoder(w).Encode(HTTPError{
+				Message: "Invalid request",
+				Error:   err.Error(),
+			})
+
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		sum := sha256.Sum256(body)
+		fingerprint := hex.EncodeToString(sum[:])
+
+		id := idempotencyID(r, key)
+
+		record, err := i.acquire(r.Context(), id, GenericItem{
+			"id":          id,
+			"key":         key,
+			"method":      r.Method,
+			"path":        r.URL.Path,
+			"fingerprint": fingerprint,
+			"expiresAt":   time.Now().Add(i.window).Format(time.RFC3339Nano),
+		})
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		if record != nil {
+			i.replay(w, record, fingerprint)
+
+			return
+		}
+
+		rec := newResponseRecorder()
+
+		next.ServeHTTP(rec, r)
+
+		i.save(r.Context(), id, rec)
+
+		for k := range rec.header {
+			w.Header()[k] = rec.header[k]
+		}
+
+		w.WriteHeader(rec.status)
+		_, _ = w.Write(rec.body.Bytes())
+	})
+}
+
+// acquire creates the record of a key, or returns the existing one if it has not expired yet.
+func (i *Idempotency) acquire(ctx context.Context, id string, record GenericItem) (GenericItem, error) {
+	for ctx.Err() == nil {
+		err := i.svc.Create(ctx, IdempotencyGroupKind, record.DeepCopy())
+		if err == nil {
+			return nil, nil
+		}
+
+		if !errors.As(err, &ItemExistsError{}) {
+			return nil, err
+		}
+
+		existing, err := i.svc.Read(ctx, IdempotencyGroupKind, id)
+		if errors.As(err, &ItemNotFoundError{}) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !idempotencyExpired(existing, time.Now()) {
+			return existing, nil
+		}
+
+		err = i.svc.Delete(withResourceVersion(ctx, ResourceVersionOf(existing)), IdempotencyGroupKind, id)
+		if err != nil && !errors.As(err, &ItemNotFoundError{}) && !errors.As(err, &ConflictError{}) {
+			return nil, err
+		}
+	}
+
+	return nil, ctx.Err()
+}
+
+func (i *Idempotency) replay(w http.ResponseWriter, record GenericItem, fingerprint string) {
+	if record["fingerprint"] != fingerprint {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+
+		_ = json.NewEncoder(w).Encode(HTTPError{
+			Message: "Idempotency key reused",
+			Error:   "idempotency key has been used with a different request",
+		})
+
+		return
+	}
+
+	status, ok := toInt64(normalizeJSONValue(record["status"]))
+	if !ok {
+		w.WriteHeader(http.StatusConflict)
+
+		_ = json.NewEncoder(w).Encode(HTTPError{
+			Message: "Request in progress",
+			Error:   "a request with the same idempotency key is in progress",
+		})
+
+		return
+	}
+
+	header, _ := record["header"].(map[string]interface{})
+	for k := range header {
+		if v, ok := header[k].(string); ok {
+			w.Header().Set(k, v)
+		}
+	}
+
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(int(status))
+
+	body, _ := record["body"].(string)
+	_, _ = io.WriteString(w, body)
+}
+
+// save keeps the response of a key. Server errors are not kept, so the request can be retried.
+func (i *Idempotency) save(ctx context.Context, id string, rec *responseRecorder) {
+	if rec.status >= http.StatusInternalServerError {
+		_ = i.svc.Delete(ctx, IdempotencyGroupKind, id)
+
+		return
+	}
+
+	header := make(map[string]interface{})
+
+	for _, k := range replayedHeaders {
+		if v := rec.header.Get(k); v != "" {
+			header[k] = v
+		}
+	}
+
+	_, _ = Update(ctx, i.svc, IdempotencyGroupKind, id, func(item GenericItem) (GenericItem, error) {
+		item["status"] = rec.status
+		item["header"] = header
+		item["body"] = rec.body.String()
+
+		return item, nil
+	})
+}
+
+// Sweep deletes expired records.
+func (i *Idempotency) Sweep(ctx context.Context) error {
+	records, err := i.svc.List(ctx, IdempotencyGroupKind)
+	if err != nil {
+		if errors.As(err, &GroupKindNotFoundError{}) {
+			return nil
+		}
+
+		return err
+	}
+
+	now := time.Now()
+
+	for j := range records {
+		if !idempotencyExpired(records[j], now) {
+			continue
+		}
+
+		err = i.svc.Delete(withResourceVersion(ctx, ResourceVersionOf(records[j])), IdempotencyGroupKind, records[j].GetID())
+		if err != nil && !errors.As(err, &ItemNotFoundError{}) && !errors.As(err, &ConflictError{}) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Run sweeps expired records every window until ctx is done.
+func (i *Idempotency) Run(ctx context.Context) {
+	ticker := time.NewTicker(i.window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = i.Sweep(ctx)
+		}
+	}
+}
+
+func NewIdempotency(svc Service, window time.Duration) *Idempotency {
+	return &Idempotency{
+		svc:    svc,
+		window: window,
+	}
+}
@@ -0,0 +1,158 @@
+package core_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/applicaset/core"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+var _ = Describe("Idempotency", func() {
+	var (
+		store *core.Store
+		h     *core.Handler
+	)
+
+	newHandler := func(window time.Duration) {
+		store = core.NewStore()
+
+		var svc core.Service
+		svc = core.NewAutoFields(store)
+		svc = core.NewIDPolicies(svc, core.IDPolicy{})
+
+		h = core.NewHandler(svc, core.WithIdempotency(core.NewIdempotency(store, window)))
+	}
+
+	post := func(target string, key string, body string) *http.Response {
+		req := httptest.NewRequest(http.MethodPost, target, bytes.NewBufferString(body))
+		req.Header.Set(core.IdempotencyKeyHeader, key)
+
+		w := httptest.NewRecorder()
+
+		h.ServeHTTP(w, req)
+
+		return w.Result()
+	}
+
+	BeforeEach(func() {
+		newHandler(time.Hour)
+	})
+
+	It("should replay creates with the same key", func() {
+		res := post("/acme/orders", "key1", `{"total":10}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+		Expect(res.Header.Get("Idempotent-Replayed")).Should(BeEmpty())
+
+		etag := res.Header.Get("ETag")
+		first := decodeBody(res)
+
+		res = post("/acme/orders", "key1", `{"total":10}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+		Expect(res.Header.Get("Idempotent-Replayed")).Should(Equal("true"))
+		Expect(res.Header.Get("ETag")).Should(Equal(etag))
+		Expect(decodeBody(res)).Should(Equal(first))
+
+		body := decodeBody(doRequest(h, http.MethodGet, "/acme/orders", ""))
+		Expect(body["items"]).Should(HaveLen(1))
+	})
+
+	It("should not replay other keys or paths", func() {
+		res := post("/acme/orders", "key1", `{"total":10}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		res = post("/acme/orders", "key2", `{"total":10}`)
+		Expect(res.Header.Get("Idempotent-Replayed")).Should(BeEmpty())
+
+		res = post("/acme/invoices", "key1", `{"total":10}`)
+		Expect(res.Header.Get("Idempotent-Replayed")).Should(BeEmpty())
+
+		res = doRequest(h, http.MethodPost, "/acme/orders", `{"total":10}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		body := decodeBody(doRequest(h, http.MethodGet, "/acme/orders", ""))
+		Expect(body["items"]).Should(HaveLen(3))
+	})
+
+	It("should reject a key reused with another request", func() {
+		res := post("/acme/orders", "key1", `{"total":10}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		res = post("/acme/orders", "key1", `{"total":20}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+	})
+
+	It("should replay client errors", func() {
+		res := doRequest(h, http.MethodPost, "/acme/orders", `{"id":"order1"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		res = post("/acme/orders", "key1", `{"id":"order1"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusConflict))
+
+		res = doRequest(h, http.MethodDelete, "/acme/orders/order1", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNoContent))
+
+		res = post("/acme/orders", "key1", `{"id":"order1"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusConflict))
+		Expect(res.Header.Get("Idempotent-Replayed")).Should(Equal("true"))
+	})
+
+	It("should replay batches", func() {
+		batch := `{"operations":[{"op":"create","group":"acme","kind":"orders","body":{"total":10}}]}`
+
+		res := post("/_batch", "key1", batch)
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+
+		res = post("/_batch", "key1", batch)
+		Expect(res.Header.Get("Idempotent-Replayed")).Should(Equal("true"))
+
+		body := decodeBody(doRequest(h, http.MethodGet, "/acme/orders", ""))
+		Expect(body["items"]).Should(HaveLen(1))
+	})
+
+	It("should not serve records to clients", func() {
+		res := post("/acme/orders", "key1", `{"total":10}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		res = doRequest(h, http.MethodGet, "/core/idempotencykeys", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNotFound))
+
+		res = doRequest(h, http.MethodGet, "/core/idempotency%6Beys", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNotFound))
+
+		records, err := store.List(context.Background(), core.IdempotencyGroupKind)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(records).Should(HaveLen(1))
+
+		res = doRequest(h, http.MethodDelete, "/core/idempotencykeys/"+records[0].GetID(), "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNotFound))
+
+		res = doRequest(h, http.MethodPost, "/_batch", `{"operations":[{"op":"delete","group":"core","kind":"idempotencykeys","id":"`+records[0].GetID()+`"}]}`)
+		Expect(decodeBody(res)["results"]).Should(ConsistOf(HaveKeyWithValue("status", BeEquivalentTo(http.StatusNotFound))))
+	})
+
+	It("should forget keys after the window", func() {
+		newHandler(time.Millisecond)
+
+		res := post("/acme/orders", "key1", `{"total":10}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		time.Sleep(5 * time.Millisecond)
+
+		res = post("/acme/orders", "key1", `{"total":10}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+		Expect(res.Header.Get("Idempotent-Replayed")).Should(BeEmpty())
+
+		time.Sleep(5 * time.Millisecond)
+
+		err := core.NewIdempotency(store, time.Millisecond).Sweep(context.Background())
+		Expect(err).ShouldNot(HaveOccurred())
+
+		records, err := store.List(context.Background(), core.IdempotencyGroupKind)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(records).Should(BeEmpty())
+	})
+})
@@ -0,0 +1,187 @@
+package core
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/oklog/ulid/v2"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var DefaultIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._~-]{0,252}$`)
+
+type IDGenerator func(ctx context.Context, groupKind string, item GenericItem) (string, error)
+
+func UUIDv4() IDGenerator {
+	return func(context.Context, string, GenericItem) (string, error) {
+		return uuid.NewString(), nil
+	}
+}
+
+func UUIDv7() IDGenerator {
+	return func(context.Context, string, GenericItem) (string, error) {
+		id, err := uuid.NewV7()
+		if err != nil {
+			return "", err
+		}
+
+		return id.String(), nil
+	}
+}
+
+func ULID() IDGenerator {
+	return func(context.Context, string, GenericItem) (string, error) {
+		return strings.ToLower(ulid.Make().String()), nil
+	}
+}
+
+var nonSlugChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+func Slugify(s string) string {
+	return strings.Trim(nonSlugChars.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
+
+// SlugID derives ids from a string field of the item, so "Hello, World!" becomes "hello-world".
+func SlugID(field string) IDGenerator {
+	return func(_ context.Context, _ string, item GenericItem) (string, error) {
+		s, _ := item[field].(string)
+
+		id := Slugify(s)
+		if id == "" {
+			return "", InvalidIDError{ID: nil, Reason: "can not derive id from field '" + field + "'"}
+		}
+
+		return id, nil
+	}
+}
+
+// SequenceID hands out ids from the sequence of each kind, as named by KindSequence.
+func SequenceID(seq *Sequences) IDGenerator {
+	return func(ctx context.Context, groupKind string, _ GenericItem) (string, error) {
+		n, err := seq.Next(ctx, KindSequence(groupKind))
+		if err != nil {
+			return "", err
+		}
+
+		return strconv.FormatInt(n, 10), nil
+	}
+}
+
+type IDPolicy struct {
+	Generate IDGenerator
+	Pattern  *regexp.Regexp
+}
+
+// IDPolicies generates ids for created items which come without one and validates the ones clients provide.
+// Replaced items keep the id of their path, which they take if they come without one.
+type IDPolicies struct {
+	next          Service
+	defaultPolicy IDPolicy
+	policies      map[string]IDPolicy
+}
+
+func (p *IDPolicies) Register(groupKind string, policy IDPolicy) {
+	p.policies[groupKind] = policy
+}
+
+func (p *IDPolicies) policyOf(groupKind string) IDPolicy {
+	policy, ok := p.policies[groupKind]
+	if !ok {
+		policy = p.defaultPolicy
+	}
+
+	if policy.Generate == nil {
+		policy.Generate = p.defaultPolicy.Generate
+	}
+
+	if policy.Pattern == nil {
+		policy.Pattern = p.defaultPolicy.Pattern
+	}
+
+	return policy
+}
+
+func (p *IDPolicies) List(ctx context.Context, groupKind string) ([]GenericItem, error) {
+	return p.next.List(ctx, groupKind)
+}
+
+func (p *IDPolicies) Create(ctx context.Context, groupKind string, req GenericItem) error {
+	policy := p.policyOf(groupKind)
+
+	switch id := req["id"].(type) {
+	case nil:
+		generated, err := policy.Generate(ctx, groupKind, req)
+		if err != nil {
+			return err
+		}
+
+		req["id"] = generated
+	case string:
+		if id == "" {
+			generated, err := policy.Generate(ctx, groupKind, req)
+			if err != nil {
+				return err
+			}
+
+			req["id"] = generated
+
+			break
+		}
+
+		if policy.Pattern != nil && !policy.Pattern.MatchString(id) {
+			return InvalidIDError{ID: id, Reason: "must match " + policy.Pattern.String()}
+		}
+	default:
+		return InvalidIDError{ID: id, Reason: "must be a string"}
+	}
+
+	return p.next.Create(ctx, groupKind, req)
+}
+
+func (p *IDPolicies) Read(ctx context.Context, groupKind string, id string) (GenericItem, error) {
+	return p.next.Read(ctx, groupKind, id)
+}
+
+func (p *IDPolicies) Replace(ctx context.Context, groupKind string, id string, req GenericItem) error {
+	switch v := req["id"].(type) {
+	case nil:
+		req["id"] = id
+	case string:
+		if v != id {
+			return InvalidIDError{ID: v, Reason: "does not match the id in path"}
+		}
+	default:
+		return InvalidIDError{ID: v, Reason: "must be a string"}
+	}
+
+	return p.next.Replace(ctx, groupKind, id, req)
+}
+
+func (p *IDPolicies) Delete(ctx context.Context, groupKind string, id string) error {
+	return p.next.Delete(ctx, groupKind, id)
+}
+
+func (p *IDPolicies) Unwrap() Service {
+	return p.next
+}
+
+var _ Service = new(IDPolicies)
+
+// NewIDPolicies returns IDPolicies applying defaultPolicy to kinds without a registered policy.
+// Missing parts of defaultPolicy fall back to UUIDv4 and DefaultIDPattern.
+func NewIDPolicies(next Service, defaultPolicy IDPolicy) *IDPolicies {
+	if defaultPolicy.Generate == nil {
+		defaultPolicy.Generate = UUIDv4()
+	}
+
+	if defaultPolicy.Pattern == nil {
+		defaultPolicy.Pattern = DefaultIDPattern
+	}
+
+	return &IDPolicies{
+		next:          next,
+		defaultPolicy: defaultPolicy,
+		policies:      make(map[string]IDPolicy),
+	}
+}
@@ -0,0 +1,144 @@
+package core_test
+
+import (
+	"context"
+	"github.com/applicaset/core"
+	"github.com/google/uuid"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"regexp"
+	"testing"
+)
+
+func TestIDGenerators(t *testing.T) {
+	ctx := context.Background()
+
+	id, err := core.UUIDv4()(ctx, "acme/foo", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, uuid.Version(4), uuid.MustParse(id).Version())
+
+	id, err = core.UUIDv7()(ctx, "acme/foo", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, uuid.Version(7), uuid.MustParse(id).Version())
+
+	id, err = core.ULID()(ctx, "acme/foo", nil)
+	assert.NoError(t, err)
+	assert.Len(t, id, 26)
+
+	id, err = core.SlugID("title")(ctx, "acme/foo", core.GenericItem{"title": " Hello, World! "})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello-world", id)
+
+	_, err = core.SlugID("title")(ctx, "acme/foo", core.GenericItem{})
+	assert.ErrorAs(t, err, &core.InvalidIDError{})
+}
+
+func TestSequenceID(t *testing.T) {
+	ctx := context.Background()
+
+	generate := core.SequenceID(core.NewSequences(core.NewStore()))
+
+	id, err := generate(ctx, "acme/invoices", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", id)
+
+	id, err = generate(ctx, "acme/invoices", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "2", id)
+
+	id, err = generate(ctx, "acme/orders", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", id)
+}
+
+var _ = Describe("ID policies", func() {
+	var h *core.Handler
+
+	BeforeEach(func() {
+		var svc core.Service
+		svc = core.NewStore()
+		svc = core.NewAutoFields(svc)
+
+		policies := core.NewIDPolicies(svc, core.IDPolicy{})
+		policies.Register("acme/posts", core.IDPolicy{
+			Generate: core.SlugID("title"),
+			Pattern:  regexp.MustCompile(`^[a-z0-9-]+$`),
+		})
+
+		h = core.NewHandler(policies)
+	})
+
+	It("should generate missing id", func() {
+		res := doRequest(h, http.MethodPost, "/acme/foo", `{"bar":"baz"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		rsp := decodeBody(res)
+		Expect(uuid.Validate(rsp["id"].(string))).Should(Succeed())
+	})
+
+	It("should generate id by kind policy", func() {
+		res := doRequest(h, http.MethodPost, "/acme/posts", `{"title":"My First Post"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		rsp := decodeBody(res)
+		Expect(rsp).Should(HaveKeyWithValue("id", "my-first-post"))
+	})
+
+	It("should fail on non-string id", func() {
+		res := doRequest(h, http.MethodPost, "/acme/foo", `{"id":12}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusBadRequest))
+
+		rsp := decodeBody(res)
+		Expect(rsp).Should(HaveKeyWithValue("message", "Invalid id"))
+	})
+
+	It("should fail on id not matching pattern", func() {
+		res := doRequest(h, http.MethodPost, "/acme/posts", `{"id":"Not A Slug"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusBadRequest))
+
+		res = doRequest(h, http.MethodPost, "/acme/foo", `{"id":"foo/bar"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusBadRequest))
+	})
+
+	It("should keep ids of replaced items", func() {
+		res := doRequest(h, http.MethodPost, "/acme/foo", `{"id":"foo1"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		res = doRequest(h, http.MethodPut, "/acme/foo/foo1", `{"id":"foo2"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusBadRequest))
+		Expect(decodeBody(res)).Should(HaveKeyWithValue("message", "Invalid id"))
+
+		res = doRequest(h, http.MethodPut, "/acme/foo/foo1", `{"id":12}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusBadRequest))
+
+		res = doRequest(h, http.MethodPut, "/acme/foo/foo1", `{"bar":"baz"}`)
+		Expect(res.StatusCode).Should(BeNumerically("<", 300))
+
+		rsp := decodeBody(doRequest(h, http.MethodGet, "/acme/foo/foo1", ""))
+		Expect(rsp).Should(HaveKeyWithValue("id", "foo1"))
+		Expect(rsp).Should(HaveKeyWithValue("bar", "baz"))
+
+		res = doRequest(h, http.MethodGet, "/acme/foo/foo2", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNotFound))
+	})
+
+	It("should fail on null body", func() {
+		res := doRequest(h, http.MethodPost, "/acme/foo", `null`)
+		Expect(res.StatusCode).Should(Equal(http.StatusBadRequest))
+	})
+})
+
+var _ = Describe("Store without ID policies", func() {
+	var svc core.Service
+	svc = core.NewStore()
+	svc = core.NewAutoFields(svc)
+
+	h := core.NewHandler(svc)
+
+	It("should fail on missing id", func() {
+		res := doRequest(h, http.MethodPost, "/acme/foo", `{"bar":"baz"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusBadRequest))
+	})
+})
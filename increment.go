@@ -0,0 +1,53 @@
+package core
+
+import (
+	"context"
+	"strings"
+)
+
+// Increment adds deltas to numeric fields of an item without losing concurrent updates.
+// Fields are addressed by dot separated paths through objects, and missing ones start from zero.
+func Increment(ctx context.Context, svc Service, groupKind string, id string, deltas map[string]float64) (GenericItem, error) {
+	return Update(ctx, svc, groupKind, id, func(item GenericItem) (GenericItem, error) {
+		for field, delta := range deltas {
+			tokens := strings.Split(field, ".")
+
+			node := map[string]interface{}(item)
+
+			for _, token := range tokens[:len(tokens)-1] {
+				switch child := node[token].(type) {
+				case nil:
+					c := make(map[string]interface{})
+					node[token] = c
+					node = c
+				case map[string]interface{}:
+					node = child
+				case GenericItem:
+					node = child
+				default:
+					return nil, FieldError{Field: field, Reason: "does not lead through objects"}
+				}
+			}
+
+			last := tokens[len(tokens)-1]
+
+			var value float64
+
+			switch current := node[last].(type) {
+			case nil:
+			case float64:
+				value = current
+			case int64:
+				value = float64(current)
+			case int:
+				value = float64(current)
+			default:
+				return nil, FieldError{Field: field, Reason: "is not a number"}
+			}
+
+			node[last] = value + delta
+		}
+
+		return item, nil
+	})
+}
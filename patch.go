@@ -0,0 +1,347 @@
+package core
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+type InvalidPatchError struct {
+	Reason string
+}
+
+func (err InvalidPatchError) Error() string {
+	return fmt.Sprintf("invalid patch: %s", err.Reason)
+}
+
+// MergePatch applies patch to target as described in RFC 7396. target may be modified in place.
+func MergePatch(target interface{}, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	var t map[string]interface{}
+
+	switch v := target.(type) {
+	case map[string]interface{}:
+		t = v
+	case GenericItem:
+		t = v
+	default:
+		t = make(map[string]interface{})
+	}
+
+	for k := range p {
+		if p[k] == nil {
+			delete(t, k)
+
+			continue
+		}
+
+		t[k] = MergePatch(t[k], p[k])
+	}
+
+	return t
+}
+
+// ApplyJSONPatch applies operations to doc as described in RFC 6902. doc may be modified in place.
+func ApplyJSONPatch(doc interface{}, operations []map[string]interface{}) (interface{}, error) {
+	var err error
+
+	for i := range operations {
+		doc, err = applyJSONPatchOperation(doc, operations[i])
+		if err != nil {
+			return nil, InvalidPatchError{Reason: fmt.Sprintf("operation %d: %s", i, err.Error())}
+		}
+	}
+
+	return doc, nil
+}
+
+func applyJSONPatchOperation(doc interface{}, operation map[string]interface{}) (interface{}, error) {
+	op, _ := operation["op"].(string)
+
+	path, ok := operation["path"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing path")
+	}
+
+	tokens, err := parseJSONPointer(path)
+	if err != nil {
+		return nil, err
+	}
+
+	value, hasValue := operation["value"]
+
+	switch op {
+	case "add", "replace", "test":
+		if !hasValue {
+			return nil, fmt.Errorf("missing value")
+		}
+	case "move", "copy":
+		from, ok := operation["from"].(string)
+		if !ok {
+			return nil, fmt.Errorf("missing from")
+		}
+
+		fromTokens, err := parseJSONPointer(from)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err = jsonPointerGet(doc, fromTokens)
+		if err != nil {
+			return nil, err
+		}
+
+		if op == "move" {
+			if strings.HasPrefix(path+"/", from+"/") && path != from {
+				return nil, fmt.Errorf("can not move '%s' into one of its children", from)
+			}
+
+			doc, err = jsonPointerRemove(doc, fromTokens)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			value = deepCopyValue(value)
+		}
+
+		return jsonPointerAdd(doc, tokens, value)
+	}
+
+	switch op {
+	case "add":
+		return jsonPointerAdd(doc, tokens, value)
+	case "remove":
+		return jsonPointerRemove(doc, tokens)
+	case "replace":
+		if _, err := jsonPointerGet(doc, tokens); err != nil {
+			return nil, err
+		}
+
+		if len(tokens) == 0 {
+			return value, nil
+		}
+
+		return jsonPointerMutate(doc, tokens, func(parent interface{}, key string) (interface{}, error) {
+			switch parent := parent.(type) {
+			case map[string]interface{}:
+				parent[key] = value
+
+				return parent, nil
+			case []interface{}:
+				idx, err := arrayIndex(key, len(parent)-1)
+				if err != nil {
+					return nil, err
+				}
+
+				parent[idx] = value
+
+				return parent, nil
+			default:
+				return nil, fmt.Errorf("path '%s' does not exist", key)
+			}
+		})
+	case "test":
+		current, err := jsonPointerGet(doc, tokens)
+		if err != nil {
+			return nil, err
+		}
+
+		if !reflect.DeepEqual(normalizeJSONValue(current), normalizeJSONValue(value)) {
+			return nil, fmt.Errorf("test failed for path '%s'", path)
+		}
+
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("unknown operation '%s'", op)
+	}
+}
+
+func normalizeJSONValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case GenericItem:
+		return normalizeJSONValue(map[string]interface{}(v))
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for k := range v {
+			res[k] = normalizeJSONValue(v[k])
+		}
+
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i := range v {
+			res[i] = normalizeJSONValue(v[i])
+		}
+
+		return res
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	default:
+		return v
+	}
+}
+
+func parseJSONPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid json pointer '%s'", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(tokens[i], "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+func arrayIndex(token string, max int) (int, error) {
+	idx, err := strconv.Atoi(token)
+	if err != nil || idx < 0 || idx > max || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index '%s'", token)
+	}
+
+	return idx, nil
+}
+
+func jsonPointerChild(node interface{}, token string) (interface{}, error) {
+	switch node := node.(type) {
+	case map[string]interface{}:
+		v, ok := node[token]
+		if !ok {
+			return nil, fmt.Errorf("path '%s' does not exist", token)
+		}
+
+		return v, nil
+	case GenericItem:
+		return jsonPointerChild(map[string]interface{}(node), token)
+	case []interface{}:
+		idx, err := arrayIndex(token, len(node)-1)
+		if err != nil {
+			return nil, err
+		}
+
+		return node[idx], nil
+	default:
+		return nil, fmt.Errorf("path '%s' does not exist", token)
+	}
+}
+
+func jsonPointerGet(doc interface{}, tokens []string) (interface{}, error) {
+	var err error
+
+	for i := range tokens {
+		doc, err = jsonPointerChild(doc, tokens[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return doc, nil
+}
+
+// jsonPointerMutate calls fn with the parent of the location tokens points to, and stores its result in place of the parent.
+func jsonPointerMutate(node interface{}, tokens []string, fn func(parent interface{}, key string) (interface{}, error)) (interface{}, error) {
+	if g, ok := node.(GenericItem); ok {
+		node = map[string]interface{}(g)
+	}
+
+	if len(tokens) == 1 {
+		return fn(node, tokens[0])
+	}
+
+	child, err := jsonPointerChild(node, tokens[0])
+	if err != nil {
+		return nil, err
+	}
+
+	child, err = jsonPointerMutate(child, tokens[1:], fn)
+	if err != nil {
+		return nil, err
+	}
+
+	switch node := node.(type) {
+	case map[string]interface{}:
+		node[tokens[0]] = child
+	case []interface{}:
+		idx, _ := strconv.Atoi(tokens[0])
+		node[idx] = child
+	}
+
+	return node, nil
+}
+
+func jsonPointerAdd(doc interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	return jsonPointerMutate(doc, tokens, func(parent interface{}, key string) (interface{}, error) {
+		switch parent := parent.(type) {
+		case map[string]interface{}:
+			parent[key] = value
+
+			return parent, nil
+		case []interface{}:
+			if key == "-" {
+				return append(parent, value), nil
+			}
+
+			idx, err := arrayIndex(key, len(parent))
+			if err != nil {
+				return nil, err
+			}
+
+			parent = append(parent, nil)
+			copy(parent[idx+1:], parent[idx:])
+			parent[idx] = value
+
+			return parent, nil
+		default:
+			return nil, fmt.Errorf("can not add '%s' to a scalar value", key)
+		}
+	})
+}
+
+func jsonPointerRemove(doc interface{}, tokens []string) (interface{}, error) {
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("can not remove the whole document")
+	}
+
+	return jsonPointerMutate(doc, tokens, func(parent interface{}, key string) (interface{}, error) {
+		switch parent := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := parent[key]; !ok {
+				return nil, fmt.Errorf("path '%s' does not exist", key)
+			}
+
+			delete(parent, key)
+
+			return parent, nil
+		case []interface{}:
+			idx, err := arrayIndex(key, len(parent)-1)
+			if err != nil {
+				return nil, err
+			}
+
+			return append(parent[:idx], parent[idx+1:]...), nil
+		default:
+			return nil, fmt.Errorf("path '%s' does not exist", key)
+		}
+	})
+}
@@ -0,0 +1,154 @@
+package core_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/applicaset/core"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	var target, patch, expected interface{}
+
+	_ = json.Unmarshal([]byte(`{"a":"b","c":{"d":"e","f":"g"}}`), &target)
+	_ = json.Unmarshal([]byte(`{"a":"z","c":{"f":null}}`), &patch)
+	_ = json.Unmarshal([]byte(`{"a":"z","c":{"d":"e"}}`), &expected)
+
+	assert.Equal(t, expected, core.MergePatch(target, patch))
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	var doc, expected interface{}
+
+	var operations []map[string]interface{}
+
+	_ = json.Unmarshal([]byte(`{"foo":["bar","baz"],"qux":{"a":1}}`), &doc)
+	_ = json.Unmarshal([]byte(`[
+		{"op":"test","path":"/qux/a","value":1},
+		{"op":"add","path":"/foo/1","value":"new"},
+		{"op":"remove","path":"/foo/2"},
+		{"op":"replace","path":"/qux/a","value":2},
+		{"op":"copy","from":"/qux","path":"/copied"},
+		{"op":"move","from":"/foo/0","path":"/moved"},
+		{"op":"add","path":"/foo/-","value":"last"},
+		{"op":"add","path":"/a~1b","value":true}
+	]`), &operations)
+	_ = json.Unmarshal([]byte(`{"foo":["new","last"],"qux":{"a":2},"copied":{"a":2},"moved":"bar","a/b":true}`), &expected)
+
+	res, err := core.ApplyJSONPatch(doc, operations)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, res)
+}
+
+func TestApplyJSONPatchFailure(t *testing.T) {
+	var doc interface{}
+
+	_ = json.Unmarshal([]byte(`{"foo":"bar"}`), &doc)
+
+	for _, operations := range []string{
+		`[{"op":"test","path":"/foo","value":"baz"}]`,
+		`[{"op":"remove","path":"/baz"}]`,
+		`[{"op":"replace","path":"/baz","value":1}]`,
+		`[{"op":"add","path":"/foo/bar/baz","value":1}]`,
+		`[{"op":"unknown","path":"/foo"}]`,
+		`[{"op":"add","path":"foo","value":1}]`,
+	} {
+		var ops []map[string]interface{}
+
+		_ = json.Unmarshal([]byte(operations), &ops)
+
+		_, err := core.ApplyJSONPatch(doc, ops)
+		assert.ErrorAs(t, err, &core.InvalidPatchError{}, operations)
+	}
+}
+
+var _ = Describe("Patch", Ordered, func() {
+	var svc core.Service
+	svc = core.NewStore()
+	svc = core.NewAutoFields(svc)
+
+	h := core.NewHandler(svc)
+
+	patch := func(contentType, body string) *http.Response {
+		req := httptest.NewRequest(http.MethodPatch, "/acme/foo/foo1", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", contentType)
+
+		w := httptest.NewRecorder()
+
+		h.ServeHTTP(w, req)
+
+		return w.Result()
+	}
+
+	BeforeAll(func() {
+		res := doRequest(h, http.MethodPost, "/acme/foo", `{"id":"foo1","bar":"baz","tags":["a"],"nested":{"x":1,"y":2}}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+	})
+
+	It("should apply merge patch", func() {
+		res := patch(core.MergePatchType, `{"bar":"baz2","nested":{"y":null}}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+		Expect(res.Header.Get("ETag")).ShouldNot(BeEmpty())
+
+		rsp := decodeBody(res)
+		Expect(rsp).Should(HaveKeyWithValue("bar", "baz2"))
+		Expect(rsp).Should(HaveKeyWithValue("nested", map[string]interface{}{"x": float64(1)}))
+		Expect(rsp).Should(HaveKey("uuid"))
+
+		rsp = decodeBody(doRequest(h, http.MethodGet, "/acme/foo/foo1", ""))
+		Expect(rsp).Should(HaveKeyWithValue("bar", "baz2"))
+	})
+
+	It("should apply json patch", func() {
+		res := patch(core.JSONPatchType, `[{"op":"add","path":"/tags/-","value":"b"},{"op":"remove","path":"/nested"}]`)
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+
+		rsp := decodeBody(res)
+		Expect(rsp).Should(HaveKeyWithValue("tags", []interface{}{"a", "b"}))
+		Expect(rsp).ShouldNot(HaveKey("nested"))
+	})
+
+	It("should refresh updatedAt", func() {
+		rsp := decodeBody(patch(core.MergePatchType, `{"updatedAt":"2000-01-01T00:00:00Z"}`))
+		Expect(rsp["updatedAt"]).ShouldNot(Equal("2000-01-01T00:00:00Z"))
+	})
+
+	It("should fail on failed test operation", func() {
+		res := patch(core.JSONPatchType, `[{"op":"test","path":"/bar","value":"other"}]`)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+
+		rsp := decodeBody(res)
+		Expect(rsp).Should(HaveKeyWithValue("message", "Invalid patch"))
+	})
+
+	It("should fail on changing id", func() {
+		res := patch(core.MergePatchType, `{"id":"foo2"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+	})
+
+	It("should fail on stale resource version", func() {
+		res := patch(core.MergePatchType, `{"resourceVersion":"0"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusConflict))
+	})
+
+	It("should fail on unsupported content type", func() {
+		res := patch("application/json", `{}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnsupportedMediaType))
+	})
+
+	It("should fail on not existed item", func() {
+		req := httptest.NewRequest(http.MethodPatch, "/acme/foo/foo2", bytes.NewBufferString(`{}`))
+		req.Header.Set("Content-Type", core.MergePatchType)
+
+		w := httptest.NewRecorder()
+
+		h.ServeHTTP(w, req)
+
+		Expect(w.Code).Should(Equal(http.StatusNotFound))
+	})
+})
@@ -0,0 +1,554 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type PluginOptions struct {
+	// Dir is where plugins are loaded from, one module per .wasm file, named after the file.
+	Dir string
+	// MaxDuration is how long a hook may run.
+	MaxDuration time.Duration
+	// MaxMemory is how many bytes of memory a plugin may have.
+	MaxMemory uint32
+}
+
+type PluginError struct {
+	Plugin string
+	Reason string
+}
+
+func (err PluginError) Error() string {
+	return fmt.Sprintf("plugin '%s' failed: %s", err.Plugin, err.Reason)
+}
+
+// PluginRequest is passed to the hooks of plugins as JSON. Old is set for replaces.
+type PluginRequest struct {
+	Hook      string      `json:"hook"`
+	GroupKind string      `json:"groupKind"`
+	Item      GenericItem `json:"item"`
+	Old       GenericItem `json:"old,omitempty"`
+	DryRun    bool        `json:"dryRun,omitempty"`
+	Actor     string      `json:"actor,omitempty"`
+}
+
+// PluginResponse is returned by the hooks of plugins as JSON. Before hooks may change the item, by returning
+// the item to write or a merge patch. Any hook may reject the operation or fail.
+type PluginResponse struct {
+	Item   GenericItem            `json:"item,omitempty"`
+	Patch  map[string]interface{} `json:"patch,omitempty"`
+	Reject *PluginRejection       `json:"reject,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+}
+
+type PluginRejection struct {
+	Reason     string `json:"reason"`
+	StatusCode int    `json:"statusCode,omitempty"`
+}
+
+// Plugins runs hooks exported by WebAssembly modules, so they can be written in any language compiling to it.
+//
+// A module exports its memory, alloc(size i32) i32 which returns where the host may write size bytes, and any of
+// the hooks beforeCreate, afterCreate, beforeReplace, afterReplace, beforeDelete and afterDelete. Hooks are called
+// as hook(ptr i32, len i32) i64 with a PluginRequest at ptr, and return where their PluginResponse is as
+// ptr << 32 | len, or 0 for no response. A module may also export kinds() i64, which returns a JSON list of
+// the kinds it applies to as "group/kind" the same way. Otherwise it applies to every kind outside of the core group.
+//
+// Every hook runs in a new instance of its module, which only has WASI without access to files, environment or
+// network, and is stopped when it runs out of time. Before hooks run ahead of the write, so they do not hold the
+// store while they run. After hooks and the write run in one transaction if the service supports them, so a failing
+// after hook undoes the write. That transaction holds the global write lock of the store, so after hooks stall
+// every other write for up to MaxDuration and should be kept short.
+// Operations keep the plugins they started with, and modules replaced by a reload are closed once they are done.
+type Plugins struct {
+	next    Service
+	opts    PluginOptions
+	runtime wazero.Runtime
+
+	mu      sync.RWMutex
+	plugins []*plugin
+}
+
+type plugin struct {
+	name    string
+	modTime time.Time
+	module  wazero.CompiledModule
+	kinds   []string
+
+	mu      sync.Mutex
+	refs    int
+	retired bool
+}
+
+func (p *plugin) acquire() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.refs++
+}
+
+func (p *plugin) release() {
+	p.mu.Lock()
+	p.refs--
+	closing := p.retired && p.refs == 0
+	p.mu.Unlock()
+
+	if closing {
+		_ = p.module.Close(context.Background())
+	}
+}
+
+// retire closes the module of p once no operation uses it anymore.
+func (p *plugin) retire() {
+	p.mu.Lock()
+	p.retired = true
+	closing := p.refs == 0
+	p.mu.Unlock()
+
+	if closing {
+		_ = p.module.Close(context.Background())
+	}
+}
+
+func (p *plugin) appliesTo(groupKind string) bool {
+	if p.kinds == nil {
+		group, _ := GetGroupAndKind(groupKind)
+
+		return group != "core"
+	}
+
+	for i := range p.kinds {
+		if p.kinds[i] == groupKind {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *plugin) exports(hook string) bool {
+	_, ok := p.module.ExportedFunctions()[hook]
+
+	return ok
+}
+
+func (ps *Plugins) List(ctx context.Context, groupKind string) ([]GenericItem, error) {
+	return ps.next.List(ctx, groupKind)
+}
+
+func (ps *Plugins) Create(ctx context.Context, groupKind string, req GenericItem) error {
+	plugins := ps.pluginsOf(groupKind)
+	defer releasePlugins(plugins)
+
+	if len(plugins) == 0 {
+		return ps.next.Create(ctx, groupKind, req)
+	}
+
+	err := ps.run(ctx, plugins, "beforeCreate", groupKind, req, nil)
+	if err != nil {
+		return err
+	}
+
+	return inTx(ctx, ps.next, func(ctx context.Context) error {
+		err := ps.next.Create(ctx, groupKind, req)
+		if err != nil {
+			return err
+		}
+
+		return ps.run(ctx, plugins, "afterCreate", groupKind, req.DeepCopy(), nil)
+	})
+}
+
+func (ps *Plugins) Read(ctx context.Context, groupKind string, id string) (GenericItem, error) {
+	return ps.next.Read(ctx, groupKind, id)
+}
+
+func (ps *Plugins) Replace(ctx context.Context, groupKind string, id string, req GenericItem) error {
+	plugins := ps.pluginsOf(groupKind)
+	defer releasePlugins(plugins)
+
+	if len(plugins) == 0 {
+		return ps.next.Replace(ctx, groupKind, id, req)
+	}
+
+	old, err := ps.next.Read(ctx, groupKind, id)
+	if err != nil {
+		return err
+	}
+
+	err = ps.run(ctx, plugins, "beforeReplace", groupKind, req, old)
+	if err != nil {
+		return err
+	}
+
+	return inTx(ctx, ps.next, func(ctx context.Context) error {
+		err := ps.next.Replace(ctx, groupKind, id, req)
+		if err != nil {
+			return err
+		}
+
+		return ps.run(ctx, plugins, "afterReplace", groupKind, req.DeepCopy(), old)
+	})
+}
+
+func (ps *Plugins) Delete(ctx context.Context, groupKind string, id string) error {
+	plugins := ps.pluginsOf(groupKind)
+	defer releasePlugins(plugins)
+
+	if len(plugins) == 0 {
+		return ps.next.Delete(ctx, groupKind, id)
+	}
+
+	item, err := ps.next.Read(ctx, groupKind, id)
+	if err != nil {
+		return err
+	}
+
+	// changes of before delete hooks are dropped
+	err = ps.run(ctx, plugins, "beforeDelete", groupKind, item.DeepCopy(), nil)
+	if err != nil {
+		return err
+	}
+
+	return inTx(ctx, ps.next, func(ctx context.Context) error {
+		err := ps.next.Delete(ctx, groupKind, id)
+		if err != nil {
+			return err
+		}
+
+		return ps.run(ctx, plugins, "afterDelete", groupKind, item, nil)
+	})
+}
+
+func (ps *Plugins) Unwrap() Service {
+	return ps.next
+}
+
+var _ Service = new(Plugins)
+
+// pluginsOf returns the plugins applying to groupKind, which are to be released once the operation is done.
+func (ps *Plugins) pluginsOf(groupKind string) []*plugin {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	res := make([]*plugin, 0, len(ps.plugins))
+
+	for i := range ps.plugins {
+		if ps.plugins[i].appliesTo(groupKind) {
+			ps.plugins[i].acquire()
+
+			res = append(res, ps.plugins[i])
+		}
+	}
+
+	return res
+}
+
+func releasePlugins(plugins []*plugin) {
+	for i := range plugins {
+		plugins[i].release()
+	}
+}
+
+// run calls hook of every plugin exporting it, in the order of their names. item takes the changes of the hooks.
+func (ps *Plugins) run(ctx context.Context, plugins []*plugin, hook string, groupKind string, item, old GenericItem) error {
+	for _, p := range plugins {
+		if !p.exports(hook) {
+			continue
+		}
+
+		req, err := json.Marshal(PluginRequest{
+			Hook:      hook,
+			GroupKind: groupKind,
+			Item:      item,
+			Old:       old,
+			DryRun:    IsDryRun(ctx),
+			Actor:     ActorFromContext(ctx),
+		})
+		if err != nil {
+			return err
+		}
+
+		data, err := ps.call(ctx, p, hook, req)
+		if err != nil {
+			return PluginError{Plugin: p.name, Reason: err.Error()}
+		}
+
+		if data == nil {
+			continue
+		}
+
+		var res PluginResponse
+
+		err = json.Unmarshal(data, &res)
+		if err != nil {
+			return PluginError{Plugin: p.name, Reason: "invalid response: " + err.Error()}
+		}
+
+		switch {
+		case res.Error != "":
+			return PluginError{Plugin: p.name, Reason: res.Error}
+		case res.Reject != nil:
+			return VetoError{StatusCode: res.Reject.StatusCode, Reason: res.Reject.Reason}
+		}
+
+		changed := item.DeepCopy()
+
+		if res.Item != nil {
+			changed = res.Item
+		}
+
+		if res.Patch != nil {
+			MergePatch(changed, map[string]interface{}(res.Patch))
+		}
+
+		if changed["id"] != item["id"] {
+			return PluginError{Plugin: p.name, Reason: "id can not be changed"}
+		}
+
+		for k := range item {
+			delete(item, k)
+		}
+
+		for k := range changed {
+			item[k] = changed[k]
+		}
+	}
+
+	return nil
+}
+
+// call runs fn of a new instance of the module of p with data as its argument, and returns its result.
+func (ps *Plugins) call(ctx context.Context, p *plugin, fn string, data []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, ps.opts.MaxDuration)
+	defer cancel()
+
+	mod, err := ps.runtime.InstantiateModule(ctx, p.module, wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize"))
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = mod.Close(context.Background()) }()
+
+	var args []uint64
+
+	if data != nil {
+		ptr, err := pluginCall(ctx, mod, "alloc", uint64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+
+		if !mod.Memory().Write(uint32(ptr), data) {
+			return nil, errors.New("alloc returned memory out of range")
+		}
+
+		args = []uint64{ptr, uint64(len(data))}
+	}
+
+	res, err := pluginCall(ctx, mod, fn, args...)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%s timed out", fn)
+		}
+
+		return nil, err
+	}
+
+	if res == 0 {
+		return nil, nil
+	}
+
+	out, ok := mod.Memory().Read(uint32(res>>32), uint32(res))
+	if !ok {
+		return nil, fmt.Errorf("%s returned memory out of range", fn)
+	}
+
+	// the memory goes away with the instance
+	return append([]byte(nil), out...), nil
+}
+
+func pluginCall(ctx context.Context, mod api.Module, name string, args ...uint64) (uint64, error) {
+	fn := mod.ExportedFunction(name)
+	if fn == nil {
+		return 0, fmt.Errorf("%s is not exported", name)
+	}
+
+	res, err := fn.Call(ctx, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(res) != 1 {
+		return 0, fmt.Errorf("%s must return one value", name)
+	}
+
+	return res[0], nil
+}
+
+func (ps *Plugins) compile(ctx context.Context, name string, path string, modTime time.Time) (*plugin, error) {
+	bin, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	module, err := ps.runtime.CompileModule(ctx, bin)
+	if err != nil {
+		return nil, PluginError{Plugin: name, Reason: err.Error()}
+	}
+
+	p := &plugin{name: name, modTime: modTime, module: module}
+
+	if _, ok := module.ExportedFunctions()["kinds"]; ok {
+		data, err := ps.call(ctx, p, "kinds", nil)
+		if err != nil {
+			_ = module.Close(ctx)
+
+			return nil, PluginError{Plugin: name, Reason: err.Error()}
+		}
+
+		p.kinds = []string{}
+
+		err = json.Unmarshal(data, &p.kinds)
+		if err != nil {
+			_ = module.Close(ctx)
+
+			return nil, PluginError{Plugin: name, Reason: "kinds must return a list of group/kind: " + err.Error()}
+		}
+	}
+
+	return p, nil
+}
+
+// Load loads the plugins of Dir which are new or changed since the last load, and unloads the removed ones.
+// A missing Dir has no plugins. A plugin which fails to load keeps its previous version, if any.
+func (ps *Plugins) Load(ctx context.Context) error {
+	entries, err := os.ReadDir(ps.opts.Dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	ps.mu.RLock()
+	loaded := make(map[string]*plugin, len(ps.plugins))
+
+	for i := range ps.plugins {
+		loaded[ps.plugins[i].name] = ps.plugins[i]
+	}
+	ps.mu.RUnlock()
+
+	plugins := make([]*plugin, 0, len(entries))
+
+	var errs []error
+
+	for i := range entries {
+		if entries[i].IsDir() || filepath.Ext(entries[i].Name()) != ".wasm" {
+			continue
+		}
+
+		name := strings.TrimSuffix(entries[i].Name(), ".wasm")
+
+		info, err := entries[i].Info()
+		if err == nil {
+			if p, ok := loaded[name]; ok && p.modTime.Equal(info.ModTime()) {
+				plugins = append(plugins, p)
+
+				continue
+			}
+
+			var p *plugin
+
+			p, err = ps.compile(ctx, name, filepath.Join(ps.opts.Dir, entries[i].Name()), info.ModTime())
+			if err == nil {
+				plugins = append(plugins, p)
+
+				continue
+			}
+		}
+
+		errs = append(errs, err)
+
+		if p, ok := loaded[name]; ok {
+			plugins = append(plugins, p)
+		}
+	}
+
+	sort.Slice(plugins, func(i, j int) bool { return plugins[i].name < plugins[j].name })
+
+	ps.mu.Lock()
+	old := ps.plugins
+	ps.plugins = plugins
+	ps.mu.Unlock()
+
+	for i := range old {
+		if !containsPlugin(plugins, old[i]) {
+			old[i].retire()
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func containsPlugin(plugins []*plugin, p *plugin) bool {
+	for i := range plugins {
+		if plugins[i] == p {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Run reloads the plugins of Dir every interval until ctx is done.
+func (ps *Plugins) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = ps.Load(ctx)
+		}
+	}
+}
+
+func (ps *Plugins) Close(ctx context.Context) error {
+	return ps.runtime.Close(ctx)
+}
+
+func NewPlugins(next Service, opts PluginOptions) *Plugins {
+	if opts.MaxDuration <= 0 {
+		opts.MaxDuration = time.Second
+	}
+
+	if opts.MaxMemory == 0 {
+		opts.MaxMemory = 16 << 20
+	}
+
+	ctx := context.Background()
+
+	pages := (opts.MaxMemory + 0xffff) >> 16
+
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(pages).
+		WithCloseOnContextDone(true))
+
+	wasi_snapshot_preview1.MustInstantiate(ctx, r)
+
+	return &Plugins{
+		next:    next,
+		opts:    opts,
+		runtime: r,
+	}
+}
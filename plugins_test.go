@@ -0,0 +1,291 @@
+package core_test
+
+import (
+	"context"
+	"errors"
+	"github.com/applicaset/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// wasmModule assembles plugin modules, with alloc as a bump allocator and the strings of str as data.
+type wasmModule struct {
+	data  []byte
+	funcs []wasmFunc
+}
+
+type wasmFunc struct {
+	name string
+	typ  byte
+	body []byte
+}
+
+const (
+	wasmTypeAlloc byte = iota
+	wasmTypeHook
+	wasmTypeKinds
+)
+
+const wasmDataOffset = 1024
+
+func uleb(v uint64) []byte {
+	var res []byte
+
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+
+		if v != 0 {
+			b |= 0x80
+		}
+
+		res = append(res, b)
+
+		if v == 0 {
+			return res
+		}
+	}
+}
+
+func sleb(v int64) []byte {
+	var res []byte
+
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+
+		if (v == 0 && b&0x40 == 0) || (v == -1 && b&0x40 != 0) {
+			return append(res, b)
+		}
+
+		res = append(res, b|0x80)
+	}
+}
+
+func wasmVec(items ...[]byte) []byte {
+	res := uleb(uint64(len(items)))
+
+	for i := range items {
+		res = append(res, items[i]...)
+	}
+
+	return res
+}
+
+func wasmName(s string) []byte {
+	return append(uleb(uint64(len(s))), s...)
+}
+
+func wasmSection(id byte, content []byte) []byte {
+	return append(append([]byte{id}, uleb(uint64(len(content)))...), content...)
+}
+
+// str adds s to the data of m and returns its location as hooks return it.
+func (m *wasmModule) str(s string) int64 {
+	offset := wasmDataOffset + len(m.data)
+	m.data = append(m.data, s...)
+
+	return int64(offset)<<32 | int64(len(s))
+}
+
+func (m *wasmModule) export(name string, typ byte, body ...byte) {
+	m.funcs = append(m.funcs, wasmFunc{name: name, typ: typ, body: body})
+}
+
+// returns exports a function returning v.
+func (m *wasmModule) returns(name string, typ byte, v int64) {
+	m.export(name, typ, append(append([]byte{0x42}, sleb(v)...), 0x0b)...)
+}
+
+func (m *wasmModule) bytes() []byte {
+	funcs := append([]wasmFunc{{
+		name: "alloc",
+		typ:  wasmTypeAlloc,
+		// global.get 0, global.get 0, local.get 0, i32.add, global.set 0
+		body: []byte{0x23, 0x00, 0x23, 0x00, 0x20, 0x00, 0x6a, 0x24, 0x00, 0x0b},
+	}}, m.funcs...)
+
+	types := wasmVec(
+		[]byte{0x60, 0x01, 0x7f, 0x01, 0x7f},
+		[]byte{0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7e},
+		[]byte{0x60, 0x00, 0x01, 0x7e},
+	)
+
+	var (
+		typeIndices [][]byte
+		exports     = [][]byte{append(wasmName("memory"), 0x02, 0x00)}
+		bodies      [][]byte
+	)
+
+	for i := range funcs {
+		typeIndices = append(typeIndices, []byte{funcs[i].typ})
+		exports = append(exports, append(append(wasmName(funcs[i].name), 0x00), uleb(uint64(i))...))
+
+		body := append([]byte{0x00}, funcs[i].body...)
+		bodies = append(bodies, append(uleb(uint64(len(body))), body...))
+	}
+
+	heap := append(append([]byte{0x7f, 0x01, 0x41}, sleb(8192)...), 0x0b)
+	data := append(append(append([]byte{0x00, 0x41}, sleb(wasmDataOffset)...), 0x0b), wasmName(string(m.data))...)
+
+	res := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	res = append(res, wasmSection(1, types)...)
+	res = append(res, wasmSection(3, wasmVec(typeIndices...))...)
+	res = append(res, wasmSection(5, wasmVec([]byte{0x00, 0x01}))...)
+	res = append(res, wasmSection(6, wasmVec(heap))...)
+	res = append(res, wasmSection(7, wasmVec(exports...))...)
+	res = append(res, wasmSection(10, wasmVec(bodies...))...)
+	res = append(res, wasmSection(11, wasmVec(data))...)
+
+	return res
+}
+
+func writePlugin(t *testing.T, dir string, name string, m *wasmModule, modTime time.Time) {
+	path := filepath.Join(dir, name+".wasm")
+
+	require.NoError(t, os.WriteFile(path, m.bytes(), 0o644))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestPluginsRunHooks(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	m := new(wasmModule)
+	m.returns("kinds", wasmTypeKinds, m.str(`["acme/orders"]`))
+	m.returns("beforeCreate", wasmTypeHook, m.str(`{"patch":{"status":"approved"}}`))
+	// loop forever
+	m.export("beforeReplace", wasmTypeHook, 0x03, 0x40, 0x0c, 0x00, 0x0b, 0x00, 0x0b)
+	// trap if memory.grow(1000) fails
+	m.export("beforeDelete", wasmTypeHook, append(append([]byte{0x41}, sleb(1000)...),
+		0x40, 0x00, 0x41, 0x7f, 0x46, 0x04, 0x40, 0x00, 0x0b, 0x42, 0x00, 0x0b)...)
+
+	writePlugin(t, dir, "approve", m, time.Now())
+
+	plugins := core.NewPlugins(core.NewStore(), core.PluginOptions{
+		Dir:         dir,
+		MaxDuration: 100 * time.Millisecond,
+		MaxMemory:   1 << 20,
+	})
+
+	defer func() { _ = plugins.Close(ctx) }()
+
+	require.NoError(t, plugins.Load(ctx))
+
+	order := core.GenericItem{"id": "o1", "total": 10}
+	require.NoError(t, plugins.Create(ctx, "acme/orders", order))
+	assert.Equal(t, "approved", order["status"])
+
+	item, err := plugins.Read(ctx, "acme/orders", "o1")
+	require.NoError(t, err)
+	assert.Equal(t, "approved", item["status"])
+
+	invoice := core.GenericItem{"id": "i1"}
+	require.NoError(t, plugins.Create(ctx, "acme/invoices", invoice))
+	assert.NotContains(t, invoice, "status")
+
+	err = plugins.Replace(ctx, "acme/orders", "o1", core.GenericItem{"id": "o1", "total": 20})
+	require.ErrorAs(t, err, &core.PluginError{})
+	assert.Contains(t, err.Error(), "timed out")
+
+	err = plugins.Delete(ctx, "acme/orders", "o1")
+	require.ErrorAs(t, err, &core.PluginError{})
+
+	item, err = plugins.Read(ctx, "acme/orders", "o1")
+	require.NoError(t, err)
+	assert.EqualValues(t, 10, item["total"])
+}
+
+func TestPluginsReload(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	now := time.Now()
+
+	reject := new(wasmModule)
+	reject.returns("beforeCreate", wasmTypeHook, reject.str(`{"reject":{"reason":"closed","statusCode":403}}`))
+
+	writePlugin(t, dir, "guard", reject, now)
+
+	plugins := core.NewPlugins(core.NewStore(), core.PluginOptions{Dir: dir})
+
+	defer func() { _ = plugins.Close(ctx) }()
+
+	require.NoError(t, plugins.Load(ctx))
+
+	err := plugins.Create(ctx, "acme/orders", core.GenericItem{"id": "o1"})
+
+	var veto core.VetoError
+
+	require.True(t, errors.As(err, &veto))
+	assert.Equal(t, 403, veto.StatusCode)
+	assert.Equal(t, "closed", veto.Reason)
+
+	// plugins without kinds leave the core group alone
+	require.NoError(t, plugins.Create(ctx, "core/settings", core.GenericItem{"id": "s1"}))
+
+	allow := new(wasmModule)
+	allow.returns("beforeCreate", wasmTypeHook, 0)
+
+	writePlugin(t, dir, "guard", allow, now.Add(time.Second))
+	require.NoError(t, plugins.Load(ctx))
+
+	require.NoError(t, plugins.Create(ctx, "acme/orders", core.GenericItem{"id": "o1"}))
+
+	// a broken build keeps the version loaded before
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "guard.wasm"), []byte("not wasm"), 0o644))
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "guard.wasm"), now.Add(2*time.Second), now.Add(2*time.Second)))
+	require.Error(t, plugins.Load(ctx))
+
+	require.NoError(t, plugins.Create(ctx, "acme/orders", core.GenericItem{"id": "o2"}))
+
+	writePlugin(t, dir, "guard", reject, now.Add(3*time.Second))
+	require.NoError(t, plugins.Load(ctx))
+	require.Error(t, plugins.Create(ctx, "acme/orders", core.GenericItem{"id": "o3"}))
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "guard.wasm")))
+	require.NoError(t, plugins.Load(ctx))
+	require.NoError(t, plugins.Create(ctx, "acme/orders", core.GenericItem{"id": "o3"}))
+}
+
+func TestPluginsReloadWhileRunning(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	now := time.Now()
+
+	slow := new(wasmModule)
+	// loop forever
+	slow.export("beforeCreate", wasmTypeHook, 0x03, 0x40, 0x0c, 0x00, 0x0b, 0x00, 0x0b)
+
+	writePlugin(t, dir, "slow", slow, now)
+
+	plugins := core.NewPlugins(core.NewStore(), core.PluginOptions{Dir: dir, MaxDuration: 200 * time.Millisecond})
+
+	defer func() { _ = plugins.Close(ctx) }()
+
+	require.NoError(t, plugins.Load(ctx))
+
+	done := make(chan error)
+
+	go func() {
+		done <- plugins.Create(ctx, "acme/orders", core.GenericItem{"id": "o1"})
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	allow := new(wasmModule)
+	allow.returns("beforeCreate", wasmTypeHook, 0)
+
+	writePlugin(t, dir, "slow", allow, now.Add(time.Second))
+	require.NoError(t, plugins.Load(ctx))
+
+	require.NoError(t, plugins.Create(ctx, "acme/orders", core.GenericItem{"id": "o2"}))
+
+	// the running hook keeps its module until it times out
+	err := <-done
+	require.ErrorAs(t, err, &core.PluginError{})
+	assert.Contains(t, err.Error(), "timed out")
+}
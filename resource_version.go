@@ -0,0 +1,117 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+func ResourceVersionOf(item GenericItem) string {
+	switch v := item["resourceVersion"].(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return ""
+	}
+}
+
+// Preconditions are checked by the terminal Service atomically with the write they guard.
+// A mismatch of IfMatch results in PreconditionFailedError and a mismatch of ResourceVersion in ConflictError.
+type Preconditions struct {
+	IfMatch         []string
+	ResourceVersion string
+}
+
+func (p Preconditions) Check(id string, current GenericItem) error {
+	rv := ResourceVersionOf(current)
+
+	if p.IfMatch != nil {
+		matched := false
+		for i := range p.IfMatch {
+			if p.IfMatch[i] == "*" || p.IfMatch[i] == rv {
+				matched = true
+
+				break
+			}
+		}
+
+		if !matched {
+			return PreconditionFailedError{ID: id}
+		}
+	}
+
+	if p.ResourceVersion != "" && p.ResourceVersion != rv {
+		return ConflictError{ID: id}
+	}
+
+	return nil
+}
+
+type preconditionsKey struct{}
+
+func WithPreconditions(ctx context.Context, p Preconditions) context.Context {
+	return context.WithValue(ctx, preconditionsKey{}, p)
+}
+
+func PreconditionsFromContext(ctx context.Context) Preconditions {
+	p, _ := ctx.Value(preconditionsKey{}).(Preconditions)
+
+	return p
+}
+
+// withResourceVersion guards the next write of ctx by rv, unless the caller has already set one.
+func withResourceVersion(ctx context.Context, rv string) context.Context {
+	p := PreconditionsFromContext(ctx)
+	if p.ResourceVersion != "" {
+		return ctx
+	}
+
+	p.ResourceVersion = rv
+
+	return WithPreconditions(ctx, p)
+}
+
+const maxConflictRetries = 10
+
+// retryOnConflict calls fn again while it fails with ConflictError, as long as retry is set.
+func retryOnConflict(retry bool, fn func() error) error {
+	var err error
+
+	for i := 0; i < maxConflictRetries; i++ {
+		err = fn()
+		if !retry || !errors.As(err, &ConflictError{}) {
+			return err
+		}
+	}
+
+	return err
+}
+
+func ETag(rv string) string {
+	return `"` + rv + `"`
+}
+
+// ParseETags parses the entity tags of an If-Match or If-None-Match header.
+// It returns nil if header is empty.
+func ParseETags(header string) []string {
+	if strings.TrimSpace(header) == "" {
+		return nil
+	}
+
+	parts := strings.Split(header, ",")
+
+	res := make([]string, 0, len(parts))
+
+	for i := range parts {
+		tag := strings.TrimSpace(parts[i])
+		tag = strings.TrimPrefix(tag, "W/")
+		tag = strings.Trim(tag, `"`)
+
+		res = append(res, tag)
+	}
+
+	return res
+}
@@ -0,0 +1,121 @@
+package core_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/applicaset/core"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+)
+
+var _ = Describe("Optimistic concurrency", Ordered, func() {
+	var svc core.Service
+	svc = core.NewStore()
+	svc = core.NewAutoFields(svc)
+
+	h := core.NewHandler(svc)
+
+	var rv string
+
+	BeforeAll(func() {
+		res := doRequest(h, http.MethodPost, "/acme/foo", `{"id":"foo1","bar":"baz"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		rsp := decodeBody(res)
+		Expect(rsp).Should(HaveKey("resourceVersion"))
+
+		rv = rsp["resourceVersion"].(string)
+		Expect(res.Header.Get("ETag")).Should(Equal(core.ETag(rv)))
+	})
+
+	It("should return etag on read", func() {
+		res := doRequest(h, http.MethodGet, "/acme/foo/foo1", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+		Expect(res.Header.Get("ETag")).Should(Equal(core.ETag(rv)))
+	})
+
+	It("should fail on replace with stale resource version", func() {
+		res := doRequest(h, http.MethodPut, "/acme/foo/foo1", `{"id":"foo1","bar":"baz2","resourceVersion":"0"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusConflict))
+
+		rsp := decodeBody(res)
+		Expect(rsp).Should(HaveKeyWithValue("message", "Item has been modified"))
+	})
+
+	It("should fail on replace with stale if-match", func() {
+		req := httptest.NewRequest(http.MethodPut, "/acme/foo/foo1", bytes.NewBufferString(`{"id":"foo1","bar":"baz2"}`))
+		req.Header.Set("If-Match", `"0"`)
+
+		w := httptest.NewRecorder()
+
+		h.ServeHTTP(w, req)
+
+		Expect(w.Code).Should(Equal(http.StatusPreconditionFailed))
+	})
+
+	It("should replace with matching resource version", func() {
+		res := doRequest(h, http.MethodPut, "/acme/foo/foo1", fmt.Sprintf(`{"id":"foo1","bar":"baz2","resourceVersion":"%s"}`, rv))
+		Expect(res.StatusCode).Should(Equal(http.StatusNoContent))
+
+		Expect(res.Header.Get("ETag")).ShouldNot(BeEmpty())
+		Expect(res.Header.Get("ETag")).ShouldNot(Equal(core.ETag(rv)))
+
+		rsp := decodeBody(doRequest(h, http.MethodGet, "/acme/foo/foo1", ""))
+		Expect(core.ETag(rsp["resourceVersion"].(string))).Should(Equal(res.Header.Get("ETag")))
+
+		rv = rsp["resourceVersion"].(string)
+	})
+
+	It("should give up updates which keep conflicting", func() {
+		ctx := context.Background()
+
+		Expect(svc.Create(ctx, "acme/foo", core.GenericItem{"id": "foo2"})).Should(Succeed())
+
+		attempts := 0
+
+		_, err := core.Update(ctx, svc, "acme/foo", "foo2", func(item core.GenericItem) (core.GenericItem, error) {
+			attempts++
+
+			// another write gets in between every time
+			err := svc.Replace(ctx, "acme/foo", "foo2", core.GenericItem{"id": "foo2", "attempts": attempts})
+			if err != nil {
+				return nil, err
+			}
+
+			return item, nil
+		})
+		Expect(err).Should(MatchError(core.ConflictError{ID: "foo2"}))
+		Expect(attempts).Should(BeNumerically(">", 1))
+		Expect(attempts).Should(BeNumerically("<=", 10))
+	})
+
+	It("should fail on delete with stale if-match", func() {
+		req := httptest.NewRequest(http.MethodDelete, "/acme/foo/foo1", nil)
+		req.Header.Set("If-Match", `"0", "1"`)
+
+		w := httptest.NewRecorder()
+
+		h.ServeHTTP(w, req)
+
+		Expect(w.Code).Should(Equal(http.StatusPreconditionFailed))
+	})
+
+	It("should fail on delete with stale resource version", func() {
+		res := doRequest(h, http.MethodDelete, "/acme/foo/foo1?resourceVersion=1", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusConflict))
+	})
+
+	It("should delete with matching if-match", func() {
+		req := httptest.NewRequest(http.MethodDelete, "/acme/foo/foo1", nil)
+		req.Header.Set("If-Match", core.ETag(rv))
+
+		w := httptest.NewRecorder()
+
+		h.ServeHTTP(w, req)
+
+		Expect(w.Code).Should(Equal(http.StatusNoContent))
+	})
+})
@@ -0,0 +1,542 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dop251/goja"
+	"runtime/metrics"
+	"sort"
+	"sync"
+	"time"
+)
+
+const ScriptsGroupKind = "core/scripts"
+
+type ScriptOptions struct {
+	// MaxDuration is how long a hook may run, including the service calls it makes.
+	MaxDuration time.Duration
+	// MaxMemory is how many bytes may be allocated while a hook runs. It is a best-effort bound: allocations can only
+	// be counted for the whole process, so concurrent work counts against it too, and they are sampled every 5ms.
+	MaxMemory uint64
+	// MaxCallStackSize bounds the recursion of scripts.
+	MaxCallStackSize int
+	MaxSourceSize    int
+}
+
+var sandboxedGlobals = []string{
+	"ArrayBuffer", "DataView", "Int8Array", "Uint8Array", "Uint8ClampedArray", "Int16Array", "Uint16Array",
+	"Int32Array", "Uint32Array", "Float32Array", "Float64Array",
+}
+
+type ScriptError struct {
+	Script string
+	Reason string
+}
+
+func (err ScriptError) Error() string {
+	return fmt.Sprintf("script '%s' failed: %s", err.Script, err.Reason)
+}
+
+// Scripts runs JavaScript hooks for the kinds of the items of ScriptsGroupKind. A script has a source, the kinds it
+// applies to as "group/kind", and may be disabled. Its source defines any of the functions
+//
+//	beforeCreate(item), afterCreate(item),
+//	beforeReplace(item, old), afterReplace(item, old),
+//	beforeDelete(item), afterDelete(item)
+//
+// Before hooks may change item, or return the item to write instead. Scripts refuse an operation by calling
+// reject(reason, statusCode), and reach other items through service.list, read, create, replace and delete.
+// Before hooks run ahead of the write, so they do not hold the store while they run. After hooks, their service
+// calls and the write run in one transaction if the service supports them, so a failing after hook undoes the write.
+// That transaction holds the global write lock of the store, so after hooks stall every other write for up to
+// MaxDuration and should be kept short.
+//
+// Every hook runs in a new runtime which only has the standard built-ins but binary buffers, reject and service.
+type Scripts struct {
+	next Service
+	opts ScriptOptions
+
+	mu       sync.Mutex
+	programs map[string]compiledScript
+}
+
+type compiledScript struct {
+	resourceVersion string
+	program         *goja.Program
+}
+
+func (s *Scripts) List(ctx context.Context, groupKind string) ([]GenericItem, error) {
+	return s.next.List(ctx, groupKind)
+}
+
+func (s *Scripts) Create(ctx context.Context, groupKind string, req GenericItem) error {
+	if groupKind == ScriptsGroupKind {
+		err := s.validate(req)
+		if err != nil {
+			return err
+		}
+
+		return s.next.Create(ctx, groupKind, req)
+	}
+
+	scripts, err := s.scriptsOf(ctx, groupKind)
+	if err != nil {
+		return err
+	}
+
+	err = s.run(ctx, scripts, "beforeCreate", req, nil)
+	if err != nil {
+		return err
+	}
+
+	return inTx(ctx, s.next, func(ctx context.Context) error {
+		err := s.next.Create(ctx, groupKind, req)
+		if err != nil {
+			return err
+		}
+
+		return s.run(ctx, scripts, "afterCreate", req.DeepCopy(), nil)
+	})
+}
+
+func (s *Scripts) Read(ctx context.Context, groupKind string, id string) (GenericItem, error) {
+	return s.next.Read(ctx, groupKind, id)
+}
+
+func (s *Scripts) Replace(ctx context.Context, groupKind string, id string, req GenericItem) error {
+	if groupKind == ScriptsGroupKind {
+		err := s.validate(req)
+		if err != nil {
+			return err
+		}
+
+		return s.next.Replace(ctx, groupKind, id, req)
+	}
+
+	scripts, err := s.scriptsOf(ctx, groupKind)
+	if err != nil {
+		return err
+	}
+
+	if len(scripts) == 0 {
+		return s.next.Replace(ctx, groupKind, id, req)
+	}
+
+	old, err := s.next.Read(ctx, groupKind, id)
+	if err != nil {
+		return err
+	}
+
+	err = s.run(ctx, scripts, "beforeReplace", req, old)
+	if err != nil {
+		return err
+	}
+
+	return inTx(ctx, s.next, func(ctx context.Context) error {
+		err := s.next.Replace(ctx, groupKind, id, req)
+		if err != nil {
+			return err
+		}
+
+		return s.run(ctx, scripts, "afterReplace", req.DeepCopy(), old)
+	})
+}
+
+func (s *Scripts) Delete(ctx context.Context, groupKind string, id string) error {
+	if groupKind == ScriptsGroupKind {
+		return s.next.Delete(ctx, groupKind, id)
+	}
+
+	scripts, err := s.scriptsOf(ctx, groupKind)
+	if err != nil {
+		return err
+	}
+
+	if len(scripts) == 0 {
+		return s.next.Delete(ctx, groupKind, id)
+	}
+
+	item, err := s.next.Read(ctx, groupKind, id)
+	if err != nil {
+		return err
+	}
+
+	// changes of before delete hooks are dropped
+	err = s.run(ctx, scripts, "beforeDelete", item.DeepCopy(), nil)
+	if err != nil {
+		return err
+	}
+
+	return inTx(ctx, s.next, func(ctx context.Context) error {
+		err := s.next.Delete(ctx, groupKind, id)
+		if err != nil {
+			return err
+		}
+
+		return s.run(ctx, scripts, "afterDelete", item, nil)
+	})
+}
+
+func (s *Scripts) Unwrap() Service {
+	return s.next
+}
+
+var _ Service = new(Scripts)
+
+func (s *Scripts) validate(script GenericItem) error {
+	source, ok := script["source"].(string)
+	if !ok {
+		return FieldError{Field: "source", Reason: "must be a string"}
+	}
+
+	if len(source) > s.opts.MaxSourceSize {
+		return FieldError{Field: "source", Reason: fmt.Sprintf("must not be longer than %d bytes", s.opts.MaxSourceSize)}
+	}
+
+	if _, ok := script["kinds"].([]interface{}); !ok {
+		return FieldError{Field: "kinds", Reason: "must be a list of group/kind"}
+	}
+
+	_, err := goja.Compile(script.GetID(), source, true)
+	if err != nil {
+		return FieldError{Field: "source", Reason: err.Error()}
+	}
+
+	return nil
+}
+
+// scriptsOf returns the compiled scripts of groupKind, by id.
+func (s *Scripts) scriptsOf(ctx context.Context, groupKind string) (map[string]*goja.Program, error) {
+	scripts, err := s.next.List(ctx, ScriptsGroupKind)
+	if err != nil {
+		if errors.As(err, &GroupKindNotFoundError{}) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	res := make(map[string]*goja.Program)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range scripts {
+		kinds, _ := scripts[i]["kinds"].([]interface{})
+		if disabled, _ := scripts[i]["disabled"].(bool); disabled || !containsValue(kinds, groupKind) {
+			continue
+		}
+
+		id, rv := scripts[i].GetID(), ResourceVersionOf(scripts[i])
+
+		if c, ok := s.programs[id]; ok && c.resourceVersion == rv {
+			res[id] = c.program
+
+			continue
+		}
+
+		source, _ := scripts[i]["source"].(string)
+
+		program, err := goja.Compile(id, source, true)
+		if err != nil {
+			return nil, ScriptError{Script: id, Reason: err.Error()}
+		}
+
+		s.programs[id] = compiledScript{resourceVersion: rv, program: program}
+		res[id] = program
+	}
+
+	return res, nil
+}
+
+// run calls hook of every script defining it, in the order of their ids. item takes the changes of the hooks.
+func (s *Scripts) run(ctx context.Context, scripts map[string]*goja.Program, hook string, item, old GenericItem) error {
+	ids := make([]string, 0, len(scripts))
+	for id := range scripts {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		res, err := s.call(ctx, id, scripts[id], hook, item, old)
+		if err != nil {
+			return err
+		}
+
+		if res == nil {
+			continue
+		}
+
+		if res["id"] != item["id"] {
+			return ScriptError{Script: id, Reason: "id can not be changed"}
+		}
+
+		for k := range item {
+			delete(item, k)
+		}
+
+		for k := range res {
+			item[k] = res[k]
+		}
+	}
+
+	return nil
+}
+
+// call runs hook of a script. It returns the item as the hook left it, or nil if the script does not define hook.
+func (s *Scripts) call(ctx context.Context, id string, program *goja.Program, hook string, item, old GenericItem) (res GenericItem, err error) {
+	vm := goja.New()
+	vm.SetMaxCallStackSize(s.opts.MaxCallStackSize)
+
+	// scripts work on JSON items, and binary buffers would allocate too fast to be stopped in time
+	for _, name := range sandboxedGlobals {
+		_ = vm.GlobalObject().Delete(name)
+	}
+
+	var veto *VetoError
+
+	_ = vm.Set("reject", func(reason string, statusCode int) {
+		veto = &VetoError{StatusCode: statusCode, Reason: reason}
+
+		panic(vm.NewTypeError("operation rejected: %s", reason))
+	})
+
+	_ = vm.Set("service", s.serviceObject(ctx, vm))
+
+	stop := s.watch(vm)
+	defer stop()
+
+	defer func() {
+		if veto != nil {
+			res, err = nil, *veto
+		}
+	}()
+
+	_, err = vm.RunProgram(program)
+	if err != nil {
+		return nil, s.scriptError(id, err)
+	}
+
+	fn, ok := goja.AssertFunction(vm.Get(hook))
+	if !ok {
+		return nil, nil
+	}
+
+	args := make([]goja.Value, 0, 2)
+
+	for _, v := range []GenericItem{item, old} {
+		if v == nil {
+			break
+		}
+
+		arg, err := toJSValue(vm, v)
+		if err != nil {
+			return nil, ScriptError{Script: id, Reason: err.Error()}
+		}
+
+		args = append(args, arg)
+	}
+
+	ret, err := fn(goja.Undefined(), args...)
+	if err != nil {
+		return nil, s.scriptError(id, err)
+	}
+
+	if goja.IsUndefined(ret) || goja.IsNull(ret) {
+		ret = args[0]
+	}
+
+	m, ok := normalizeJSONValue(ret.Export()).(map[string]interface{})
+	if !ok {
+		return nil, ScriptError{Script: id, Reason: hook + " must return an object or nothing"}
+	}
+
+	return m, nil
+}
+
+func (s *Scripts) scriptError(id string, err error) error {
+	var interrupted *goja.InterruptedError
+
+	if errors.As(err, &interrupted) {
+		return ScriptError{Script: id, Reason: fmt.Sprint(interrupted.Value())}
+	}
+
+	return ScriptError{Script: id, Reason: err.Error()}
+}
+
+// watch interrupts vm when it runs out of time or memory.
+func (s *Scripts) watch(vm *goja.Runtime) (stop func()) {
+	done := make(chan struct{})
+
+	sample := []metrics.Sample{{Name: "/gc/heap/allocs:bytes"}}
+	metrics.Read(sample)
+	start := sample[0].Value.Uint64()
+
+	go func() {
+		timeout := time.NewTimer(s.opts.MaxDuration)
+		defer timeout.Stop()
+
+		ticker := time.NewTicker(5 * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-timeout.C:
+				vm.Interrupt("script timed out")
+
+				return
+			case <-ticker.C:
+				metrics.Read(sample)
+
+				if sample[0].Value.Uint64()-start > s.opts.MaxMemory {
+					vm.Interrupt("script used too much memory")
+
+					return
+				}
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// serviceObject exposes the service below Scripts to scripts, but for the internal kinds, which are hidden from
+// them like from clients. Its errors are thrown as exceptions.
+func (s *Scripts) serviceObject(ctx context.Context, vm *goja.Runtime) map[string]interface{} {
+	check := func(err error) {
+		if err != nil {
+			panic(vm.NewGoError(err))
+		}
+	}
+
+	hidden := func(groupKind string) error {
+		if !internalGroupKinds[groupKind] {
+			return nil
+		}
+
+		group, kind := GetGroupAndKind(groupKind)
+
+		return GroupKindNotFoundError{Group: group, Kind: kind}
+	}
+
+	item := func(v goja.Value) GenericItem {
+		m, ok := normalizeJSONValue(v.Export()).(map[string]interface{})
+		if !ok {
+			panic(vm.NewTypeError("item must be an object"))
+		}
+
+		return m
+	}
+
+	value := func(item GenericItem) goja.Value {
+		v, err := toJSValue(vm, item)
+		check(err)
+
+		return v
+	}
+
+	return map[string]interface{}{
+		"list": func(groupKind string) goja.Value {
+			err := hidden(groupKind)
+
+			var items []GenericItem
+			if err == nil {
+				items, err = s.next.List(ctx, groupKind)
+			}
+
+			if errors.As(err, &GroupKindNotFoundError{}) {
+				items, err = []GenericItem{}, nil
+			}
+
+			check(err)
+
+			res := make([]interface{}, len(items))
+			for i := range items {
+				res[i] = map[string]interface{}(items[i])
+			}
+
+			v, err := toJSValue(vm, res)
+			check(err)
+
+			return v
+		},
+		"read": func(groupKind string, id string) goja.Value {
+			err := hidden(groupKind)
+
+			var res GenericItem
+			if err == nil {
+				res, err = s.next.Read(ctx, groupKind, id)
+			}
+
+			if errors.As(err, &ItemNotFoundError{}) || errors.As(err, &GroupKindNotFoundError{}) {
+				return goja.Null()
+			}
+
+			check(err)
+
+			return value(res)
+		},
+		"create": func(groupKind string, v goja.Value) goja.Value {
+			check(hidden(groupKind))
+
+			req := item(v)
+
+			check(s.next.Create(ctx, groupKind, req))
+
+			return value(req)
+		},
+		"replace": func(groupKind string, id string, v goja.Value) goja.Value {
+			check(hidden(groupKind))
+
+			req := item(v)
+
+			check(s.next.Replace(ctx, groupKind, id, req))
+
+			return value(req)
+		},
+		"delete": func(groupKind string, id string) {
+			check(hidden(groupKind))
+			check(s.next.Delete(ctx, groupKind, id))
+		},
+	}
+}
+
+// toJSValue turns v into plain JavaScript values, so scripts can change them freely.
+func toJSValue(vm *goja.Runtime, v interface{}) (goja.Value, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	parse, _ := goja.AssertFunction(vm.Get("JSON").ToObject(vm).Get("parse"))
+
+	return parse(goja.Undefined(), vm.ToValue(string(data)))
+}
+
+func NewScripts(next Service, opts ScriptOptions) *Scripts {
+	if opts.MaxDuration <= 0 {
+		opts.MaxDuration = time.Second
+	}
+
+	if opts.MaxMemory == 0 {
+		opts.MaxMemory = 64 << 20
+	}
+
+	if opts.MaxCallStackSize <= 0 {
+		opts.MaxCallStackSize = 1024
+	}
+
+	if opts.MaxSourceSize <= 0 {
+		opts.MaxSourceSize = 256 << 10
+	}
+
+	return &Scripts{
+		next:     next,
+		opts:     opts,
+		programs: make(map[string]compiledScript),
+	}
+}
@@ -0,0 +1,168 @@
+package core_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/applicaset/core"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"time"
+)
+
+var _ = Describe("Scripts", func() {
+	var (
+		svc core.Service
+		h   *core.Handler
+	)
+
+	addScript := func(id string, kinds string, source string) *http.Response {
+		body, err := json.Marshal(map[string]interface{}{"id": id, "kinds": json.RawMessage(kinds), "source": source})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		return doRequest(h, http.MethodPost, "/core/scripts", string(body))
+	}
+
+	BeforeEach(func() {
+		svc = core.NewStore()
+		svc = core.NewAutoFields(svc)
+		svc = core.NewScripts(svc, core.ScriptOptions{MaxDuration: 100 * time.Millisecond, MaxMemory: 4 << 20})
+
+		h = core.NewHandler(svc)
+	})
+
+	It("should run hooks which validate and compute fields", func() {
+		res := addScript("orders", `["acme/orders"]`, `
+			function beforeCreate(item) {
+				if (!(item.quantity > 0)) {
+					reject("quantity must be positive", 422);
+				}
+
+				const product = service.read("acme/products", item.product);
+				if (product === null) {
+					reject("product does not exist");
+				}
+
+				item.total = item.quantity * product.price;
+			}
+
+			function beforeReplace(item, old) {
+				item.total = item.quantity * old.total / old.quantity;
+			}
+
+			function afterCreate(item) {
+				service.create("acme/audit", {id: "created-" + item.id, total: item.total});
+			}
+
+			function afterDelete(item) {
+				service.delete("acme/audit", "created-" + item.id);
+			}
+		`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		Expect(doRequest(h, http.MethodPost, "/acme/products", `{"id":"p1","price":2.5}`).StatusCode).Should(Equal(http.StatusCreated))
+
+		res = doRequest(h, http.MethodPost, "/acme/orders", `{"id":"o1","product":"p1","quantity":0}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+		Expect(decodeBody(res)["error"]).Should(ContainSubstring("quantity must be positive"))
+
+		res = doRequest(h, http.MethodPost, "/acme/orders", `{"id":"o1","product":"p2","quantity":1}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusBadRequest))
+
+		res = doRequest(h, http.MethodPost, "/acme/orders", `{"id":"o1","product":"p1","quantity":4}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+		Expect(decodeBody(res)["total"]).Should(BeEquivalentTo(10))
+
+		body := decodeBody(doRequest(h, http.MethodGet, "/acme/audit/created-o1", ""))
+		Expect(body["total"]).Should(BeEquivalentTo(10))
+
+		res = doRequest(h, http.MethodPut, "/acme/orders/o1", `{"id":"o1","product":"p1","quantity":2}`)
+		Expect(res.StatusCode).Should(BeNumerically("<", 300))
+
+		body = decodeBody(doRequest(h, http.MethodGet, "/acme/orders/o1", ""))
+		Expect(body["total"]).Should(BeEquivalentTo(5))
+
+		Expect(doRequest(h, http.MethodDelete, "/acme/orders/o1", "").StatusCode).Should(BeNumerically("<", 300))
+		Expect(doRequest(h, http.MethodGet, "/acme/audit/created-o1", "").StatusCode).Should(Equal(http.StatusNotFound))
+	})
+
+	It("should undo the write of a failing hook", func() {
+		res := addScript("audit", `["acme/orders"]`, `
+			function afterCreate(item) {
+				service.create("acme/audit", {id: item.id});
+				throw new Error("boom");
+			}
+		`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		res = doRequest(h, http.MethodPost, "/acme/orders", `{"id":"o1"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusInternalServerError))
+		Expect(decodeBody(res)["message"]).Should(Equal("Script failed"))
+
+		Expect(doRequest(h, http.MethodGet, "/acme/orders/o1", "").StatusCode).Should(Equal(http.StatusNotFound))
+		Expect(doRequest(h, http.MethodGet, "/acme/audit/o1", "").StatusCode).Should(Equal(http.StatusNotFound))
+	})
+
+	It("should stop scripts which run too long or allocate too much", func() {
+		Expect(addScript("loop", `["acme/loops"]`, `function beforeCreate(item) { for (;;) {} }`).StatusCode).Should(Equal(http.StatusCreated))
+		Expect(addScript("hog", `["acme/hogs"]`, `
+			function beforeCreate(item) {
+				const a = [];
+				for (;;) { a.push(new Array(1 << 16).fill(0)); }
+			}
+		`).StatusCode).Should(Equal(http.StatusCreated))
+		Expect(addScript("deep", `["acme/deep"]`, `function f(n) { return f(n + 1); } function beforeCreate(item) { f(0); }`).StatusCode).Should(Equal(http.StatusCreated))
+
+		res := doRequest(h, http.MethodPost, "/acme/loops", `{"id":"l1"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusInternalServerError))
+		Expect(decodeBody(res)["error"]).Should(ContainSubstring("timed out"))
+
+		res = doRequest(h, http.MethodPost, "/acme/hogs", `{"id":"h1"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusInternalServerError))
+		Expect(decodeBody(res)["error"]).Should(ContainSubstring("too much memory"))
+
+		res = doRequest(h, http.MethodPost, "/acme/deep", `{"id":"d1"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusInternalServerError))
+
+		Expect(addScript("buffers", `["acme/buffers"]`, `function beforeCreate(item) { new ArrayBuffer(1 << 30); }`).StatusCode).Should(Equal(http.StatusCreated))
+
+		res = doRequest(h, http.MethodPost, "/acme/buffers", `{"id":"b1"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusInternalServerError))
+		Expect(decodeBody(res)["error"]).Should(ContainSubstring("ArrayBuffer is not defined"))
+	})
+
+	It("should hide internal kinds from scripts", func() {
+		err := svc.Create(context.Background(), core.WebhookSecretsGroupKind, core.GenericItem{"id": "core.webhooks.hook1", "secret": "s3cr3t"})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		res := addScript("snoop", `["acme/orders"]`, `
+			function beforeCreate(item) {
+				item.secrets = service.list("core/webhooksecrets");
+				item.secret = service.read("core/webhooksecrets", "core.webhooks.hook1");
+
+				try {
+					service.create("core/sequences", {id: "acme.orders", value: 0});
+				} catch (e) {
+					item.error = String(e);
+				}
+			}
+		`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		res = doRequest(h, http.MethodPost, "/acme/orders", `{"id":"o1"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		body := decodeBody(res)
+		Expect(body["secrets"]).Should(BeEmpty())
+		Expect(body["secret"]).Should(BeNil())
+		Expect(body["error"]).Should(ContainSubstring("not found"))
+	})
+
+	It("should reject invalid scripts", func() {
+		res := addScript("bad", `["acme/orders"]`, `function beforeCreate(item) {`)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+
+		res = addScript("bad", `"acme/orders"`, `function beforeCreate(item) {}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+	})
+})
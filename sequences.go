@@ -0,0 +1,176 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+)
+
+const SequencesGroupKind = "core/sequences"
+
+type SequenceMode int
+
+const (
+	// SequenceGapFree stores every value it hands out. Combined with a transaction, values are not lost when a write fails.
+	SequenceGapFree SequenceMode = iota
+	// SequenceMonotonic reserves blocks of values, so it is faster but may skip the rest of a block on restart.
+	SequenceMonotonic
+)
+
+type SequenceOptions struct {
+	Mode      SequenceMode
+	Start     int64
+	Increment int64
+	CacheSize int64
+}
+
+// Sequences hands out increasing integers for named sequences.
+// Values are kept as items of SequencesGroupKind in svc, which NewHandler does not serve, and reserved with resource
+// version checks, so they are atomic on any Service which honors Preconditions.
+type Sequences struct {
+	svc Service
+
+	mu      sync.Mutex
+	options map[string]SequenceOptions
+	blocks  map[string]*sequenceBlock
+}
+
+type sequenceBlock struct {
+	next int64
+	last int64
+}
+
+// KindSequence returns the name of the sequence dedicated to groupKind.
+func KindSequence(groupKind string) string {
+	return strings.ReplaceAll(groupKind, "/", ".")
+}
+
+func (s *Sequences) Define(name string, opts SequenceOptions) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.options[name] = opts
+
+	delete(s.blocks, name)
+}
+
+func (s *Sequences) optionsOf(name string) SequenceOptions {
+	opts := s.options[name]
+
+	if opts.Start == 0 {
+		opts.Start = 1
+	}
+
+	if opts.Increment == 0 {
+		opts.Increment = 1
+	}
+
+	if opts.CacheSize <= 0 {
+		opts.CacheSize = 100
+	}
+
+	return opts
+}
+
+func (s *Sequences) Next(ctx context.Context, name string) (int64, error) {
+	s.mu.Lock()
+	opts := s.optionsOf(name)
+	s.mu.Unlock()
+
+	if opts.Mode == SequenceGapFree {
+		first, _, err := s.reserve(ctx, name, opts, 1)
+
+		return first, err
+	}
+
+	s.mu.Lock()
+
+	if block, ok := s.blocks[name]; ok && block.next <= block.last {
+		res := block.next
+
+		if !IsDryRun(ctx) {
+			block.next += opts.Increment
+		}
+
+		s.mu.Unlock()
+
+		return res, nil
+	}
+
+	s.mu.Unlock()
+
+	// The lock is not held while reserving, as the service may be locked by a transaction waiting for it.
+	first, last, err := s.reserve(ctx, name, opts, opts.CacheSize)
+	if err != nil {
+		return 0, err
+	}
+
+	if IsDryRun(ctx) {
+		return first, nil
+	}
+
+	// A block reserved in a transaction which is rolled back would be handed out again, so it is only kept after commit.
+	AfterCommit(ctx, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if block, ok := s.blocks[name]; !ok || block.next > block.last {
+			s.blocks[name] = &sequenceBlock{next: first + opts.Increment, last: last}
+		}
+	})
+
+	return first, nil
+}
+
+// reserve takes count values of a sequence and returns the first and the last of them.
+func (s *Sequences) reserve(ctx context.Context, name string, opts SequenceOptions, count int64) (first, last int64, err error) {
+	for i := 0; i < maxConflictRetries; i++ {
+		_, err = Update(ctx, s.svc, SequencesGroupKind, name, func(item GenericItem) (GenericItem, error) {
+			value, ok := toInt64(item["value"])
+			if !ok {
+				return nil, FieldError{Field: "value", Reason: "sequence value is not an integer"}
+			}
+
+			first = value + opts.Increment
+			last = value + count*opts.Increment
+			item["value"] = last
+
+			return item, nil
+		})
+		if err == nil || !(errors.As(err, &ItemNotFoundError{}) || errors.As(err, &GroupKindNotFoundError{})) {
+			return first, last, err
+		}
+
+		first = opts.Start
+		last = opts.Start + (count-1)*opts.Increment
+
+		err = s.svc.Create(ctx, SequencesGroupKind, GenericItem{"id": name, "value": last})
+		if err == nil || !errors.As(err, &ItemExistsError{}) {
+			return first, last, err
+		}
+	}
+
+	return 0, 0, ConflictError{ID: name}
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	case float64:
+		return int64(v), float64(int64(v)) == v
+	default:
+		return 0, false
+	}
+}
+
+func NewSequences(svc Service) *Sequences {
+	return &Sequences{
+		svc:     svc,
+		options: make(map[string]SequenceOptions),
+		blocks:  make(map[string]*sequenceBlock),
+	}
+}
@@ -0,0 +1,177 @@
+package core_test
+
+import (
+	"context"
+	"github.com/applicaset/core"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestGapFreeSequenceIsAtomic(t *testing.T) {
+	ctx := context.Background()
+
+	seq := core.NewSequences(core.NewStore())
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		values = make(map[int64]bool)
+	)
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			value, err := seq.Next(ctx, "invoices")
+			assert.NoError(t, err)
+
+			mu.Lock()
+			values[value] = true
+			mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+
+	assert.Len(t, values, 50)
+
+	for i := int64(1); i <= 50; i++ {
+		assert.True(t, values[i], i)
+	}
+}
+
+func TestMonotonicSequenceReservesBlocks(t *testing.T) {
+	ctx := context.Background()
+
+	store := core.NewStore()
+
+	seq1 := core.NewSequences(store)
+	seq1.Define("views", core.SequenceOptions{Mode: core.SequenceMonotonic, Start: 10, CacheSize: 5})
+
+	seq2 := core.NewSequences(store)
+	seq2.Define("views", core.SequenceOptions{Mode: core.SequenceMonotonic, Start: 10, CacheSize: 5})
+
+	value, err := seq1.Next(ctx, "views")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), value)
+
+	value, err = seq1.Next(ctx, "views")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(11), value)
+
+	value, err = seq2.Next(ctx, "views")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(15), value)
+
+	item, err := store.Read(ctx, core.SequencesGroupKind, "views")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 19, item["value"])
+}
+
+var _ = Describe("Increment", Ordered, func() {
+	var svc core.Service
+	store := core.NewStore()
+	svc = store
+	svc = core.NewAutoFields(svc)
+
+	h := core.NewHandler(svc, core.WithSequences(core.NewSequences(store), "invoices"))
+
+	BeforeAll(func() {
+		res := doRequest(h, http.MethodPost, "/acme/posts", `{"id":"post1","title":"hello","stats":{"likes":1}}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+	})
+
+	It("should not lose concurrent increments", func() {
+		var wg sync.WaitGroup
+
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				res := doRequest(h, http.MethodPost, "/acme/posts/post1/_increment", `{"views":1,"stats.likes":2}`)
+				Expect(res.StatusCode).Should(Equal(http.StatusOK))
+			}()
+		}
+
+		wg.Wait()
+
+		rsp := decodeBody(doRequest(h, http.MethodGet, "/acme/posts/post1", ""))
+		Expect(rsp).Should(HaveKeyWithValue("views", float64(20)))
+		Expect(rsp["stats"]).Should(HaveKeyWithValue("likes", float64(41)))
+	})
+
+	It("should fail on non-numeric field", func() {
+		res := doRequest(h, http.MethodPost, "/acme/posts/post1/_increment", `{"title":1}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+	})
+
+	It("should fail on paths through other values than objects", func() {
+		res := doRequest(h, http.MethodPost, "/acme/posts", `{"id":"post3","tags":[1,2],"name":"x"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		res = doRequest(h, http.MethodPost, "/acme/posts/post3/_increment", `{"tags.0":1}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+
+		res = doRequest(h, http.MethodPost, "/acme/posts/post3/_increment", `{"name.len":1}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+
+		res = doRequest(h, http.MethodPost, "/acme/posts/post3/_increment", `{"tags":1}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+
+		rsp := decodeBody(doRequest(h, http.MethodGet, "/acme/posts/post3", ""))
+		Expect(rsp).Should(HaveKeyWithValue("tags", []interface{}{float64(1), float64(2)}))
+		Expect(rsp).Should(HaveKeyWithValue("name", "x"))
+	})
+
+	It("should fail on not existed item", func() {
+		res := doRequest(h, http.MethodPost, "/acme/posts/post2/_increment", `{"views":1}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusNotFound))
+	})
+
+	It("should hand out sequence values", func() {
+		rsp := decodeBody(doRequest(h, http.MethodPost, "/_sequences/invoices", ""))
+		Expect(rsp).Should(HaveKeyWithValue("value", float64(1)))
+
+		rsp = decodeBody(doRequest(h, http.MethodPost, "/_sequences/invoices", ""))
+		Expect(rsp).Should(HaveKeyWithValue("value", float64(2)))
+	})
+
+	It("should not let clients change sequences", func() {
+		res := doRequest(h, http.MethodPut, "/core/sequences/invoices", `{"id":"invoices","value":0}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusNotFound))
+
+		res = doRequest(h, http.MethodDelete, "/core/sequences/invoices", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNotFound))
+
+		res = doRequest(h, http.MethodGet, "/core/sequences", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNotFound))
+
+		rsp := decodeBody(doRequest(h, http.MethodPost, "/_sequences/invoices", ""))
+		Expect(rsp).Should(HaveKeyWithValue("value", float64(3)))
+	})
+
+	It("should only hand out values of configured sequences", func() {
+		res := doRequest(h, http.MethodPost, "/_sequences/orders", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNotFound))
+
+		res = doRequest(h, http.MethodPost, "/_sequences/acme.posts", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNotFound))
+
+		res = doRequest(h, http.MethodPost, "/_sequences/core.changes", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNotFound))
+
+		_, err := store.Read(context.Background(), core.SequencesGroupKind, "orders")
+		Expect(err).Should(MatchError(core.ItemNotFoundError{ID: "orders"}))
+
+		Expect(func() { core.WithSequences(core.NewSequences(store), "acme.posts") }).Should(Panic())
+	})
+})
@@ -12,7 +12,40 @@ type Item interface {
 type GenericItem map[string]interface{}
 
 func (item GenericItem) GetID() string {
-	return item["id"].(string)
+	id, _ := item["id"].(string)
+
+	return id
+}
+
+func (item GenericItem) DeepCopy() GenericItem {
+	if item == nil {
+		return nil
+	}
+
+	return deepCopyValue(map[string]interface{}(item)).(map[string]interface{})
+}
+
+func deepCopyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case GenericItem:
+		return GenericItem(deepCopyValue(map[string]interface{}(v)).(map[string]interface{}))
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for k := range v {
+			res[k] = deepCopyValue(v[k])
+		}
+
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i := range v {
+			res[i] = deepCopyValue(v[i])
+		}
+
+		return res
+	default:
+		return v
+	}
 }
 
 type Service interface {
@@ -23,6 +56,42 @@ type Service interface {
 	Delete(ctx context.Context, groupKind string, id string) (err error)
 }
 
+// GroupKindLister is implemented by services which can enumerate the kinds they hold.
+type GroupKindLister interface {
+	ListGroupKinds(ctx context.Context) (res []string, err error)
+}
+
+// Lookup walks a chain of decorators, following their Unwrap method, and returns the first service implementing T.
+func Lookup[T any](svc Service) (res T, ok bool) {
+	for svc != nil {
+		res, ok = svc.(T)
+		if ok {
+			return res, true
+		}
+
+		u, isDecorator := svc.(interface{ Unwrap() Service })
+		if !isDecorator {
+			break
+		}
+
+		svc = u.Unwrap()
+	}
+
+	return res, false
+}
+
+// innermost returns the Service at the bottom of the chain of svc, which the decorators of svc wrap.
+func innermost(svc Service) Service {
+	for {
+		u, isDecorator := svc.(interface{ Unwrap() Service })
+		if !isDecorator {
+			return svc
+		}
+
+		svc = u.Unwrap()
+	}
+}
+
 func GetGroupKind(group, kind string) string {
 	return strings.Join([]string{group, kind}, "/")
 }
@@ -2,16 +2,28 @@ package core
 
 import (
 	"context"
+	"sort"
+	"strconv"
 	"sync"
 )
 
 type Store struct {
-	db map[string]map[string]GenericItem
-	sync.Mutex
+	db     map[string]map[string]GenericItem
+	rv     uint64
+	events *eventLog
+	// commits keeps the callbacks of transactions in the order they committed.
+	commits sync.Mutex
+	sync.RWMutex
 }
 
-func (s *Store) List(_ context.Context, groupKind string) ([]GenericItem, error) {
-	res := make([]GenericItem, len(s.db))
+func (s *Store) nextResourceVersion() string {
+	s.rv++
+
+	return strconv.FormatUint(s.rv, 10)
+}
+
+func (s *Store) List(ctx context.Context, groupKind string) ([]GenericItem, error) {
+	defer s.rlock(ctx)()
 
 	table, ok := s.db[groupKind]
 	if !ok {
@@ -22,37 +34,58 @@ func (s *Store) List(_ context.Context, groupKind string) ([]GenericItem, error)
 		}
 	}
 
+	res := make([]GenericItem, len(table))
+
 	i := 0
 	for k := range table {
-		res[i] = table[k]
+		res[i] = table[k].DeepCopy()
 
 		i++
 	}
 
+	sort.Slice(res, func(i, j int) bool { return res[i].GetID() < res[j].GetID() })
+
 	return res, nil
 }
 
-func (s *Store) Create(_ context.Context, groupKind string, req GenericItem) error {
-	s.Lock()
-	defer s.Unlock()
+func (s *Store) Create(ctx context.Context, groupKind string, req GenericItem) error {
+	if req.GetID() == "" {
+		return InvalidIDError{ID: req["id"], Reason: "must be a non-empty string"}
+	}
+
+	unlock, record := s.lock(ctx)
+	defer unlock()
 
-	_, ok := s.db[groupKind]
-	if !ok {
-		s.db[groupKind] = make(map[string]GenericItem)
+	id := req.GetID()
+
+	if _, ok := s.db[groupKind][id]; ok {
+		return ItemExistsError{ID: id}
 	}
 
-	for k := range s.db[groupKind] {
-		if req.GetID() == k {
-			return ItemExistsError{ID: k}
-		}
+	if IsDryRun(ctx) {
+		return nil
 	}
 
-	s.db[groupKind][req.GetID()] = req
+	if _, ok := s.db[groupKind]; !ok {
+		s.db[groupKind] = make(map[string]GenericItem)
+
+		record(func() { delete(s.db, groupKind) })
+	}
+
+	req["resourceVersion"] = s.nextResourceVersion()
+
+	s.db[groupKind][id] = req.DeepCopy()
+
+	record(func() { delete(s.db[groupKind], id) })
+
+	s.emit(ctx, EventAdded, groupKind, req)
 
 	return nil
 }
 
-func (s *Store) Read(_ context.Context, groupKind string, id string) (GenericItem, error) {
+func (s *Store) Read(ctx context.Context, groupKind string, id string) (GenericItem, error) {
+	defer s.rlock(ctx)()
+
 	table, ok := s.db[groupKind]
 	if !ok {
 		group, kind := GetGroupAndKind(groupKind)
@@ -67,12 +100,12 @@ func (s *Store) Read(_ context.Context, groupKind string, id string) (GenericIte
 		return nil, ItemNotFoundError{ID: id}
 	}
 
-	return res, nil
+	return res.DeepCopy(), nil
 }
 
-func (s *Store) Replace(_ context.Context, groupKind string, id string, req GenericItem) error {
-	s.Lock()
-	defer s.Unlock()
+func (s *Store) Replace(ctx context.Context, groupKind string, id string, req GenericItem) error {
+	unlock, record := s.lock(ctx)
+	defer unlock()
 
 	table, ok := s.db[groupKind]
 	if !ok {
@@ -83,18 +116,38 @@ func (s *Store) Replace(_ context.Context, groupKind string, id string, req Gene
 		}
 	}
 
-	if _, ok := table[id]; !ok {
+	current, ok := table[id]
+	if !ok {
 		return ItemNotFoundError{ID: id}
 	}
 
-	s.db[groupKind][id] = req
+	err := PreconditionsFromContext(ctx).Check(id, current)
+	if err != nil {
+		return err
+	}
+
+	if rv := ResourceVersionOf(req); rv != "" && rv != ResourceVersionOf(current) {
+		return ConflictError{ID: id}
+	}
+
+	if IsDryRun(ctx) {
+		return nil
+	}
+
+	req["resourceVersion"] = s.nextResourceVersion()
+
+	s.db[groupKind][id] = req.DeepCopy()
+
+	record(func() { s.db[groupKind][id] = current })
+
+	s.emit(ctx, EventModified, groupKind, req)
 
 	return nil
 }
 
-func (s *Store) Delete(_ context.Context, groupKind string, id string) error {
-	s.Lock()
-	defer s.Unlock()
+func (s *Store) Delete(ctx context.Context, groupKind string, id string) error {
+	unlock, record := s.lock(ctx)
+	defer unlock()
 
 	table, ok := s.db[groupKind]
 	if !ok {
@@ -105,19 +158,54 @@ func (s *Store) Delete(_ context.Context, groupKind string, id string) error {
 		}
 	}
 
-	if _, ok := table[id]; !ok {
+	current, ok := table[id]
+	if !ok {
 		return ItemNotFoundError{ID: id}
 	}
 
+	err := PreconditionsFromContext(ctx).Check(id, current)
+	if err != nil {
+		return err
+	}
+
+	if IsDryRun(ctx) {
+		return nil
+	}
+
+	s.nextResourceVersion()
+
 	delete(s.db[groupKind], id)
 
+	record(func() { s.db[groupKind][id] = current })
+
+	s.emit(ctx, EventDeleted, groupKind, current)
+
 	return nil
 }
 
-var _ Service = new(Store)
+func (s *Store) ListGroupKinds(ctx context.Context) ([]string, error) {
+	defer s.rlock(ctx)()
+
+	res := make([]string, 0, len(s.db))
+	for k := range s.db {
+		res = append(res, k)
+	}
+
+	sort.Strings(res)
+
+	return res, nil
+}
+
+var (
+	_ Service         = new(Store)
+	_ GroupKindLister = new(Store)
+	_ Transactor      = new(Store)
+	_ Watcher         = new(Store)
+)
 
 func NewStore() *Store {
 	return &Store{
-		db: make(map[string]map[string]GenericItem),
+		db:     make(map[string]map[string]GenericItem),
+		events: newEventLog(),
 	}
 }
@@ -0,0 +1,151 @@
+package core
+
+import "context"
+
+// Transactor is implemented by services which can run several operations as a unit.
+// The context passed to fn carries the transaction, and every operation of the service chain made with it joins it.
+// If fn fails, none of its writes are kept. A Tx called with a context which already carries a transaction joins it.
+// Transactions may hold the whole service while fn runs, so fn must not block, wait for I/O or run user code
+// without a bound on its time.
+type Transactor interface {
+	Tx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+type TxNotSupportedError struct{}
+
+func (err TxNotSupportedError) Error() string {
+	return "transactions are not supported"
+}
+
+// Tx runs fn in a transaction of the first Transactor in the chain of svc.
+func Tx(ctx context.Context, svc Service, fn func(ctx context.Context) error) error {
+	t, ok := Lookup[Transactor](svc)
+	if !ok {
+		return TxNotSupportedError{}
+	}
+
+	return t.Tx(ctx, fn)
+}
+
+// inTransaction reports whether ctx carries a transaction.
+func inTransaction(ctx context.Context) bool {
+	_, ok := ctx.Value(afterCommitKey{}).(afterCommitter)
+
+	return ok
+}
+
+type afterCommitter interface {
+	afterCommit(fn func())
+}
+
+type afterCommitKey struct{}
+
+// AfterCommit defers fn until the transaction of ctx commits, and drops it if the transaction is rolled back.
+// Without a transaction fn is called immediately.
+// It is meant for side effects which can not be undone, like notifying other goroutines.
+// Callbacks of transactions run in the order the transactions committed, so they must not block or write themselves.
+func AfterCommit(ctx context.Context, fn func()) {
+	if c, ok := ctx.Value(afterCommitKey{}).(afterCommitter); ok {
+		c.afterCommit(fn)
+
+		return
+	}
+
+	fn()
+}
+
+type storeTx struct {
+	store *Store
+
+	undo      []func()
+	events    []storedEvent
+	callbacks []func()
+}
+
+func (tx *storeTx) rollback() {
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		tx.undo[i]()
+	}
+}
+
+type storeTxKey struct{}
+
+func (tx *storeTx) afterCommit(fn func()) {
+	tx.callbacks = append(tx.callbacks, fn)
+}
+
+// Tx holds the write lock of the store while fn runs, so every other read and write waits for it. fn must be
+// quick and must not block, and must not share ctx with other goroutines.
+func (s *Store) Tx(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	if s.txOf(ctx) != nil {
+		return fn(ctx)
+	}
+
+	tx := &storeTx{store: s}
+
+	ctx = context.WithValue(ctx, storeTxKey{}, tx)
+	ctx = context.WithValue(ctx, afterCommitKey{}, tx)
+
+	s.Lock()
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.rollback()
+			s.Unlock()
+
+			panic(r)
+		}
+	}()
+
+	err = fn(ctx)
+	if err != nil {
+		tx.rollback()
+		s.Unlock()
+
+		return err
+	}
+
+	s.events.append(tx.events...)
+
+	s.commits.Lock()
+	defer s.commits.Unlock()
+
+	s.Unlock()
+
+	for i := range tx.callbacks {
+		tx.callbacks[i]()
+	}
+
+	return nil
+}
+
+func (s *Store) txOf(ctx context.Context) *storeTx {
+	tx, ok := ctx.Value(storeTxKey{}).(*storeTx)
+	if !ok || tx.store != s {
+		return nil
+	}
+
+	return tx
+}
+
+// lock takes the write lock of the store, unless ctx carries a transaction of it, which already holds it.
+// record keeps fn to undo a write if the transaction is rolled back.
+func (s *Store) lock(ctx context.Context) (unlock func(), record func(fn func())) {
+	if tx := s.txOf(ctx); tx != nil {
+		return func() {}, func(fn func()) { tx.undo = append(tx.undo, fn) }
+	}
+
+	s.Lock()
+
+	return s.Unlock, func(func()) {}
+}
+
+func (s *Store) rlock(ctx context.Context) (unlock func()) {
+	if s.txOf(ctx) != nil {
+		return func() {}
+	}
+
+	s.RLock()
+
+	return s.RUnlock
+}
@@ -0,0 +1,143 @@
+package core_test
+
+import (
+	"context"
+	"errors"
+	"github.com/applicaset/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestTxCommits(t *testing.T) {
+	ctx := context.Background()
+
+	var svc core.Service
+	svc = core.NewStore()
+	svc = core.NewAutoFields(svc)
+
+	err := svc.Create(ctx, "acme/stock", core.GenericItem{"id": "item1", "count": 5})
+	require.NoError(t, err)
+
+	committed := false
+
+	err = core.Tx(ctx, svc, func(ctx context.Context) error {
+		core.AfterCommit(ctx, func() { committed = true })
+
+		err := svc.Create(ctx, "acme/orders", core.GenericItem{"id": "order1"})
+		if err != nil {
+			return err
+		}
+
+		_, err = core.Increment(ctx, svc, "acme/stock", "item1", map[string]float64{"count": -1})
+
+		return err
+	})
+	require.NoError(t, err)
+	assert.True(t, committed)
+
+	_, err = svc.Read(ctx, "acme/orders", "order1")
+	assert.NoError(t, err)
+
+	item, err := svc.Read(ctx, "acme/stock", "item1")
+	require.NoError(t, err)
+	assert.EqualValues(t, 4, item["count"])
+}
+
+func TestTxRollsBack(t *testing.T) {
+	ctx := context.Background()
+
+	store := core.NewStore()
+
+	err := store.Create(ctx, "acme/stock", core.GenericItem{"id": "item1", "count": 5})
+	require.NoError(t, err)
+
+	err = store.Create(ctx, "acme/stock", core.GenericItem{"id": "item2", "count": 1})
+	require.NoError(t, err)
+
+	errOutOfStock := errors.New("out of stock")
+	committed := false
+
+	err = core.Tx(ctx, store, func(ctx context.Context) error {
+		core.AfterCommit(ctx, func() { committed = true })
+
+		err := store.Create(ctx, "acme/orders", core.GenericItem{"id": "order1"})
+		if err != nil {
+			return err
+		}
+
+		err = store.Replace(ctx, "acme/stock", "item1", core.GenericItem{"id": "item1", "count": 4})
+		if err != nil {
+			return err
+		}
+
+		err = store.Delete(ctx, "acme/stock", "item2")
+		if err != nil {
+			return err
+		}
+
+		// nested transactions join the outer one
+		return store.Tx(ctx, func(context.Context) error {
+			return errOutOfStock
+		})
+	})
+	assert.ErrorIs(t, err, errOutOfStock)
+	assert.False(t, committed)
+
+	_, err = store.List(ctx, "acme/orders")
+	assert.ErrorAs(t, err, &core.GroupKindNotFoundError{})
+
+	item, err := store.Read(ctx, "acme/stock", "item1")
+	require.NoError(t, err)
+	assert.EqualValues(t, 5, item["count"])
+
+	_, err = store.Read(ctx, "acme/stock", "item2")
+	assert.NoError(t, err)
+}
+
+func TestTxIsolatesConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+
+	store := core.NewStore()
+
+	err := store.Create(ctx, "acme/stock", core.GenericItem{"id": "item1", "count": 0})
+	require.NoError(t, err)
+
+	started := make(chan struct{})
+	done := make(chan error)
+
+	go func() {
+		done <- core.Tx(ctx, store, func(ctx context.Context) error {
+			close(started)
+
+			for i := 0; i < 10; i++ {
+				_, err := core.Increment(ctx, store, "acme/stock", "item1", map[string]float64{"count": 1})
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	}()
+
+	<-started
+
+	// blocks until the transaction commits
+	item, err := store.Read(ctx, "acme/stock", "item1")
+	require.NoError(t, err)
+	assert.EqualValues(t, 10, item["count"])
+
+	assert.NoError(t, <-done)
+}
+
+type opaqueService struct {
+	core.Service
+}
+
+func TestTxNotSupported(t *testing.T) {
+	svc := opaqueService{Service: core.NewStore()}
+
+	err := core.Tx(context.Background(), svc, func(context.Context) error { return nil })
+	assert.ErrorAs(t, err, &core.TxNotSupportedError{})
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"context"
+	"errors"
+)
+
+// Update reads an item, applies fn to it and replaces it guarded by the resource version it has read.
+// If another write happens in between, it starts over with the fresh item, unless fn has set a resource version
+// itself, and gives up with ConflictError after maxConflictRetries attempts.
+func Update(ctx context.Context, svc Service, groupKind string, id string, fn func(item GenericItem) (GenericItem, error)) (GenericItem, error) {
+	for i := 0; i < maxConflictRetries && ctx.Err() == nil; i++ {
+		current, err := svc.Read(ctx, groupKind, id)
+		if err != nil {
+			return nil, err
+		}
+
+		rv := ResourceVersionOf(current)
+
+		res, err := fn(current)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := res["resourceVersion"]; !ok {
+			res["resourceVersion"] = rv
+		}
+
+		err = svc.Replace(ctx, groupKind, id, res)
+		if err == nil {
+			return res, nil
+		}
+
+		if ResourceVersionOf(res) != rv || !errors.As(err, &ConflictError{}) {
+			return nil, err
+		}
+	}
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	return nil, ConflictError{ID: id}
+}
+
+// Upsert replaces the item with given id, or creates it if it does not exist, and reports whether it has been created.
+// An item is never created when the request carries If-Match preconditions.
+func Upsert(ctx context.Context, svc Service, groupKind string, id string, req GenericItem) (created bool, err error) {
+	if _, ok := req["id"]; !ok {
+		req["id"] = id
+	}
+
+	if req["id"] != id {
+		return false, InvalidIDError{ID: req["id"], Reason: "does not match the id in path"}
+	}
+
+	for i := 0; i < maxConflictRetries; i++ {
+		err = svc.Replace(ctx, groupKind, id, req)
+		if err == nil || !(errors.As(err, &ItemNotFoundError{}) || errors.As(err, &GroupKindNotFoundError{})) {
+			return false, err
+		}
+
+		if PreconditionsFromContext(ctx).IfMatch != nil {
+			return false, PreconditionFailedError{ID: id}
+		}
+
+		err = svc.Create(ctx, groupKind, req)
+		if err == nil || !errors.As(err, &ItemExistsError{}) {
+			return err == nil, err
+		}
+	}
+
+	return false, ConflictError{ID: id}
+}
@@ -0,0 +1,56 @@
+package core_test
+
+import (
+	"bytes"
+	"github.com/applicaset/core"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+)
+
+var _ = Describe("Upsert", Ordered, func() {
+	var svc core.Service
+	svc = core.NewStore()
+	svc = core.NewAutoFields(svc)
+
+	h := core.NewHandler(svc, core.WithUpsert())
+
+	It("should create not existed item", func() {
+		res := doRequest(h, http.MethodPut, "/acme/foo/foo1", `{"bar":"baz"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+		Expect(res.Header.Get("ETag")).ShouldNot(BeEmpty())
+
+		rsp := decodeBody(res)
+		Expect(rsp).Should(HaveKeyWithValue("id", "foo1"))
+		Expect(rsp).Should(HaveKey("uuid"))
+		Expect(rsp).Should(HaveKey("createdAt"))
+	})
+
+	It("should replace existing item", func() {
+		res := doRequest(h, http.MethodPut, "/acme/foo/foo1", `{"bar":"baz2"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+
+		rsp := decodeBody(doRequest(h, http.MethodGet, "/acme/foo/foo1", ""))
+		Expect(rsp).Should(HaveKeyWithValue("bar", "baz2"))
+	})
+
+	It("should fail on id mismatch", func() {
+		res := doRequest(h, http.MethodPut, "/acme/foo/foo2", `{"id":"foo3"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusBadRequest))
+
+		rsp := decodeBody(res)
+		Expect(rsp).Should(HaveKeyWithValue("message", "Invalid id"))
+	})
+
+	It("should not create item with if-match", func() {
+		req := httptest.NewRequest(http.MethodPut, "/acme/foo/foo2", bytes.NewBufferString(`{}`))
+		req.Header.Set("If-Match", "*")
+
+		w := httptest.NewRecorder()
+
+		h.ServeHTTP(w, req)
+
+		Expect(w.Code).Should(Equal(http.StatusPreconditionFailed))
+	})
+})
@@ -0,0 +1,285 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventAdded    EventType = "ADDED"
+	EventModified EventType = "MODIFIED"
+	EventDeleted  EventType = "DELETED"
+	// EventError ends a watch which can not go on, e.g. because it fell too far behind. Object holds a message.
+	EventError EventType = "ERROR"
+)
+
+type Event struct {
+	Type            EventType   `json:"type"`
+	GroupKind       string      `json:"groupKind,omitempty"`
+	ID              string      `json:"id,omitempty"`
+	ResourceVersion string      `json:"resourceVersion,omitempty"`
+	Object          GenericItem `json:"object"`
+}
+
+// Watcher is implemented by services which notify about changes of their items.
+// Watch streams the events of groupKind, or only of the item with id if it is not empty, until ctx is done.
+// With an empty resourceVersion it starts with the next change, otherwise with the first change after resourceVersion.
+type Watcher interface {
+	Watch(ctx context.Context, groupKind string, id string, resourceVersion string) (<-chan Event, error)
+}
+
+type ResourceVersionExpiredError struct {
+	ResourceVersion string
+}
+
+func (err ResourceVersionExpiredError) Error() string {
+	return fmt.Sprintf("resource version '%s' is too old", err.ResourceVersion)
+}
+
+type WatchNotSupportedError struct{}
+
+func (err WatchNotSupportedError) Error() string {
+	return "watch is not supported"
+}
+
+const (
+	eventHistorySize = 1024
+	// watchHeartbeatInterval keeps idle event streams from being closed by proxies.
+	watchHeartbeatInterval = 15 * time.Second
+)
+
+type storedEvent struct {
+	rv    uint64
+	event Event
+}
+
+// eventLog keeps the latest events, so watchers can catch up at their own pace without blocking writers.
+type eventLog struct {
+	mu      sync.Mutex
+	events  []storedEvent
+	trimmed uint64
+	changed chan struct{}
+}
+
+func (l *eventLog) append(events ...storedEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.events = append(l.events, events...)
+
+	if n := len(l.events) - eventHistorySize; n > 0 {
+		l.trimmed = l.events[n-1].rv
+		l.events = append(l.events[:0:0], l.events[n:]...)
+	}
+
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// since returns the events after rv and a channel which is closed on the next append.
+func (l *eventLog) since(rv uint64) ([]storedEvent, <-chan struct{}, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if rv < l.trimmed {
+		return nil, nil, false
+	}
+
+	i := len(l.events)
+	for i > 0 && l.events[i-1].rv > rv {
+		i--
+	}
+
+	return l.events[i:], l.changed, true
+}
+
+func newEventLog() *eventLog {
+	return &eventLog{changed: make(chan struct{})}
+}
+
+// emit records an event of a write made with ctx. Events of a transaction are recorded when it commits.
+// It must be called with the write lock held, so events are recorded in the order of their resource versions.
+func (s *Store) emit(ctx context.Context, eventType EventType, groupKind string, item GenericItem) {
+	e := storedEvent{
+		rv: s.rv,
+		event: Event{
+			Type:            eventType,
+			GroupKind:       groupKind,
+			ID:              item.GetID(),
+			ResourceVersion: strconv.FormatUint(s.rv, 10),
+			Object:          item.DeepCopy(),
+		},
+	}
+
+	e.event.Object["resourceVersion"] = e.event.ResourceVersion
+
+	if tx := s.txOf(ctx); tx != nil {
+		tx.events = append(tx.events, e)
+
+		return
+	}
+
+	s.events.append(e)
+}
+
+func (s *Store) Watch(ctx context.Context, groupKind string, id string, resourceVersion string) (<-chan Event, error) {
+	var rv uint64
+
+	if resourceVersion == "" {
+		unlock := s.rlock(ctx)
+		rv = s.rv
+		unlock()
+	} else {
+		var err error
+
+		rv, err = strconv.ParseUint(resourceVersion, 10, 64)
+		if err != nil {
+			return nil, FieldError{Field: "resourceVersion", Reason: "must be a non-negative integer"}
+		}
+	}
+
+	if _, _, ok := s.events.since(rv); !ok {
+		return nil, ResourceVersionExpiredError{ResourceVersion: resourceVersion}
+	}
+
+	ch := make(chan Event)
+
+	go func() {
+		defer close(ch)
+
+		for {
+			events, changed, ok := s.events.since(rv)
+			if !ok {
+				err := ResourceVersionExpiredError{ResourceVersion: strconv.FormatUint(rv, 10)}
+
+				select {
+				case ch <- Event{Type: EventError, Object: GenericItem{"message": err.Error()}}:
+				case <-ctx.Done():
+				}
+
+				return
+			}
+
+			for i := range events {
+				rv = events[i].rv
+
+				if events[i].event.GroupKind != groupKind || (id != "" && events[i].event.ID != id) {
+					continue
+				}
+
+				e := events[i].event
+				e.Object = e.Object.DeepCopy()
+
+				select {
+				case ch <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+func isWatch(r *http.Request) bool {
+	watch, _ := strconv.ParseBool(r.URL.Query().Get("watch"))
+
+	return watch
+}
+
+// serveWatch streams the events of groupKind, or of the item with id, as server-sent events.
+// It resumes after the resourceVersion query parameter, or after the Last-Event-ID header of a reconnecting client.
+func serveWatch(w http.ResponseWriter, r *http.Request, svc Service, groupKind string, id string) {
+	watcher, ok := Lookup[Watcher](svc)
+	if !ok {
+		writeError(w, WatchNotSupportedError{})
+
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, WatchNotSupportedError{})
+
+		return
+	}
+
+	rv := r.URL.Query().Get("resourceVersion")
+	if rv == "" {
+		rv = r.Header.Get("Last-Event-ID")
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	events, err := watcher.Watch(ctx, groupKind, id, rv)
+	if err != nil {
+		writeError(w, err)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(watchHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+
+			err = writeEvent(w, e)
+		}
+
+		if err != nil {
+			return
+		}
+
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	if e.ResourceVersion != "" {
+		_, err = fmt.Fprintf(w, "id: %s\n", e.ResourceVersion)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+
+	return err
+}
@@ -0,0 +1,222 @@
+package core_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/applicaset/core"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func nextEvent(t *testing.T, events <-chan core.Event) core.Event {
+	t.Helper()
+
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for event")
+
+		return core.Event{}
+	}
+}
+
+func TestStoreWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := core.NewStore()
+
+	events, err := store.Watch(ctx, "acme/foo", "", "")
+	require.NoError(t, err)
+
+	itemEvents, err := store.Watch(ctx, "acme/foo", "foo2", "")
+	require.NoError(t, err)
+
+	require.NoError(t, store.Create(ctx, "acme/bar", core.GenericItem{"id": "bar1"}))
+	require.NoError(t, store.Create(ctx, "acme/foo", core.GenericItem{"id": "foo1"}))
+	require.NoError(t, store.Create(ctx, "acme/foo", core.GenericItem{"id": "foo2"}))
+	require.NoError(t, store.Replace(ctx, "acme/foo", "foo1", core.GenericItem{"id": "foo1", "bar": "baz"}))
+	require.NoError(t, store.Delete(ctx, "acme/foo", "foo1"))
+
+	e := nextEvent(t, events)
+	assert.Equal(t, core.EventAdded, e.Type)
+	assert.Equal(t, "foo1", e.ID)
+	assert.Equal(t, "2", e.ResourceVersion)
+
+	e = nextEvent(t, events)
+	assert.Equal(t, core.EventAdded, e.Type)
+	assert.Equal(t, "foo2", e.ID)
+
+	e = nextEvent(t, events)
+	assert.Equal(t, core.EventModified, e.Type)
+	assert.Equal(t, "baz", e.Object["bar"])
+
+	e = nextEvent(t, events)
+	assert.Equal(t, core.EventDeleted, e.Type)
+	assert.Equal(t, "foo1", e.ID)
+	assert.Equal(t, "5", e.Object["resourceVersion"])
+
+	e = nextEvent(t, itemEvents)
+	assert.Equal(t, core.EventAdded, e.Type)
+	assert.Equal(t, "foo2", e.ID)
+
+	// resume after the creation of foo2
+	resumed, err := store.Watch(ctx, "acme/foo", "", "3")
+	require.NoError(t, err)
+
+	assert.Equal(t, "4", nextEvent(t, resumed).ResourceVersion)
+	assert.Equal(t, "5", nextEvent(t, resumed).ResourceVersion)
+}
+
+func TestStoreWatchTx(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := core.NewStore()
+
+	events, err := store.Watch(ctx, "acme/foo", "", "")
+	require.NoError(t, err)
+
+	err = store.Tx(ctx, func(ctx context.Context) error {
+		require.NoError(t, store.Create(ctx, "acme/foo", core.GenericItem{"id": "foo1"}))
+
+		return errors.New("rollback")
+	})
+	require.Error(t, err)
+
+	err = store.Tx(ctx, func(ctx context.Context) error {
+		return store.Create(ctx, "acme/foo", core.GenericItem{"id": "foo2"})
+	})
+	require.NoError(t, err)
+
+	e := nextEvent(t, events)
+	assert.Equal(t, "foo2", e.ID)
+}
+
+func TestStoreWatchExpired(t *testing.T) {
+	ctx := context.Background()
+
+	store := core.NewStore()
+
+	for i := 0; i < 2000; i++ {
+		require.NoError(t, store.Create(ctx, "acme/foo", core.GenericItem{"id": fmt.Sprint(i)}))
+	}
+
+	_, err := store.Watch(ctx, "acme/foo", "", "1")
+	assert.ErrorAs(t, err, &core.ResourceVersionExpiredError{})
+
+	_, err = store.Watch(ctx, "acme/foo", "", "1990")
+	assert.NoError(t, err)
+
+	_, err = store.Watch(ctx, "acme/foo", "", "latest")
+	assert.ErrorAs(t, err, &core.FieldError{})
+}
+
+var _ = Describe("Watch", func() {
+	var (
+		svc    core.Service
+		server *httptest.Server
+	)
+
+	BeforeEach(func() {
+		svc = core.NewStore()
+		svc = core.NewAutoFields(svc)
+
+		server = httptest.NewServer(core.NewHandler(svc))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	watch := func(target string, header http.Header) (*bufio.Reader, func()) {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+target, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		for k := range header {
+			req.Header[k] = header[k]
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+		Expect(res.Header.Get("Content-Type")).Should(Equal("text/event-stream"))
+
+		return bufio.NewReader(res.Body), func() {
+			cancel()
+
+			_ = res.Body.Close()
+		}
+	}
+
+	// readEvent returns the lines of the next event
+	readEvent := func(r *bufio.Reader) []string {
+		var res []string
+
+		for {
+			line, err := r.ReadString('\n')
+			Expect(err).ShouldNot(HaveOccurred())
+
+			line = strings.TrimSuffix(line, "\n")
+			if line == "" {
+				return res
+			}
+
+			res = append(res, line)
+		}
+	}
+
+	It("should stream changes of a kind", func() {
+		r, stop := watch("/acme/foo?watch=true", nil)
+		defer stop()
+
+		err := svc.Create(context.Background(), "acme/foo", core.GenericItem{"id": "foo1"})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		lines := readEvent(r)
+		Expect(lines).Should(HaveLen(3))
+		Expect(lines[0]).Should(Equal("id: 1"))
+		Expect(lines[1]).Should(Equal("event: ADDED"))
+		Expect(lines[2]).Should(HavePrefix(`data: {"type":"ADDED","groupKind":"acme/foo","id":"foo1"`))
+	})
+
+	It("should stream changes of an item and resume from the last event", func() {
+		err := svc.Create(context.Background(), "acme/foo", core.GenericItem{"id": "foo1"})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		err = svc.Create(context.Background(), "acme/foo", core.GenericItem{"id": "foo2"})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		err = svc.Delete(context.Background(), "acme/foo", "foo1")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		r, stop := watch("/acme/foo/foo1?watch=true", http.Header{"Last-Event-ID": []string{"1"}})
+		defer stop()
+
+		lines := readEvent(r)
+		Expect(lines[0]).Should(Equal("id: 3"))
+		Expect(lines[1]).Should(Equal("event: DELETED"))
+	})
+
+	It("should fail on expired resource versions", func() {
+		for i := 0; i < 2000; i++ {
+			err := svc.Create(context.Background(), "acme/foo", core.GenericItem{"id": fmt.Sprint(i)})
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+
+		res := doRequest(server.Config.Handler, http.MethodGet, "/acme/foo?watch=true&resourceVersion=1", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusGone))
+	})
+})
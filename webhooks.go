@@ -0,0 +1,694 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	WebhooksGroupKind          = "core/webhooks"
+	WebhookDeliveriesGroupKind = "core/webhookdeliveries"
+	WebhookSecretsGroupKind    = "core/webhooksecrets"
+
+	WebhookIDHeader        = "X-Webhook-ID"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+type WebhookOptions struct {
+	Client         *http.Client
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// PollInterval is how often due retries are looked for.
+	PollInterval time.Duration
+	// Retention is how long finished deliveries are kept.
+	Retention time.Duration
+}
+
+type WebhookMetrics struct {
+	Succeeded uint64 `json:"succeeded"`
+	Failed    uint64 `json:"failed"`
+	Retried   uint64 `json:"retried"`
+}
+
+// WebhookPayload is the body of webhook calls.
+type WebhookPayload struct {
+	Delivery string `json:"delivery"`
+	Webhook  string `json:"webhook"`
+	Event    Event  `json:"event"`
+}
+
+// Webhooks calls the webhooks registered as items of WebhooksGroupKind on changes of the kinds they subscribe to.
+// A webhook has a url, an optional secret to sign payloads with, the kinds it subscribes to as "group/kind"
+// and optionally the event types it is interested in.
+//
+// Secrets are moved out of webhooks into WebhookSecretsGroupKind, which NewHandler does not serve, and webhooks
+// written without one keep the secret they have. An empty secret removes it.
+//
+// Deliveries are queued as items of WebhookDeliveriesGroupKind within the write they are about,
+// so they survive as long as the service does, and keep the history of their attempts until Retention has passed
+// since they finished. NewHandler does not serve them.
+// Each webhook gets its deliveries in order, independently of the others: a delivery waiting for a retry holds
+// back the later ones of its webhook until it succeeds or runs out of attempts.
+type Webhooks struct {
+	next    Service
+	opts    WebhookOptions
+	trigger chan struct{}
+
+	mu   sync.Mutex
+	busy map[string]bool
+
+	succeeded uint64
+	failed    uint64
+	retried   uint64
+}
+
+// SignWebhookPayload returns the signature of a payload sent at timestamp, as found in the X-Webhook-Signature header.
+func SignWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (wh *Webhooks) List(ctx context.Context, groupKind string) ([]GenericItem, error) {
+	return wh.next.List(ctx, groupKind)
+}
+
+func (wh *Webhooks) Create(ctx context.Context, groupKind string, req GenericItem) error {
+	var (
+		secret    string
+		hasSecret bool
+	)
+
+	if groupKind == WebhooksGroupKind {
+		err := validateWebhook(req)
+		if err != nil {
+			return err
+		}
+
+		secret, hasSecret = takeWebhookSecret(req)
+	}
+
+	return inTx(ctx, wh.next, func(ctx context.Context) error {
+		err := wh.next.Create(ctx, groupKind, req)
+		if err != nil {
+			return err
+		}
+
+		if hasSecret {
+			err = saveWebhookSecret(ctx, wh.next, groupKind, req.GetID(), secret)
+			if err != nil {
+				return err
+			}
+		}
+
+		return wh.enqueue(ctx, EventAdded, groupKind, req)
+	})
+}
+
+func (wh *Webhooks) Read(ctx context.Context, groupKind string, id string) (GenericItem, error) {
+	return wh.next.Read(ctx, groupKind, id)
+}
+
+func (wh *Webhooks) Replace(ctx context.Context, groupKind string, id string, req GenericItem) error {
+	var (
+		secret    string
+		hasSecret bool
+	)
+
+	if groupKind == WebhooksGroupKind {
+		err := validateWebhook(req)
+		if err != nil {
+			return err
+		}
+
+		secret, hasSecret = takeWebhookSecret(req)
+	}
+
+	return inTx(ctx, wh.next, func(ctx context.Context) error {
+		err := wh.next.Replace(ctx, groupKind, id, req)
+		if err != nil {
+			return err
+		}
+
+		if hasSecret {
+			err = saveWebhookSecret(ctx, wh.next, groupKind, id, secret)
+			if err != nil {
+				return err
+			}
+		}
+
+		return wh.enqueue(ctx, EventModified, groupKind, req)
+	})
+}
+
+func (wh *Webhooks) Delete(ctx context.Context, groupKind string, id string) error {
+	return inTx(ctx, wh.next, func(ctx context.Context) error {
+		item, err := wh.next.Read(ctx, groupKind, id)
+		if err != nil {
+			return err
+		}
+
+		err = wh.next.Delete(ctx, groupKind, id)
+		if err != nil {
+			return err
+		}
+
+		// items with finalizers are only marked for deletion
+		current, err := wh.next.Read(ctx, groupKind, id)
+		if err == nil {
+			return wh.enqueue(ctx, EventModified, groupKind, current)
+		}
+
+		if groupKind == WebhooksGroupKind {
+			err = saveWebhookSecret(ctx, wh.next, groupKind, id, "")
+			if err != nil {
+				return err
+			}
+		}
+
+		return wh.enqueue(ctx, EventDeleted, groupKind, item)
+	})
+}
+
+func (wh *Webhooks) Unwrap() Service {
+	return wh.next
+}
+
+var _ Service = new(Webhooks)
+
+func validateWebhook(item GenericItem) error {
+	s, _ := item["url"].(string)
+
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return FieldError{Field: "url", Reason: "must be an absolute http or https url"}
+	}
+
+	if _, ok := item["kinds"].([]interface{}); !ok {
+		return FieldError{Field: "kinds", Reason: "must be a list of group/kind"}
+	}
+
+	if v, ok := item["secret"]; ok {
+		if _, ok := v.(string); !ok {
+			return FieldError{Field: "secret", Reason: "must be a string"}
+		}
+	}
+
+	return nil
+}
+
+func webhookSecretID(groupKind string, id string) string {
+	return KindSequence(groupKind) + "." + id
+}
+
+// takeWebhookSecret removes the secret from webhook, and reports whether it had one.
+func takeWebhookSecret(webhook GenericItem) (string, bool) {
+	v, ok := webhook["secret"]
+	if !ok {
+		return "", false
+	}
+
+	delete(webhook, "secret")
+
+	secret, _ := v.(string)
+
+	return secret, true
+}
+
+// saveWebhookSecret keeps the secret of the webhook of groupKind with given id, or removes it if it is empty.
+func saveWebhookSecret(ctx context.Context, svc Service, groupKind string, id string, secret string) error {
+	secretID := webhookSecretID(groupKind, id)
+
+	if secret == "" {
+		err := svc.Delete(ctx, WebhookSecretsGroupKind, secretID)
+		if err != nil && !errors.As(err, &ItemNotFoundError{}) && !errors.As(err, &GroupKindNotFoundError{}) {
+			return err
+		}
+
+		return nil
+	}
+
+	item := GenericItem{"id": secretID, "secret": secret}
+
+	err := svc.Replace(ctx, WebhookSecretsGroupKind, secretID, item)
+	if errors.As(err, &ItemNotFoundError{}) || errors.As(err, &GroupKindNotFoundError{}) {
+		return svc.Create(ctx, WebhookSecretsGroupKind, item)
+	}
+
+	return err
+}
+
+func webhookSecret(ctx context.Context, svc Service, groupKind string, id string) (string, error) {
+	item, err := svc.Read(ctx, WebhookSecretsGroupKind, webhookSecretID(groupKind, id))
+	if err != nil {
+		if errors.As(err, &ItemNotFoundError{}) || errors.As(err, &GroupKindNotFoundError{}) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	secret, _ := item["secret"].(string)
+
+	return secret, nil
+}
+
+func webhookSubscribes(webhook GenericItem, eventType EventType, groupKind string) bool {
+	if disabled, _ := webhook["disabled"].(bool); disabled {
+		return false
+	}
+
+	kinds, _ := webhook["kinds"].([]interface{})
+	if !containsValue(kinds, groupKind) {
+		return false
+	}
+
+	events, ok := webhook["events"].([]interface{})
+
+	return !ok || containsValue(events, string(eventType))
+}
+
+func containsValue(list []interface{}, v interface{}) bool {
+	for i := range list {
+		if list[i] == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (wh *Webhooks) enqueue(ctx context.Context, eventType EventType, groupKind string, item GenericItem) error {
+	if groupKind == WebhookDeliveriesGroupKind || groupKind == WebhookSecretsGroupKind || IsDryRun(ctx) {
+		return nil
+	}
+
+	webhooks, err := wh.next.List(ctx, WebhooksGroupKind)
+	if err != nil {
+		if errors.As(err, &GroupKindNotFoundError{}) {
+			return nil
+		}
+
+		return err
+	}
+
+	queued := false
+
+	for i := range webhooks {
+		if !webhookSubscribes(webhooks[i], eventType, groupKind) {
+			continue
+		}
+
+		id, err := uuid.NewV7()
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().Format(time.RFC3339Nano)
+
+		err = wh.next.Create(ctx, WebhookDeliveriesGroupKind, GenericItem{
+			"id":      id.String(),
+			"webhook": webhooks[i].GetID(),
+			"event": map[string]interface{}{
+				"type":            string(eventType),
+				"groupKind":       groupKind,
+				"id":              item.GetID(),
+				"resourceVersion": ResourceVersionOf(item),
+				"object":          map[string]interface{}(item.DeepCopy()),
+			},
+			"status":        DeliveryPending,
+			"attempts":      []interface{}{},
+			"queuedAt":      now,
+			"nextAttemptAt": now,
+		})
+		if err != nil {
+			return err
+		}
+
+		queued = true
+	}
+
+	if queued {
+		AfterCommit(ctx, wh.notify)
+	}
+
+	return nil
+}
+
+func (wh *Webhooks) notify() {
+	select {
+	case wh.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (wh *Webhooks) backoff(attempts int) time.Duration {
+	res := wh.opts.InitialBackoff
+
+	for i := 1; i < attempts && res < wh.opts.MaxBackoff; i++ {
+		res *= 2
+	}
+
+	if res > wh.opts.MaxBackoff {
+		res = wh.opts.MaxBackoff
+	}
+
+	return res
+}
+
+// Dispatch attempts the pending deliveries of each webhook in order, up to the first one which is not due, and
+// removes finished deliveries past retention. Each webhook is called by one goroutine at a time, so webhooks
+// which are still busy with an earlier dispatch are left to the next one.
+func (wh *Webhooks) Dispatch(ctx context.Context) error {
+	deliveries, err := wh.next.List(ctx, WebhookDeliveriesGroupKind)
+	if err != nil {
+		if errors.As(err, &GroupKindNotFoundError{}) {
+			return nil
+		}
+
+		return err
+	}
+
+	now := time.Now()
+
+	var webhookIDs []string
+
+	// deliveries are listed by id, which is the order they were queued in
+	pending := make(map[string][]GenericItem)
+
+	for i := range deliveries {
+		if deliveries[i]["status"] != DeliveryPending {
+			if deliveryExpired(deliveries[i], now.Add(-wh.opts.Retention)) {
+				err = wh.next.Delete(ctx, WebhookDeliveriesGroupKind, deliveries[i].GetID())
+				if err != nil && !errors.As(err, &ItemNotFoundError{}) {
+					return err
+				}
+			}
+
+			continue
+		}
+
+		webhookID := fmt.Sprint(deliveries[i]["webhook"])
+		if _, ok := pending[webhookID]; !ok {
+			webhookIDs = append(webhookIDs, webhookID)
+		}
+
+		pending[webhookID] = append(pending[webhookID], deliveries[i])
+	}
+
+	var wg sync.WaitGroup
+
+	errs := make([]error, len(webhookIDs))
+
+	for i, webhookID := range webhookIDs {
+		if !wh.acquire(webhookID) {
+			continue
+		}
+
+		wg.Add(1)
+
+		go func(i int, webhookID string) {
+			defer wg.Done()
+			defer wh.release(webhookID)
+
+			for _, delivery := range pending[webhookID] {
+				status, err := wh.deliver(ctx, delivery.GetID())
+				if err != nil {
+					errs[i] = err
+
+					return
+				}
+
+				if status == DeliveryPending {
+					return
+				}
+			}
+		}(i, webhookID)
+	}
+
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+func deliveryDue(delivery GenericItem, now time.Time) bool {
+	if delivery["status"] != DeliveryPending {
+		return false
+	}
+
+	s, _ := delivery["nextAttemptAt"].(string)
+	due, err := time.Parse(time.RFC3339Nano, s)
+
+	return err != nil || !due.After(now)
+}
+
+// deliveryExpired reports whether delivery finished before given time.
+func deliveryExpired(delivery GenericItem, before time.Time) bool {
+	s, ok := delivery["finishedAt"].(string)
+	if !ok {
+		s, _ = delivery["queuedAt"].(string)
+	}
+
+	finished, err := time.Parse(time.RFC3339Nano, s)
+
+	return err == nil && finished.Before(before)
+}
+
+func (wh *Webhooks) acquire(webhookID string) bool {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+
+	if wh.busy[webhookID] {
+		return false
+	}
+
+	wh.busy[webhookID] = true
+
+	return true
+}
+
+func (wh *Webhooks) release(webhookID string) {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+
+	delete(wh.busy, webhookID)
+}
+
+// deliver attempts a delivery if it is due, and returns its status.
+func (wh *Webhooks) deliver(ctx context.Context, id string) (interface{}, error) {
+	delivery, err := wh.next.Read(ctx, WebhookDeliveriesGroupKind, id)
+	if err != nil {
+		if errors.As(err, &ItemNotFoundError{}) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	if !deliveryDue(delivery, time.Now()) {
+		return delivery["status"], nil
+	}
+
+	attempt := map[string]interface{}{
+		"time": time.Now().Format(time.RFC3339Nano),
+	}
+
+	webhook, err := wh.next.Read(ctx, WebhooksGroupKind, fmt.Sprint(delivery["webhook"]))
+
+	switch {
+	case errors.As(err, &ItemNotFoundError{}):
+		attempt["error"] = "webhook does not exist anymore"
+	case err != nil:
+		return nil, err
+	default:
+		start := time.Now()
+
+		statusCode, err := wh.send(ctx, webhook, delivery)
+
+		attempt["duration"] = time.Since(start).String()
+
+		if statusCode != 0 {
+			attempt["statusCode"] = statusCode
+		}
+
+		if err != nil {
+			attempt["error"] = err.Error()
+		}
+	}
+
+	var status interface{}
+
+	_, err = Update(ctx, wh.next, WebhookDeliveriesGroupKind, delivery.GetID(), func(item GenericItem) (GenericItem, error) {
+		attempts, _ := item["attempts"].([]interface{})
+		attempts = append(attempts, attempt)
+		item["attempts"] = attempts
+
+		_, failed := attempt["error"]
+
+		switch {
+		case !failed:
+			item["status"] = DeliverySucceeded
+		case len(attempts) >= wh.opts.MaxAttempts || webhook == nil:
+			item["status"] = DeliveryFailed
+		default:
+			item["nextAttemptAt"] = time.Now().Add(wh.backoff(len(attempts))).Format(time.RFC3339Nano)
+		}
+
+		if item["status"] != DeliveryPending {
+			item["finishedAt"] = time.Now().Format(time.RFC3339Nano)
+		}
+
+		status = item["status"]
+
+		return item, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch status {
+	case DeliverySucceeded:
+		atomic.AddUint64(&wh.succeeded, 1)
+	case DeliveryFailed:
+		atomic.AddUint64(&wh.failed, 1)
+	default:
+		atomic.AddUint64(&wh.retried, 1)
+	}
+
+	return status, nil
+}
+
+func (wh *Webhooks) send(ctx context.Context, webhook GenericItem, delivery GenericItem) (int, error) {
+	event, _ := delivery["event"].(map[string]interface{})
+
+	body, err := json.Marshal(map[string]interface{}{
+		"delivery": delivery.GetID(),
+		"webhook":  webhook.GetID(),
+		"event":    event,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	target, _ := webhook["url"].(string)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookIDHeader, webhook.GetID())
+	req.Header.Set(WebhookDeliveryHeader, delivery.GetID())
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+
+	secret, err := webhookSecret(ctx, wh.next, WebhooksGroupKind, webhook.GetID())
+	if err != nil {
+		return 0, err
+	}
+
+	if secret != "" {
+		req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(secret, timestamp, body))
+	}
+
+	res, err := wh.opts.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() { _ = res.Body.Close() }()
+
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 1<<20))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+
+	return res.StatusCode, nil
+}
+
+// Run dispatches deliveries as they are queued and retries them when they are due, until ctx is done.
+func (wh *Webhooks) Run(ctx context.Context) {
+	ticker := time.NewTicker(wh.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		go func() { _ = wh.Dispatch(ctx) }()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-wh.trigger:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (wh *Webhooks) Metrics() WebhookMetrics {
+	return WebhookMetrics{
+		Succeeded: atomic.LoadUint64(&wh.succeeded),
+		Failed:    atomic.LoadUint64(&wh.failed),
+		Retried:   atomic.LoadUint64(&wh.retried),
+	}
+}
+
+// NewWebhooks returns Webhooks with missing options set to 10 attempts, backing off from 1s up to 1h,
+// polling for due retries every second and keeping finished deliveries for a day.
+func NewWebhooks(next Service, opts WebhookOptions) *Webhooks {
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 10
+	}
+
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = time.Second
+	}
+
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = time.Hour
+	}
+
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+
+	if opts.Retention <= 0 {
+		opts.Retention = 24 * time.Hour
+	}
+
+	return &Webhooks{
+		next:    next,
+		opts:    opts,
+		trigger: make(chan struct{}, 1),
+		busy:    make(map[string]bool),
+	}
+}
@@ -0,0 +1,325 @@
+package core_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/applicaset/core"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var _ = Describe("Webhooks", func() {
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+
+	var (
+		svc      core.Service
+		webhooks *core.Webhooks
+		h        *core.Handler
+		receiver *httptest.Server
+
+		mu         sync.Mutex
+		requests   []received
+		statusCode int
+	)
+
+	BeforeEach(func() {
+		requests = nil
+		statusCode = http.StatusOK
+
+		receiver = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			requests = append(requests, received{header: r.Header, body: body})
+
+			w.WriteHeader(statusCode)
+		}))
+
+		svc = core.NewStore()
+		svc = core.NewAutoFields(svc)
+		svc = core.NewFinalizers(svc)
+
+		webhooks = core.NewWebhooks(svc, core.WebhookOptions{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+		svc = webhooks
+
+		h = core.NewHandler(svc)
+
+		res := doRequest(h, http.MethodPost, "/core/webhooks", `{"id":"hook1","url":"`+receiver.URL+`","secret":"s3cr3t","kinds":["acme/orders"]}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+	})
+
+	AfterEach(func() {
+		receiver.Close()
+	})
+
+	dispatch := func() {
+		err := webhooks.Dispatch(context.Background())
+		Expect(err).ShouldNot(HaveOccurred())
+	}
+
+	// deliveries returns the deliveries of webhookID, or all of them if it is empty, as clients would see them.
+	deliveries := func(webhookID string) []interface{} {
+		items, err := webhooks.Unwrap().List(context.Background(), core.WebhookDeliveriesGroupKind)
+		if errors.As(err, &core.GroupKindNotFoundError{}) {
+			return nil
+		}
+
+		Expect(err).ShouldNot(HaveOccurred())
+
+		var res []interface{}
+
+		for i := range items {
+			if webhookID == "" || items[i]["webhook"] == webhookID {
+				res = append(res, items[i])
+			}
+		}
+
+		b, err := json.Marshal(res)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		err = json.Unmarshal(b, &res)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		return res
+	}
+
+	eventIDs := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+
+		var res []string
+
+		for i := range requests {
+			var payload core.WebhookPayload
+
+			err := json.Unmarshal(requests[i].body, &payload)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			res = append(res, payload.Event.ID)
+		}
+
+		return res
+	}
+
+	It("should reject invalid webhooks", func() {
+		res := doRequest(h, http.MethodPost, "/core/webhooks", `{"id":"hook2","url":"/relative","kinds":["acme/orders"]}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+
+		res = doRequest(h, http.MethodPost, "/core/webhooks", `{"id":"hook2","url":"`+receiver.URL+`"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusUnprocessableEntity))
+	})
+
+	It("should deliver signed payloads", func() {
+		res := doRequest(h, http.MethodPost, "/acme/orders", `{"id":"order1"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		res = doRequest(h, http.MethodPost, "/acme/invoices", `{"id":"invoice1"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		dispatch()
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		Expect(requests).Should(HaveLen(1))
+
+		timestamp, err := strconv.ParseInt(requests[0].header.Get(core.WebhookTimestampHeader), 10, 64)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		signature := core.SignWebhookPayload("s3cr3t", timestamp, requests[0].body)
+		Expect(requests[0].header.Get(core.WebhookSignatureHeader)).Should(Equal(signature))
+		Expect(requests[0].header.Get(core.WebhookIDHeader)).Should(Equal("hook1"))
+
+		var payload core.WebhookPayload
+
+		err = json.Unmarshal(requests[0].body, &payload)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(payload.Webhook).Should(Equal("hook1"))
+		Expect(payload.Event.Type).Should(Equal(core.EventAdded))
+		Expect(payload.Event.GroupKind).Should(Equal("acme/orders"))
+		Expect(payload.Event.ID).Should(Equal("order1"))
+
+		items := deliveries("hook1")
+		Expect(items).Should(HaveLen(1))
+		Expect(items[0]).Should(HaveKeyWithValue("status", core.DeliverySucceeded))
+		Expect(items[0]).Should(HaveKeyWithValue("attempts", HaveLen(1)))
+	})
+
+	It("should keep secrets out of webhooks", func() {
+		res := doRequest(h, http.MethodGet, "/core/webhooks/hook1", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+		Expect(decodeBody(res)).ShouldNot(HaveKey("secret"))
+
+		res = doRequest(h, http.MethodGet, "/core/webhooks", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusOK))
+		Expect(decodeBody(res)["items"]).Should(HaveEach(Not(HaveKey("secret"))))
+
+		res = doRequest(h, http.MethodGet, "/core/webhooksecrets", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNotFound))
+
+		// webhooks written without a secret keep theirs
+		res = doRequest(h, http.MethodPut, "/core/webhooks/hook1", `{"id":"hook1","url":"`+receiver.URL+`","kinds":["acme/orders"]}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusNoContent))
+
+		res = doRequest(h, http.MethodPost, "/acme/orders", `{"id":"order1"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		dispatch()
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		Expect(requests).Should(HaveLen(1))
+
+		timestamp, err := strconv.ParseInt(requests[0].header.Get(core.WebhookTimestampHeader), 10, 64)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(requests[0].header.Get(core.WebhookSignatureHeader)).Should(Equal(core.SignWebhookPayload("s3cr3t", timestamp, requests[0].body)))
+	})
+
+	It("should not let slow webhooks hold up the others", func() {
+		release := make(chan struct{})
+
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		}))
+
+		defer slow.Close()
+		defer close(release)
+
+		res := doRequest(h, http.MethodPost, "/core/webhooks", `{"id":"hook0","url":"`+slow.URL+`","kinds":["acme/orders"]}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		res = doRequest(h, http.MethodPost, "/acme/orders", `{"id":"order1"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		go func() { _ = webhooks.Dispatch(context.Background()) }()
+
+		Eventually(func() []interface{} { return deliveries("hook1") }).Should(ContainElement(HaveKeyWithValue("status", core.DeliverySucceeded)))
+		Expect(deliveries("hook0")).Should(ContainElement(HaveKeyWithValue("status", core.DeliveryPending)))
+	})
+
+	It("should deliver chosen events only", func() {
+		res := doRequest(h, http.MethodPut, "/core/webhooks/hook1", `{"id":"hook1","url":"`+receiver.URL+`","kinds":["acme/orders"],"events":["DELETED"]}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusNoContent))
+
+		res = doRequest(h, http.MethodPost, "/acme/orders", `{"id":"order1"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		res = doRequest(h, http.MethodDelete, "/acme/orders/order1", "")
+		Expect(res.StatusCode).Should(Equal(http.StatusNoContent))
+
+		items := deliveries("")
+		Expect(items).Should(HaveLen(1))
+		Expect(items[0]).Should(HaveKeyWithValue("event", HaveKeyWithValue("type", "DELETED")))
+	})
+
+	It("should retry with backoff until attempts run out", func() {
+		statusCode = http.StatusServiceUnavailable
+
+		res := doRequest(h, http.MethodPost, "/acme/orders", `{"id":"order1"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		dispatch()
+
+		items := deliveries("")
+		Expect(items[0]).Should(HaveKeyWithValue("status", core.DeliveryPending))
+		Expect(items[0]).Should(HaveKeyWithValue("attempts", ContainElement(HaveKeyWithValue("statusCode", float64(http.StatusServiceUnavailable)))))
+
+		for i := 0; i < 2; i++ {
+			time.Sleep(10 * time.Millisecond)
+
+			dispatch()
+		}
+
+		items = deliveries("")
+		Expect(items[0]).Should(HaveKeyWithValue("status", core.DeliveryFailed))
+		Expect(items[0]).Should(HaveKeyWithValue("attempts", HaveLen(3)))
+
+		Expect(webhooks.Metrics()).Should(Equal(core.WebhookMetrics{Failed: 1, Retried: 2}))
+	})
+
+	It("should hold back later deliveries until earlier ones are done", func() {
+		statusCode = http.StatusServiceUnavailable
+
+		res := doRequest(h, http.MethodPost, "/acme/orders", `{"id":"order1"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		res = doRequest(h, http.MethodPost, "/acme/orders", `{"id":"order2"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		dispatch()
+
+		Expect(eventIDs()).Should(Equal([]string{"order1"}))
+		Expect(deliveries("")).Should(HaveEach(HaveKeyWithValue("status", core.DeliveryPending)))
+
+		mu.Lock()
+		statusCode = http.StatusOK
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		dispatch()
+
+		Expect(eventIDs()).Should(Equal([]string{"order1", "order1", "order2"}))
+		Expect(deliveries("")).Should(HaveEach(HaveKeyWithValue("status", core.DeliverySucceeded)))
+	})
+
+	It("should remove finished deliveries past retention", func() {
+		res := doRequest(h, http.MethodPost, "/acme/orders", `{"id":"order1"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		dispatch()
+
+		Expect(deliveries("")).Should(HaveLen(1))
+
+		err := core.NewWebhooks(webhooks.Unwrap(), core.WebhookOptions{Retention: time.Hour}).Dispatch(context.Background())
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(deliveries("")).Should(HaveLen(1))
+
+		time.Sleep(10 * time.Millisecond)
+
+		err = core.NewWebhooks(webhooks.Unwrap(), core.WebhookOptions{Retention: time.Millisecond}).Dispatch(context.Background())
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(deliveries("")).Should(BeEmpty())
+	})
+
+	It("should not let clients write deliveries", func() {
+		res := doRequest(h, http.MethodPost, "/core/webhookdeliveries", `{"id":"d1","webhook":"hook1","status":"pending","event":{"type":"ADDED","groupKind":"acme/orders","id":"forged"}}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusNotFound))
+
+		dispatch()
+
+		Expect(eventIDs()).Should(BeEmpty())
+	})
+
+	It("should not queue deliveries of rolled back writes or dry runs", func() {
+		err := core.Tx(context.Background(), svc, func(ctx context.Context) error {
+			err := svc.Create(ctx, "acme/orders", core.GenericItem{"id": "order1"})
+			if err != nil {
+				return err
+			}
+
+			return errors.New("rollback")
+		})
+		Expect(err).Should(HaveOccurred())
+
+		res := doRequest(h, http.MethodPost, "/acme/orders?dryRun=true", `{"id":"order2"}`)
+		Expect(res.StatusCode).Should(Equal(http.StatusCreated))
+
+		Expect(deliveries("")).Should(BeEmpty())
+	})
+})
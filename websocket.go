@@ -0,0 +1,321 @@
+package core
+
+import (
+	"context"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type BackpressurePolicy int
+
+const (
+	// BackpressureDrop drops messages for a client which does not keep up, and tells it how many it missed.
+	BackpressureDrop BackpressurePolicy = iota
+	// BackpressureDisconnect closes the connection of a client which does not keep up.
+	BackpressureDisconnect
+)
+
+type WebSocketOptions struct {
+	// BufferSize is the number of messages queued for a client before the Backpressure policy applies.
+	BufferSize   int
+	Backpressure BackpressurePolicy
+	// CheckOrigin allows cross-origin connections if it returns true. By default only same origin ones are allowed.
+	CheckOrigin func(r *http.Request) bool
+}
+
+const (
+	defaultWebSocketBufferSize = 256
+	maxWebSocketRequestSize    = 64 << 10
+	webSocketWriteTimeout      = 10 * time.Second
+)
+
+// WebSocketRequest is sent by clients to subscribe to the changes of a kind, or of an item if ID is set,
+// optionally filtered by an expression, and to unsubscribe again.
+type WebSocketRequest struct {
+	Type            string `json:"type"`
+	Subscription    string `json:"subscription"`
+	Group           string `json:"group,omitempty"`
+	Kind            string `json:"kind,omitempty"`
+	ID              string `json:"id,omitempty"`
+	Filter          string `json:"filter,omitempty"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type WebSocketMessage struct {
+	Type         string `json:"type"`
+	Subscription string `json:"subscription,omitempty"`
+	Event        *Event `json:"event,omitempty"`
+	Dropped      int    `json:"dropped,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+type wsConn struct {
+	conn    *websocket.Conn
+	watcher Watcher
+	opts    WebSocketOptions
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	out    chan WebSocketMessage
+
+	closeOnce   sync.Once
+	closeCode   int
+	closeReason string
+
+	mu   sync.Mutex
+	subs map[string]*wsSubscription
+}
+
+type wsSubscription struct {
+	cancel context.CancelFunc
+}
+
+// WebSocketHandler serves many subscriptions to changes of svc over one connection.
+func WebSocketHandler(svc Service, opts WebSocketOptions) http.HandlerFunc {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = defaultWebSocketBufferSize
+	}
+
+	upgrader := websocket.Upgrader{CheckOrigin: opts.CheckOrigin}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		watcher, ok := Lookup[Watcher](svc)
+		if !ok {
+			writeError(w, WatchNotSupportedError{})
+
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// the upgrader has already responded
+			return
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		c := &wsConn{
+			conn:      conn,
+			watcher:   watcher,
+			opts:      opts,
+			ctx:       ctx,
+			cancel:    cancel,
+			out:       make(chan WebSocketMessage, opts.BufferSize),
+			closeCode: websocket.CloseNormalClosure,
+			subs:      make(map[string]*wsSubscription),
+		}
+
+		go c.writeLoop()
+
+		c.readLoop()
+	}
+}
+
+func (c *wsConn) close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeReason = reason
+		c.cancel()
+	})
+}
+
+// send queues msg without blocking, so a slow client never holds up its subscriptions.
+func (c *wsConn) send(msg WebSocketMessage) bool {
+	select {
+	case c.out <- msg:
+		return true
+	case <-c.ctx.Done():
+		return false
+	default:
+	}
+
+	if c.opts.Backpressure == BackpressureDisconnect {
+		c.close(websocket.CloseTryAgainLater, "client is too slow")
+	}
+
+	return false
+}
+
+func (c *wsConn) readLoop() {
+	defer c.close(websocket.CloseNormalClosure, "")
+
+	c.conn.SetReadLimit(maxWebSocketRequestSize)
+
+	_ = c.conn.SetReadDeadline(time.Now().Add(2 * watchHeartbeatInterval))
+
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(2 * watchHeartbeatInterval))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var req WebSocketRequest
+
+		err = json.Unmarshal(data, &req)
+		if err != nil {
+			c.send(WebSocketMessage{Type: "error", Error: "invalid request: " + err.Error()})
+
+			continue
+		}
+
+		switch req.Type {
+		case "subscribe":
+			c.subscribe(req)
+		case "unsubscribe":
+			c.unsubscribe(req)
+		default:
+			c.send(WebSocketMessage{Type: "error", Subscription: req.Subscription, Error: "unknown request type '" + req.Type + "'"})
+		}
+	}
+}
+
+func (c *wsConn) writeLoop() {
+	ping := time.NewTicker(watchHeartbeatInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			msg := websocket.FormatCloseMessage(c.closeCode, c.closeReason)
+
+			_ = c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+			_ = c.conn.Close()
+
+			return
+		case msg := <-c.out:
+			data, err := json.Marshal(msg)
+			if err != nil {
+				continue
+			}
+
+			_ = c.conn.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout))
+
+			err = c.conn.WriteMessage(websocket.TextMessage, data)
+			if err != nil {
+				c.close(websocket.CloseAbnormalClosure, "")
+			}
+		case <-ping.C:
+			err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(webSocketWriteTimeout))
+			if err != nil {
+				c.close(websocket.CloseAbnormalClosure, "")
+			}
+		}
+	}
+}
+
+func (c *wsConn) subscribe(req WebSocketRequest) {
+	fail := func(reason string) {
+		c.send(WebSocketMessage{Type: "error", Subscription: req.Subscription, Error: reason})
+	}
+
+	if req.Subscription == "" || req.Group == "" || req.Kind == "" {
+		fail("subscription, group and kind are required")
+
+		return
+	}
+
+	if internalGroupKinds[GetGroupKind(req.Group, req.Kind)] {
+		fail(GroupKindNotFoundError{Group: req.Group, Kind: req.Kind}.Error())
+
+		return
+	}
+
+	var filter *Expr
+
+	if req.Filter != "" {
+		var err error
+
+		filter, err = ParseExpr(req.Filter)
+		if err != nil {
+			fail(err.Error())
+
+			return
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.subs[req.Subscription]; ok {
+		fail("subscription already exists")
+
+		return
+	}
+
+	ctx, cancel := context.WithCancel(c.ctx)
+
+	events, err := c.watcher.Watch(ctx, GetGroupKind(req.Group, req.Kind), req.ID, req.ResourceVersion)
+	if err != nil {
+		cancel()
+		fail(err.Error())
+
+		return
+	}
+
+	sub := &wsSubscription{cancel: cancel}
+	c.subs[req.Subscription] = sub
+
+	c.send(WebSocketMessage{Type: "subscribed", Subscription: req.Subscription})
+
+	go c.forward(ctx, req.Subscription, sub, events, filter)
+}
+
+func (c *wsConn) unsubscribe(req WebSocketRequest) {
+	c.mu.Lock()
+	sub, ok := c.subs[req.Subscription]
+	delete(c.subs, req.Subscription)
+	c.mu.Unlock()
+
+	if !ok {
+		c.send(WebSocketMessage{Type: "error", Subscription: req.Subscription, Error: "subscription does not exist"})
+
+		return
+	}
+
+	sub.cancel()
+
+	c.send(WebSocketMessage{Type: "unsubscribed", Subscription: req.Subscription})
+}
+
+func (c *wsConn) forward(ctx context.Context, subscription string, sub *wsSubscription, events <-chan Event, filter *Expr) {
+	// a watch which ends on its own, e.g. after an error event, is not a subscription anymore
+	defer func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		sub.cancel()
+
+		if c.subs[subscription] == sub {
+			delete(c.subs, subscription)
+		}
+	}()
+
+	dropped := 0
+
+	for e := range events {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if e.Type != EventError && filter != nil {
+			if ok, err := filter.Match(e.Object); err != nil || !ok {
+				continue
+			}
+		}
+
+		if dropped > 0 && c.send(WebSocketMessage{Type: "dropped", Subscription: subscription, Dropped: dropped}) {
+			dropped = 0
+		}
+
+		e := e
+		if !c.send(WebSocketMessage{Type: "event", Subscription: subscription, Event: &e}) {
+			dropped++
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package core_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/applicaset/core"
+	"github.com/gorilla/websocket"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+var _ = Describe("WebSocket", func() {
+	var (
+		svc    core.Service
+		server *httptest.Server
+		conn   *websocket.Conn
+	)
+
+	connect := func(opts core.WebSocketOptions) {
+		svc = core.NewStore()
+		svc = core.NewAutoFields(svc)
+
+		server = httptest.NewServer(core.NewHandler(svc, core.WithWebSocketOptions(opts)))
+
+		var err error
+
+		conn, _, err = websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/_ws", nil)
+		Expect(err).ShouldNot(HaveOccurred())
+	}
+
+	AfterEach(func() {
+		_ = conn.Close()
+
+		server.Close()
+	})
+
+	send := func(req core.WebSocketRequest) {
+		err := conn.WriteJSON(req)
+		Expect(err).ShouldNot(HaveOccurred())
+	}
+
+	receive := func() core.WebSocketMessage {
+		err := conn.SetReadDeadline(time.Now().Add(time.Second))
+		Expect(err).ShouldNot(HaveOccurred())
+
+		var msg core.WebSocketMessage
+
+		err = conn.ReadJSON(&msg)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		return msg
+	}
+
+	create := func(groupKind string, item core.GenericItem) {
+		err := svc.Create(context.Background(), groupKind, item)
+		Expect(err).ShouldNot(HaveOccurred())
+	}
+
+	It("should multiplex subscriptions", func() {
+		connect(core.WebSocketOptions{})
+
+		send(core.WebSocketRequest{Type: "subscribe", Subscription: "orders", Group: "acme", Kind: "orders", Filter: `total > 10`})
+		Expect(receive()).Should(Equal(core.WebSocketMessage{Type: "subscribed", Subscription: "orders"}))
+
+		send(core.WebSocketRequest{Type: "subscribe", Subscription: "item", Group: "acme", Kind: "stock", ID: "item1"})
+		Expect(receive()).Should(Equal(core.WebSocketMessage{Type: "subscribed", Subscription: "item"}))
+
+		create("acme/orders", core.GenericItem{"id": "order1", "total": 5})
+		create("acme/orders", core.GenericItem{"id": "order2", "total": 50})
+		create("acme/stock", core.GenericItem{"id": "item2"})
+		create("acme/stock", core.GenericItem{"id": "item1"})
+
+		received := make(map[string]string)
+
+		for i := 0; i < 2; i++ {
+			msg := receive()
+			Expect(msg.Type).Should(Equal("event"))
+			Expect(msg.Event.Type).Should(Equal(core.EventAdded))
+
+			received[msg.Subscription] = msg.Event.ID
+		}
+
+		Expect(received).Should(Equal(map[string]string{"orders": "order2", "item": "item1"}))
+
+		send(core.WebSocketRequest{Type: "unsubscribe", Subscription: "orders"})
+		Expect(receive()).Should(Equal(core.WebSocketMessage{Type: "unsubscribed", Subscription: "orders"}))
+
+		create("acme/orders", core.GenericItem{"id": "order3", "total": 50})
+		err := svc.Delete(context.Background(), "acme/stock", "item1")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		msg := receive()
+		Expect(msg.Subscription).Should(Equal("item"))
+		Expect(msg.Event.Type).Should(Equal(core.EventDeleted))
+	})
+
+	It("should report invalid requests", func() {
+		connect(core.WebSocketOptions{})
+
+		send(core.WebSocketRequest{Type: "subscribe", Subscription: "orders", Group: "acme", Kind: "orders", Filter: `total >`})
+		Expect(receive().Type).Should(Equal("error"))
+
+		send(core.WebSocketRequest{Type: "subscribe", Subscription: "orders"})
+		Expect(receive().Type).Should(Equal("error"))
+
+		send(core.WebSocketRequest{Type: "unsubscribe", Subscription: "orders"})
+		Expect(receive().Type).Should(Equal("error"))
+
+		send(core.WebSocketRequest{Type: "publish", Subscription: "orders"})
+		Expect(receive().Type).Should(Equal("error"))
+	})
+
+	It("should disconnect slow clients", func() {
+		connect(core.WebSocketOptions{BufferSize: 1, Backpressure: core.BackpressureDisconnect})
+
+		send(core.WebSocketRequest{Type: "subscribe", Subscription: "orders", Group: "acme", Kind: "orders"})
+		Expect(receive().Type).Should(Equal("subscribed"))
+
+		// large events fill the socket buffers while the client does not read
+		payload := strings.Repeat("x", 64<<10)
+
+		for i := 0; i < 500; i++ {
+			create("acme/orders", core.GenericItem{"id": fmt.Sprint(i), "payload": payload})
+		}
+
+		var err error
+
+		for err == nil {
+			_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+			_, _, err = conn.ReadMessage()
+		}
+
+		Expect(websocket.IsCloseError(err, websocket.CloseTryAgainLater)).Should(BeTrue(), err.Error())
+	})
+})